@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/deepmap/oapi-codegen/pkg/securityprovider"
@@ -12,6 +13,8 @@ import (
 	"github.com/go-kit/log/level"
 	"github.com/hashicorp/go-retryablehttp"
 	"github.com/pkg/errors"
+
+	"github.com/incident-io/catalog-importer/v2/metrics"
 )
 
 const maxRetries = 3
@@ -58,6 +61,26 @@ func New(ctx context.Context, apiKey, apiEndpoint, version string, logger kitlog
 	retryClient.Logger = &retryableHttpLogger{logger}
 	retryClient.RetryMax = maxRetries
 	retryClient.Backoff = attentiveBackoff
+	retryClient.RequestLogHook = func(_ retryablehttp.Logger, req *http.Request, attempt int) {
+		if attempt > 0 {
+			metrics.APIRetries.WithLabelValues(req.Method).Inc()
+		}
+	}
+
+	// Record every attempt, including retries, below the retrying client.
+	retryClient.HTTPClient.Transport = Wrap(retryClient.HTTPClient.Transport, func(req *http.Request, next http.RoundTripper) (*http.Response, error) {
+		start := time.Now()
+		resp, err := next.RoundTrip(req)
+		metrics.APIRequestDuration.WithLabelValues(req.Method).Observe(time.Since(start).Seconds())
+
+		code := "error"
+		if err == nil {
+			code = strconv.Itoa(resp.StatusCode)
+		}
+		metrics.APIRequests.WithLabelValues(req.Method, code).Inc()
+
+		return resp, err
+	})
 
 	base := retryClient.StandardClient()
 
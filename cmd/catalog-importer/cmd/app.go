@@ -37,6 +37,10 @@ var (
 	importCmd     = app.Command("import", "Import catalog data directly or generate importer config")
 	importOptions = new(ImportOptions).Bind(importCmd)
 
+	// Export
+	exportCmd     = app.Command("export", "Export existing catalog types and entries into importer config and data files")
+	exportOptions = new(ExportOptions).Bind(exportCmd)
+
 	// Types
 	typesCmd     = app.Command("types", "Shows all the types that can be used for this account")
 	typesOptions = new(TypesOptions).Bind(typesCmd)
@@ -45,6 +49,22 @@ var (
 	sync        = app.Command("sync", "Sync data from catalog sources into incident.io")
 	syncOptions = new(SyncOptions).Bind(sync)
 
+	// Serve
+	serveCmd     = app.Command("serve", "Run continuously, syncing on an interval or when triggered over HTTP")
+	serveOptions = new(ServeOptions).Bind(serveCmd)
+
+	// Plan
+	planCmd     = app.Command("plan", "Calculate the changes a sync would make and write them to a plan file")
+	planOptions = new(PlanOptions).Bind(planCmd)
+
+	// Apply
+	applyCmd     = app.Command("apply", "Apply the changes in a plan file, provided the catalog hasn't changed since")
+	applyOptions = new(ApplyOptions).Bind(applyCmd)
+
+	// Restore
+	restoreCmd     = app.Command("restore", "Restore catalog types and entries from a snapshot taken by sync --backup-dir")
+	restoreOptions = new(RestoreOptions).Bind(restoreCmd)
+
 	// Source
 	sourceCmd     = app.Command("source", "Loads and prints the catalog entries from source, for debugging")
 	sourceOptions = new(SourceOptions).Bind(sourceCmd)
@@ -94,10 +114,20 @@ func Run(ctx context.Context) (err error) {
 		return initOptions.Run(ctx, logger)
 	case importCmd.FullCommand():
 		return importOptions.Run(ctx, logger)
+	case exportCmd.FullCommand():
+		return exportOptions.Run(ctx, logger)
 	case typesCmd.FullCommand():
 		return typesOptions.Run(ctx, logger)
 	case sync.FullCommand():
 		return syncOptions.Run(ctx, logger, nil)
+	case serveCmd.FullCommand():
+		return serveOptions.Run(ctx, logger)
+	case planCmd.FullCommand():
+		return planOptions.Run(ctx, logger)
+	case applyCmd.FullCommand():
+		return applyOptions.Run(ctx, logger)
+	case restoreCmd.FullCommand():
+		return restoreOptions.Run(ctx, logger)
 	case sourceCmd.FullCommand():
 		return sourceOptions.Run(ctx, logger)
 	case jsonnetCmd.FullCommand():
@@ -202,17 +232,9 @@ func BANNER(msg string, args ...any) {
 }
 
 func DIFF[Type any](prefix string, this, that Type) {
-	thisJSON, _ := json.Marshal(this)
-	var thisNormalised any
-	json.Unmarshal(thisJSON, &thisNormalised)
-
-	thatJSON, _ := json.Marshal(that)
-	var thatNormalised any
-	json.Unmarshal(thatJSON, &thatNormalised)
-
 	var buf strings.Builder
 
-	diff := cmp.Diff(thisNormalised, thatNormalised)
+	diff := cmp.Diff(normalise(this), normalise(that))
 	if diff != "" {
 		for _, line := range strings.Split(diff, "\n") {
 			// Add the prefix, such as constant whitespace.
@@ -232,3 +254,19 @@ func DIFF[Type any](prefix string, this, that Type) {
 		OUT(strings.TrimRight(buf.String(), "\n "))
 	}
 }
+
+// sameJSON is true if both values marshal into equivalent JSON, meaning DIFF would print
+// nothing for them.
+func sameJSON[Type any](this, that Type) bool {
+	return cmp.Equal(normalise(this), normalise(that))
+}
+
+// normalise round-trips the value through JSON, so we compare values as the API would
+// see them.
+func normalise(value any) any {
+	data, _ := json.Marshal(value)
+	var normalised any
+	json.Unmarshal(data, &normalised)
+
+	return normalised
+}
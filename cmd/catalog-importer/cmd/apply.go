@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+)
+
+type ApplyOptions struct {
+	PlanFile    string
+	APIEndpoint string
+	APIKey      string
+	LockTimeout time.Duration
+	ForceUnlock bool
+}
+
+func (opt *ApplyOptions) Bind(cmd *kingpin.CmdClause) *ApplyOptions {
+	cmd.Flag("plan", "Plan file produced by the plan command (e.g. plan.json)").
+		Required().
+		StringVar(&opt.PlanFile)
+	cmd.Flag("api-endpoint", "Endpoint of the incident.io API").
+		Default("https://api.incident.io").
+		Envar("INCIDENT_ENDPOINT").
+		StringVar(&opt.APIEndpoint)
+	cmd.Flag("api-key", "API key for incident.io").
+		Envar("INCIDENT_API_KEY").
+		StringVar(&opt.APIKey)
+	cmd.Flag("lock-timeout", "How long apply holds the lock on its sync ID before another sync can assume it crashed").
+		Default("1h").
+		DurationVar(&opt.LockTimeout)
+	cmd.Flag("force-unlock", "Take the lock on the sync ID even if another sync holds it").
+		BoolVar(&opt.ForceUnlock)
+
+	return opt
+}
+
+func (opt *ApplyOptions) Run(ctx context.Context, logger kitlog.Logger) error {
+	if err := validateLockTimeout(opt.LockTimeout); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(opt.PlanFile)
+	if err != nil {
+		return errors.Wrap(err, "reading plan")
+	}
+
+	var plan reconcile.Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return errors.Wrap(err, "parsing plan")
+	}
+	OUT("✔ Loaded plan (%d catalog types, created at %s)", len(plan.Types), plan.CreatedAt.Format("2006-01-02 15:04:05"))
+
+	if plan.Version != Version() {
+		OUT("⚠ Plan was created with importer version %s, but this is version %s", plan.Version, Version())
+	}
+
+	cl, err := client.New(ctx, opt.APIKey, opt.APIEndpoint, Version(), logger)
+	if err != nil {
+		return err
+	}
+
+	result, err := cl.CatalogV2ListTypesWithResponse(ctx)
+	if err != nil {
+		return errors.Wrap(err, "listing catalog types")
+	}
+	OUT("✔ Connected to incident.io API (%s)", opt.APIEndpoint)
+
+	// Lock the sync ID before we check for drift, so no sync can change the catalog
+	// between us checking it and applying the plan.
+	managedCatalogTypes := lo.Filter(result.JSON200.CatalogTypes, func(catalogType client.CatalogTypeV2, _ int) bool {
+		return catalogType.Annotations[AnnotationSyncID] == plan.SyncID
+	})
+	lock, ctx, err := lockSyncID(ctx, logger, cl, managedCatalogTypes, plan.SyncID, syncLockOptions{
+		Timeout: opt.LockTimeout,
+		Force:   opt.ForceUnlock,
+	})
+	if err != nil {
+		return err
+	}
+	defer lock.Release(context.Background())
+
+	existingCatalogTypes := map[string]client.CatalogTypeV2{}
+	for _, catalogType := range result.JSON200.CatalogTypes {
+		existingCatalogTypes[catalogType.TypeName] = catalogType
+	}
+
+	// Before we make any changes, check the catalog is in the same state as it was when
+	// the plan was built. If it isn't, the plan could do something unexpected.
+	OUT("\n↻ Checking catalog hasn't changed since the plan was created...")
+	catalogTypesByName := map[string]*client.CatalogTypeV2{}
+	drifted := []string{}
+	for _, typePlan := range plan.Types {
+		existingCatalogType, exists := existingCatalogTypes[typePlan.TypeName]
+		if typePlan.Action == reconcile.TypeActionCreate {
+			if exists {
+				drifted = append(drifted, fmt.Sprintf("%s: type has been created since the plan", typePlan.TypeName))
+			}
+
+			continue
+		}
+
+		if !exists || existingCatalogType.Id != typePlan.CatalogTypeID {
+			drifted = append(drifted, fmt.Sprintf("%s: type no longer exists", typePlan.TypeName))
+			continue
+		}
+
+		// Types we're adopting should still belong to whoever owned them when we planned.
+		expectedSyncID := plan.SyncID
+		if typePlan.Action == reconcile.TypeActionAdopt && typePlan.Before != nil {
+			expectedSyncID = typePlan.Before.Annotations[AnnotationSyncID]
+		}
+		if syncID := existingCatalogType.Annotations[AnnotationSyncID]; syncID != expectedSyncID {
+			drifted = append(drifted, fmt.Sprintf("%s: type is now managed by a different sync ID (%s)", typePlan.TypeName, syncID))
+			continue
+		}
+
+		catalogType, entries, err := reconcile.GetEntries(ctx, cl, existingCatalogType.Id)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("listing entries for %s", typePlan.TypeName))
+		}
+		// Plans made with --continue-on-error have no fingerprint for outputs that failed,
+		// as we never reconciled their entries, so there are no entry changes to check.
+		if typePlan.Fingerprint == "" && len(typePlan.Entries) == 0 {
+			OUT("  ⚠ %s: output failed when planning, so not checking its entries", typePlan.TypeName)
+		} else if reconcile.Fingerprint(catalogType, entries) != typePlan.Fingerprint {
+			drifted = append(drifted, fmt.Sprintf("%s: schema or entries have changed since the plan", typePlan.TypeName))
+			continue
+		}
+
+		catalogTypesByName[typePlan.TypeName] = catalogType
+	}
+
+	if len(drifted) > 0 {
+		for _, reason := range drifted {
+			OUT("  ✘ %s", reason)
+		}
+
+		return fmt.Errorf("catalog has changed since the plan was created, re-run plan to build a new one:\n%s",
+			strings.Join(drifted, "\n"))
+	}
+	OUT("  ✔ Catalog matches the plan")
+
+	if !plan.HasChanges() {
+		OUT("\n✔ No changes, the catalog is up-to-date!")
+		return nil
+	}
+
+	OUT("\n↻ Creating catalog types that don't yet exist...")
+	for _, typePlan := range plan.Types {
+		if typePlan.Action != reconcile.TypeActionCreate {
+			continue
+		}
+
+		logger.Log("msg", "catalog type in plan does not exist, creating", "type_name", typePlan.TypeName)
+		createdCatalogType, err := createCatalogType(ctx, cl, typePlan.Model(), plan.SyncID, plan.SourceRepoUrl)
+		if err != nil {
+			return err
+		}
+
+		catalogTypesByName[typePlan.TypeName] = createdCatalogType
+		OUT("  ✔ %s (id=%s)", typePlan.TypeName, createdCatalogType.Id)
+	}
+
+	OUT("\n↻ Syncing catalog type schemas...")
+	models := []*output.CatalogTypeModel{}
+	for _, typePlan := range plan.Types {
+		if typePlan.Action != reconcile.TypeActionNone {
+			models = append(models, typePlan.Model())
+		}
+	}
+	if err := updateCatalogTypes(ctx, logger, cl, lock, models, catalogTypesByName, plan.SyncID, plan.SourceRepoUrl); err != nil {
+		return err
+	}
+
+	OUT("\n↻ Syncing entries...")
+	entriesClient := reconcile.EntriesClientFromClient(cl)
+	for _, typePlan := range plan.Types {
+		OUT("\n    ↻ %s", typePlan.TypeName)
+
+		catalogType := catalogTypesByName[typePlan.TypeName]
+		err := reconcile.ApplyEntries(ctx, logger, entriesClient, catalogType.Id, typePlan.Entries, newEntriesProgress(true))
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("outputs (type_name = '%s'): applying catalog entries", typePlan.TypeName))
+		}
+	}
+
+	return nil
+}
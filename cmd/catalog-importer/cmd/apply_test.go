@@ -0,0 +1,270 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/samber/lo"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+)
+
+var _ = Describe("ApplyOptions", func() {
+	var (
+		ctx         context.Context
+		api         *fakeAPI
+		cl          *client.ClientWithResponses
+		catalogType client.CatalogTypeV2
+		entry       client.CatalogEntryV2
+		opt         *ApplyOptions
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		api, cl = newFakeAPI(ctx)
+
+		catalogType = api.AddType(client.CatalogTypeV2{
+			Name:        "Service",
+			TypeName:    `Custom["Service"]`,
+			Annotations: map[string]string{AnnotationSyncID: "sync-id"},
+		})
+		entry = api.AddEntry(client.CatalogEntryV2{
+			CatalogTypeId: catalogType.Id,
+			ExternalId:    lo.ToPtr("payments"),
+			Name:          "Payments",
+		})
+
+		// Plan renaming the entry, against the catalog as it is now.
+		liveType, entries, err := reconcile.GetEntries(ctx, cl, catalogType.Id)
+		Expect(err).NotTo(HaveOccurred())
+
+		after := reconcile.EntryPayload(entry)
+		after.Name = "Payments API"
+
+		plan := reconcile.NewPlan(Version(), "sync-id", "")
+		plan.Types = []*reconcile.TypePlan{
+			{
+				Action:        reconcile.TypeActionNone,
+				TypeName:      catalogType.TypeName,
+				CatalogTypeID: catalogType.Id,
+				Name:          catalogType.Name,
+				Attributes:    []client.CatalogTypeAttributePayloadV2{},
+				Before:        liveType,
+				Fingerprint:   reconcile.Fingerprint(liveType, entries),
+				Entries: []*reconcile.EntryPlan{
+					{Action: reconcile.EntryActionUpdate, EntryID: entry.Id, ExternalID: "payments", After: &after},
+				},
+			},
+		}
+
+		data, err := json.Marshal(plan)
+		Expect(err).NotTo(HaveOccurred())
+		planFile := filepath.Join(GinkgoT().TempDir(), "plan.json")
+		Expect(os.WriteFile(planFile, data, 0o644)).To(Succeed())
+
+		opt = &ApplyOptions{
+			PlanFile:    planFile,
+			APIEndpoint: api.URL,
+			APIKey:      "api-key",
+			LockTimeout: time.Hour,
+		}
+	})
+
+	It("applies the plan if the catalog hasn't changed", func() {
+		Expect(opt.Run(ctx, kitlog.NewNopLogger())).To(Succeed())
+
+		Expect(api.Entries(catalogType.Id)).To(ConsistOf(
+			HaveField("Name", "Payments API"),
+		))
+
+		By("releasing the lock")
+		Expect(api.Type(catalogType.Id).Annotations).NotTo(HaveKey(AnnotationLockHolder))
+	})
+
+	It("refuses to apply the plan if an entry has changed", func() {
+		changed := api.AddEntry(client.CatalogEntryV2{
+			CatalogTypeId: catalogType.Id,
+			ExternalId:    lo.ToPtr("billing"),
+			Name:          "Billing",
+		})
+
+		err := opt.Run(ctx, kitlog.NewNopLogger())
+		Expect(err).To(MatchError(ContainSubstring("catalog has changed since the plan was created")))
+		Expect(err).To(MatchError(ContainSubstring("schema or entries have changed")))
+
+		Expect(api.Entries(catalogType.Id)).To(ConsistOf(
+			HaveField("Name", "Payments"),
+			HaveField("Id", changed.Id),
+		))
+	})
+
+	It("refuses to apply the plan if the type now belongs to another sync ID", func() {
+		_, err := updateTypeAnnotations(ctx, cl, catalogType, map[string]string{AnnotationSyncID: "other-sync-id"})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = opt.Run(ctx, kitlog.NewNopLogger())
+		Expect(err).To(MatchError(ContainSubstring("type is now managed by a different sync ID (other-sync-id)")))
+	})
+
+	It("refuses a lock timeout that isn't positive", func() {
+		opt.LockTimeout = 0
+
+		Expect(opt.Run(ctx, kitlog.NewNopLogger())).To(MatchError("--lock-timeout must be at least 1s"))
+		Expect(api.Entries(catalogType.Id)).To(ConsistOf(HaveField("Name", "Payments")))
+	})
+
+	It("refuses to apply the plan while another sync holds the lock", func() {
+		_, err := updateTypeAnnotations(ctx, cl, catalogType, map[string]string{
+			AnnotationSyncID:        "sync-id",
+			AnnotationLockHolder:    "someone-else",
+			AnnotationLockExpiresAt: time.Now().Add(time.Minute).UTC().Format(time.RFC3339),
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = opt.Run(ctx, kitlog.NewNopLogger())
+		Expect(err).To(MatchError(ContainSubstring("is locked by someone-else")))
+		Expect(api.Entries(catalogType.Id)).To(ConsistOf(HaveField("Name", "Payments")))
+	})
+})
+
+var _ = Describe("PlanOptions and ApplyOptions", func() {
+	var (
+		ctx        context.Context
+		api        *fakeAPI
+		configFile string
+		planOpt    *PlanOptions
+		applyOpt   *ApplyOptions
+	)
+
+	writeConfig := func(description, teams string) {
+		Expect(os.WriteFile(configFile, []byte(fmt.Sprintf(`{
+  sync_id: 'sync-id',
+  pipelines: [
+    {
+      sources: [{ inline: { entries: [{ id: 'payments', name: 'Payments', tier: 1 }] } }],
+      outputs: [{
+        name: 'Service',
+        description: '%s',
+        type_name: 'Custom["Service"]',
+        source: { name: '$.name', external_id: '$.id' },
+        attributes: [{ id: 'tier', name: 'Tier', type: 'Number', source: '$.tier' }],
+      }],
+    },
+    {
+      sources: [{ inline: { entries: %s } }],
+      outputs: [{
+        name: 'Team',
+        description: 'Teams',
+        type_name: 'Custom["Team"]',
+        source: { name: '$.name', external_id: '$.id' },
+        attributes: [{ id: 'name', name: 'Name', type: 'String', source: '$.name' }],
+      }],
+    },
+  ],
+}`, description, teams)), 0o644)).To(Succeed())
+	}
+
+	allTeams := `[{ id: 'core', name: 'Core' }]`
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		api, _ = newFakeAPI(ctx)
+
+		dir := GinkgoT().TempDir()
+		configFile = filepath.Join(dir, "importer.jsonnet")
+
+		planOpt = &PlanOptions{
+			SyncOptions: SyncOptions{
+				ConfigFile:  configFile,
+				APIEndpoint: api.URL,
+				APIKey:      "api-key",
+				LockTimeout: time.Hour,
+			},
+			OutputFile: filepath.Join(dir, "plan.json"),
+		}
+		applyOpt = &ApplyOptions{
+			PlanFile:    planOpt.OutputFile,
+			APIEndpoint: api.URL,
+			APIKey:      "api-key",
+			LockTimeout: time.Hour,
+		}
+
+		// Sync the config once, so the plan starts from a catalog that matches it.
+		writeConfig("Services", allTeams)
+		syncOpt := planOpt.SyncOptions
+		Expect(syncOpt.Run(ctx, kitlog.NewNopLogger(), nil)).To(Succeed())
+	})
+
+	typePlan := func(plan *reconcile.Plan, typeName string) *reconcile.TypePlan {
+		typePlan, ok := lo.Find(plan.Types, func(typePlan *reconcile.TypePlan) bool {
+			return typePlan.TypeName == typeName
+		})
+		Expect(ok).To(BeTrue(), "no plan for %s", typeName)
+
+		return typePlan
+	}
+
+	readPlan := func() *reconcile.Plan {
+		data, err := os.ReadFile(planOpt.OutputFile)
+		Expect(err).NotTo(HaveOccurred())
+
+		plan := &reconcile.Plan{}
+		Expect(json.Unmarshal(data, plan)).To(Succeed())
+
+		return plan
+	}
+
+	It("plans no changes if the config is unchanged", func() {
+		Expect(planOpt.Run(ctx, kitlog.NewNopLogger())).To(Succeed())
+
+		Expect(readPlan().Types).To(HaveEach(HaveField("Action", reconcile.TypeActionNone)))
+	})
+
+	It("applies a change to only the type's description", func() {
+		writeConfig("Services we run in production", allTeams)
+
+		Expect(planOpt.Run(ctx, kitlog.NewNopLogger())).To(Succeed())
+		plan := readPlan()
+		Expect(plan.Types).To(ConsistOf(
+			And(HaveField("TypeName", `Custom["Service"]`), HaveField("Action", reconcile.TypeActionUpdate)),
+			And(HaveField("TypeName", `Custom["Team"]`), HaveField("Action", reconcile.TypeActionNone)),
+		))
+
+		Expect(applyOpt.Run(ctx, kitlog.NewNopLogger())).To(Succeed())
+
+		service := typePlan(plan, `Custom["Service"]`)
+		Expect(api.Type(service.CatalogTypeID).Description).To(Equal("Services we run in production"))
+	})
+
+	When("continuing on error", func() {
+		BeforeEach(func() {
+			planOpt.ContinueOnError = true
+
+			// Teams now build no entries, which fails that output.
+			writeConfig("Services we run in production", "[]")
+		})
+
+		It("writes the plan for the outputs that succeeded, and applies it", func() {
+			Expect(planOpt.Run(ctx, kitlog.NewNopLogger())).To(MatchError(ContainSubstring("sync finished with 1 errors")))
+
+			plan := readPlan()
+			team := typePlan(plan, `Custom["Team"]`)
+			Expect(team.Fingerprint).To(BeEmpty())
+			Expect(team.Entries).To(BeEmpty())
+
+			Expect(applyOpt.Run(ctx, kitlog.NewNopLogger())).To(Succeed())
+
+			service := typePlan(plan, `Custom["Service"]`)
+			Expect(api.Type(service.CatalogTypeID).Description).To(Equal("Services we run in production"))
+			Expect(api.Entries(team.CatalogTypeID)).To(ConsistOf(HaveField("Name", "Core")))
+		})
+	})
+})
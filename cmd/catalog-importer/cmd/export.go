@@ -0,0 +1,317 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/ghodss/yaml"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/google/go-jsonnet/formatter"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/config"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+	"github.com/incident-io/catalog-importer/v2/source"
+)
+
+const (
+	ExportFormatJsonnet = "jsonnet"
+	ExportFormatYAML    = "yaml"
+)
+
+type ExportOptions struct {
+	APIEndpoint string
+	APIKey      string
+	TypeNames   []string
+	SyncID      string
+	Format      string
+	OutputDir   string
+}
+
+func (opt *ExportOptions) Bind(cmd *kingpin.CmdClause) *ExportOptions {
+	cmd.Flag("api-endpoint", "Endpoint of the incident.io API").
+		Default("https://api.incident.io").
+		Envar("INCIDENT_ENDPOINT").
+		StringVar(&opt.APIEndpoint)
+	cmd.Flag("api-key", "API key for incident.io").
+		Envar("INCIDENT_API_KEY").
+		StringVar(&opt.APIKey)
+	cmd.Flag("type-name", `Which catalog types to export (e.g. Custom["Service"])`).
+		Required().
+		StringsVar(&opt.TypeNames)
+	cmd.Flag("sync-id", "Sync ID to use in the exported config").
+		Required().
+		StringVar(&opt.SyncID)
+	cmd.Flag("format", "Format of the exported config, either jsonnet or yaml").
+		Default(ExportFormatJsonnet).
+		EnumVar(&opt.Format, ExportFormatJsonnet, ExportFormatYAML)
+	cmd.Flag("output-dir", "Directory to write the config and data files into").
+		Default(".").
+		StringVar(&opt.OutputDir)
+
+	return opt
+}
+
+func (opt *ExportOptions) Run(ctx context.Context, logger kitlog.Logger) error {
+	cl, err := client.New(ctx, opt.APIKey, opt.APIEndpoint, Version(), logger, client.WithReadOnly())
+	if err != nil {
+		return err
+	}
+
+	result, err := cl.CatalogV2ListTypesWithResponse(ctx)
+	if err != nil {
+		return errors.Wrap(err, "listing catalog types")
+	}
+	OUT("✔ Connected to incident.io API (%s)", opt.APIEndpoint)
+
+	dataDir := filepath.Join(opt.OutputDir, "data")
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return errors.Wrap(err, "creating output directory")
+	}
+
+	cfg := &config.Config{
+		SyncID:    opt.SyncID,
+		Pipelines: []*config.Pipeline{},
+	}
+
+	OUT("\n↻ Exporting catalog types...")
+	for _, typeName := range opt.TypeNames {
+		catalogType, ok := lo.Find(result.JSON200.CatalogTypes, func(catalogType client.CatalogTypeV2) bool {
+			return catalogType.TypeName == typeName
+		})
+		if !ok {
+			return fmt.Errorf("could not find catalog type with type_name='%s'", typeName)
+		}
+
+		_, entries, err := reconcile.GetEntries(ctx, cl, catalogType.Id)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("listing entries for %s", typeName))
+		}
+
+		dataFile := filepath.Join(dataDir, exportFilename(typeName))
+		data, err := json.MarshalIndent(exportEntries(catalogType, entries), "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "marshalling entries")
+		}
+		if err := os.WriteFile(dataFile, data, 0644); err != nil {
+			return errors.Wrap(err, "writing entries")
+		}
+
+		withoutExternalID := lo.CountBy(entries, func(entry client.CatalogEntryV2) bool {
+			return entry.ExternalId == nil
+		})
+		if withoutExternalID > 0 {
+			OUT("  ⚠ %s has %d entries without an external ID, which we've exported using their entry ID. "+
+				"The first sync will set this as their external ID.", typeName, withoutExternalID)
+		}
+
+		// The type already exists, so the exported config needs to adopt it from whoever
+		// manages it now.
+		outputType := exportOutput(catalogType)
+		if syncID, ok := catalogType.Annotations[AnnotationSyncID]; !ok {
+			outputType.Adopt = true
+		} else if syncID != opt.SyncID {
+			OUT("  ⚠ %s is managed by sync ID '%s', the exported config will adopt it from there", typeName, syncID)
+			outputType.AdoptFrom = []string{syncID}
+		}
+
+		cfg.Pipelines = append(cfg.Pipelines, &config.Pipeline{
+			Sources: []*source.Source{
+				{
+					Local: &source.SourceLocal{Files: []string{dataFile}},
+				},
+			},
+			Outputs: []*output.Output{
+				outputType,
+			},
+		})
+
+		OUT("  ✔ %s (%d entries, written to %s)", typeName, len(entries), dataFile)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return errors.Wrap(err, "exported config is invalid")
+	}
+
+	configFile, err := opt.writeConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	OUT("\n✔ Wrote config to %s", configFile)
+	OUT(`
+Any catalog types that your exported types reference must either be exported too,
+or already exist. Run a dry-run to check the config produces no changes:
+
+$ catalog-importer sync --config %s --dry-run
+`, configFile)
+
+	return nil
+}
+
+func (opt *ExportOptions) writeConfig(cfg *config.Config) (string, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return "", errors.Wrap(err, "marshalling config")
+	}
+
+	// Our config structs don't omit empty fields, so we strip the nulls to keep the config
+	// readable.
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return "", errors.Wrap(err, "unmarshalling config")
+	}
+	data, err = json.MarshalIndent(withoutNulls(value), "", "  ")
+	if err != nil {
+		return "", errors.Wrap(err, "marshalling config")
+	}
+
+	var configFile string
+	switch opt.Format {
+	case ExportFormatYAML:
+		configFile = filepath.Join(opt.OutputDir, "importer.yaml")
+		data, err = yaml.JSONToYAML(data)
+		if err != nil {
+			return "", errors.Wrap(err, "converting config to YAML")
+		}
+	default:
+		configFile = filepath.Join(opt.OutputDir, "importer.jsonnet")
+		formatted, err := formatter.Format(configFile, string(data), formatter.DefaultOptions())
+		if err != nil {
+			return "", errors.Wrap(err, "formatting config")
+		}
+
+		data = []byte(formatted)
+	}
+
+	if err := os.WriteFile(configFile, data, 0644); err != nil {
+		return "", errors.Wrap(err, "writing config")
+	}
+
+	return configFile, nil
+}
+
+// exportOutput builds an output that will recreate the catalog type and its schema from
+// the entries we export.
+func exportOutput(catalogType client.CatalogTypeV2) *output.Output {
+	outputType := &output.Output{
+		Name:        catalogType.Name,
+		Description: catalogType.Description,
+		TypeName:    catalogType.TypeName,
+		Ranked:      catalogType.Ranked,
+		Source: output.SourceConfig{
+			Name:       "$.name",
+			ExternalID: "$.external_id",
+			Aliases:    []string{"$.aliases"},
+		},
+		Attributes: []*output.Attribute{},
+		Categories: lo.Map(catalogType.Categories, func(category client.CatalogTypeV2Categories, _ int) string {
+			return string(category)
+		}),
+	}
+	if catalogType.Ranked {
+		outputType.Source.Rank = null.StringFrom("$.rank")
+	}
+
+	for _, attr := range catalogType.Schema.Attributes {
+		attribute := &output.Attribute{
+			ID:    attr.Id,
+			Name:  attr.Name,
+			Type:  null.StringFrom(attr.Type),
+			Array: attr.Array,
+		}
+
+		switch attr.Mode {
+		case client.CatalogTypeAttributeV2ModeBacklink:
+			attribute.BacklinkAttribute = null.StringFromPtr(attr.BacklinkAttribute)
+		case client.CatalogTypeAttributeV2ModePath:
+			attribute.Path = lo.Map(lo.FromPtr(attr.Path), func(item client.CatalogTypeAttributePathItemV2, _ int) string {
+				return item.AttributeId
+			})
+		default:
+			escapedID, _ := json.Marshal(attr.Id)
+			attribute.Source = null.StringFrom(fmt.Sprintf("$.attributes[%s]", string(escapedID)))
+		}
+
+		outputType.Attributes = append(outputType.Attributes, attribute)
+	}
+
+	return outputType
+}
+
+// exportEntries converts entries into data for the local source, keeping attribute values
+// as the literals we received from the API so a sync will produce identical values.
+func exportEntries(catalogType client.CatalogTypeV2, entries []client.CatalogEntryV2) []map[string]any {
+	results := []map[string]any{}
+	for _, entry := range entries {
+		attributes := map[string]any{}
+		for attributeID, binding := range entry.AttributeValues {
+			if binding.ArrayValue != nil {
+				attributes[attributeID] = lo.FilterMap(*binding.ArrayValue, func(value client.CatalogEntryEngineParamBindingValueV2, _ int) (string, bool) {
+					return lo.FromPtr(value.Literal), value.Literal != nil
+				})
+			} else if binding.Value != nil && binding.Value.Literal != nil {
+				attributes[attributeID] = *binding.Value.Literal
+			}
+		}
+
+		result := map[string]any{
+			"external_id": lo.FromPtrOr(entry.ExternalId, entry.Id),
+			"name":        entry.Name,
+			"aliases":     entry.Aliases,
+			"attributes":  attributes,
+		}
+		if catalogType.Ranked {
+			result["rank"] = entry.Rank
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+var (
+	exportWordBoundaryPattern = regexp.MustCompile(`([a-z0-9])([A-Z])`)
+	exportFilenamePattern     = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// exportFilename turns a type name like Custom["ServiceTier"] into service_tier.json.
+func exportFilename(typeName string) string {
+	name := strings.TrimSuffix(strings.TrimPrefix(typeName, `Custom["`), `"]`)
+	name = exportWordBoundaryPattern.ReplaceAllString(name, "${1}_${2}")
+	name = strings.Trim(exportFilenamePattern.ReplaceAllString(strings.ToLower(name), "_"), "_")
+
+	return name + ".json"
+}
+
+// withoutNulls recursively removes null values from JSON objects.
+func withoutNulls(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		result := map[string]any{}
+		for key, elem := range value {
+			if elem != nil {
+				result[key] = withoutNulls(elem)
+			}
+		}
+
+		return result
+	case []any:
+		return lo.Map(value, func(elem any, _ int) any {
+			return withoutNulls(elem)
+		})
+	default:
+		return value
+	}
+}
@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+
+	kitlog "github.com/go-kit/log"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/samber/lo"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+	"github.com/incident-io/catalog-importer/v2/source"
+)
+
+var _ = Describe("export", func() {
+	catalogType := client.CatalogTypeV2{
+		Name:        "Service",
+		Description: "Our services",
+		TypeName:    `Custom["Service"]`,
+		Ranked:      true,
+		Categories:  []client.CatalogTypeV2Categories{"service"},
+		Schema: client.CatalogTypeSchemaV2{
+			Attributes: []client.CatalogTypeAttributeV2{
+				{Id: "tier", Name: "Tier", Type: "Number"},
+				{Id: "owners", Name: "Owners", Type: `Custom["Team"]`, Array: true},
+				{Id: "dependents", Name: "Dependents", Type: `Custom["Service"]`, Array: true,
+					Mode: client.CatalogTypeAttributeV2ModeBacklink, BacklinkAttribute: lo.ToPtr("depends_on")},
+				{Id: "owner_slack", Name: "Owner Slack", Type: "SlackChannel",
+					Mode: client.CatalogTypeAttributeV2ModePath, Path: &[]client.CatalogTypeAttributePathItemV2{
+						{AttributeId: "owners"}, {AttributeId: "slack"},
+					}},
+			},
+		},
+	}
+
+	entries := []client.CatalogEntryV2{
+		{
+			Id:         "01H00000000000000000000001",
+			ExternalId: lo.ToPtr("payments"),
+			Name:       "Payments",
+			Aliases:    []string{"payments-api"},
+			Rank:       2,
+			AttributeValues: map[string]client.CatalogEntryEngineParamBindingV2{
+				"tier": {Value: &client.CatalogEntryEngineParamBindingValueV2{Literal: lo.ToPtr("1")}},
+				"owners": {ArrayValue: &[]client.CatalogEntryEngineParamBindingValueV2{
+					{Literal: lo.ToPtr("core")}, {Literal: lo.ToPtr("payments")},
+				}},
+			},
+		},
+		{
+			Id:              "01H00000000000000000000002",
+			Name:            "Billing",
+			Aliases:         []string{},
+			AttributeValues: map[string]client.CatalogEntryEngineParamBindingV2{},
+		},
+	}
+
+	Describe("exportOutput", func() {
+		It("recreates the type and its schema", func() {
+			outputType := exportOutput(catalogType)
+
+			Expect(outputType.Name).To(Equal("Service"))
+			Expect(outputType.Description).To(Equal("Our services"))
+			Expect(outputType.TypeName).To(Equal(`Custom["Service"]`))
+			Expect(outputType.Categories).To(Equal([]string{"service"}))
+			Expect(outputType.Source.ExternalID).To(Equal("$.external_id"))
+			Expect(outputType.Source.Rank).To(Equal(null.StringFrom("$.rank")))
+
+			Expect(outputType.Attributes).To(HaveLen(4))
+			Expect(outputType.Attributes[0].Source).To(Equal(null.StringFrom(`$.attributes["tier"]`)))
+			Expect(outputType.Attributes[1].Array).To(BeTrue())
+			Expect(outputType.Attributes[2].BacklinkAttribute).To(Equal(null.StringFrom("depends_on")))
+			Expect(outputType.Attributes[2].Source.Valid).To(BeFalse())
+			Expect(outputType.Attributes[3].Path).To(Equal([]string{"owners", "slack"}))
+			Expect(outputType.Attributes[3].Source.Valid).To(BeFalse())
+		})
+
+		It("leaves out the rank for unranked types", func() {
+			unranked := catalogType
+			unranked.Ranked = false
+
+			Expect(exportOutput(unranked).Source.Rank.Valid).To(BeFalse())
+		})
+	})
+
+	Describe("exportEntries", func() {
+		It("exports literals, using the entry ID if there's no external ID", func() {
+			Expect(exportEntries(catalogType, entries)).To(Equal([]map[string]any{
+				{
+					"external_id": "payments",
+					"name":        "Payments",
+					"aliases":     []string{"payments-api"},
+					"rank":        int32(2),
+					"attributes": map[string]any{
+						"tier":   "1",
+						"owners": []string{"core", "payments"},
+					},
+				},
+				{
+					"external_id": "01H00000000000000000000002",
+					"name":        "Billing",
+					"aliases":     []string{},
+					"rank":        int32(0),
+					"attributes":  map[string]any{},
+				},
+			}))
+		})
+
+		It("keeps entries without an external ID when synced", func() {
+			ctx := context.Background()
+			api, cl := newFakeAPI(ctx)
+
+			liveType := api.AddType(catalogType)
+			for _, entry := range entries {
+				entry.CatalogTypeId = liveType.Id
+				api.AddEntry(entry)
+			}
+			liveEntries := api.Entries(liveType.Id)
+
+			// Load the exported data as the local source would.
+			data, err := json.Marshal(exportEntries(liveType, liveEntries))
+			Expect(err).NotTo(HaveOccurred())
+			var sourceEntries []source.Entry
+			Expect(json.Unmarshal(data, &sourceEntries)).To(Succeed())
+
+			outputType := exportOutput(liveType)
+			outputType.Attributes = outputType.Attributes[:2] // derived attributes have no values
+			models, err := output.MarshalEntries(ctx, kitlog.NewNopLogger(), outputType, sourceEntries)
+			Expect(err).NotTo(HaveOccurred())
+
+			result := new(reconcile.EntriesResult)
+			err = reconcile.Entries(ctx, kitlog.NewNopLogger(), reconcile.EntriesClientFromClient(cl),
+				outputType, &liveType, models, nil, reconcile.WithResult(result))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(result.Created).To(BeEmpty())
+			Expect(result.Deleted).To(BeEmpty())
+			Expect(result.Updated).To(ConsistOf(liveEntries[1].Id))
+
+			synced := api.Entries(liveType.Id)
+			Expect(lo.Map(synced, func(entry client.CatalogEntryV2, _ int) string {
+				return entry.Id
+			})).To(Equal([]string{liveEntries[0].Id, liveEntries[1].Id}))
+			Expect(synced[1].ExternalId).To(Equal(lo.ToPtr(liveEntries[1].Id)))
+		})
+	})
+
+	Describe("exportFilename", func() {
+		DescribeTable("names the data file after the type",
+			func(typeName, expected string) {
+				Expect(exportFilename(typeName)).To(Equal(expected))
+			},
+			Entry("custom type", `Custom["Service"]`, "service.json"),
+			Entry("camel case", `Custom["ServiceTier"]`, "service_tier.json"),
+			Entry("acronyms and digits", `Custom["AWSAccount2"]`, "awsaccount2.json"),
+			Entry("punctuation", `Custom["Team - EMEA"]`, "team_emea.json"),
+		)
+	})
+
+	Describe("withoutNulls", func() {
+		It("removes nulls from nested objects", func() {
+			Expect(withoutNulls(map[string]any{
+				"name":     "Service",
+				"ranked":   nil,
+				"nested":   map[string]any{"keep": false, "drop": nil},
+				"list":     []any{map[string]any{"drop": nil, "keep": "yes"}, nil},
+				"number":   1.0,
+				"emptyMap": map[string]any{},
+			})).To(Equal(map[string]any{
+				"name":     "Service",
+				"nested":   map[string]any{"keep": false},
+				"list":     []any{map[string]any{"keep": "yes"}, nil},
+				"number":   1.0,
+				"emptyMap": map[string]any{},
+			}))
+		})
+	})
+})
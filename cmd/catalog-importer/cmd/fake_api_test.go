@@ -0,0 +1,319 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	stdsync "sync"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+)
+
+// fakeAPI is an in-memory version of the catalog API, enough to test the commands that
+// change the catalog.
+type fakeAPI struct {
+	URL string
+
+	mu      stdsync.Mutex
+	types   map[string]*client.CatalogTypeV2
+	entries map[string]*client.CatalogEntryV2
+	seq     int
+}
+
+// newFakeAPI starts a fake API for the duration of the test, returning a client for it.
+func newFakeAPI(ctx context.Context) (*fakeAPI, *client.ClientWithResponses) {
+	api := &fakeAPI{
+		types:   map[string]*client.CatalogTypeV2{},
+		entries: map[string]*client.CatalogEntryV2{},
+	}
+
+	server := httptest.NewServer(api)
+	DeferCleanup(server.Close)
+	api.URL = server.URL
+
+	cl, err := client.New(ctx, "api-key", server.URL, "test", kitlog.NewNopLogger())
+	Expect(err).NotTo(HaveOccurred())
+
+	return api, cl
+}
+
+// AddType adds a catalog type directly, as if someone created it elsewhere.
+func (a *fakeAPI) AddType(catalogType client.CatalogTypeV2) client.CatalogTypeV2 {
+	defer a.mu.Unlock()
+	a.mu.Lock()
+
+	catalogType.Id = a.id("T")
+	if catalogType.Annotations == nil {
+		catalogType.Annotations = map[string]string{}
+	}
+	if catalogType.Categories == nil {
+		catalogType.Categories = []client.CatalogTypeV2Categories{}
+	}
+	if catalogType.Schema.Attributes == nil {
+		catalogType.Schema.Attributes = []client.CatalogTypeAttributeV2{}
+	}
+	a.types[catalogType.Id] = &catalogType
+
+	return catalogType
+}
+
+// AddEntry adds a catalog entry directly, as if someone created it elsewhere.
+func (a *fakeAPI) AddEntry(entry client.CatalogEntryV2) client.CatalogEntryV2 {
+	defer a.mu.Unlock()
+	a.mu.Lock()
+
+	entry.Id = a.id("E")
+	if entry.Aliases == nil {
+		entry.Aliases = []string{}
+	}
+	if entry.AttributeValues == nil {
+		entry.AttributeValues = map[string]client.CatalogEntryEngineParamBindingV2{}
+	}
+	a.entries[entry.Id] = &entry
+
+	return entry
+}
+
+// Type returns a copy of the catalog type, as it is now.
+func (a *fakeAPI) Type(id string) client.CatalogTypeV2 {
+	defer a.mu.Unlock()
+	a.mu.Lock()
+
+	Expect(a.types).To(HaveKey(id))
+	return *a.types[id]
+}
+
+// Entries returns copies of the entries for a catalog type, in the order they were created.
+func (a *fakeAPI) Entries(catalogTypeID string) []client.CatalogEntryV2 {
+	defer a.mu.Unlock()
+	a.mu.Lock()
+
+	return a.listEntries(catalogTypeID, "")
+}
+
+func (a *fakeAPI) id(prefix string) string {
+	a.seq++
+	return fmt.Sprintf("%s%06d", prefix, a.seq)
+}
+
+func (a *fakeAPI) listEntries(catalogTypeID, after string) []client.CatalogEntryV2 {
+	entries := []client.CatalogEntryV2{}
+	for _, entry := range a.entries {
+		if entry.CatalogTypeId == catalogTypeID && entry.Id > after {
+			entries = append(entries, *entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Id < entries[j].Id
+	})
+
+	return entries
+}
+
+func (a *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer a.mu.Unlock()
+	a.mu.Lock()
+
+	write := func(code int, body any) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(body)
+	}
+	notFound := func() {
+		write(http.StatusNotFound, map[string]any{"type": "not_found", "status": 404})
+	}
+
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/v2/catalog_types" && r.Method == http.MethodGet:
+		catalogTypes := []client.CatalogTypeV2{}
+		for _, catalogType := range a.types {
+			catalogTypes = append(catalogTypes, *catalogType)
+		}
+		sort.Slice(catalogTypes, func(i, j int) bool {
+			return catalogTypes[i].Id < catalogTypes[j].Id
+		})
+		write(http.StatusOK, client.ListTypesResponseBody{CatalogTypes: catalogTypes})
+
+	case r.URL.Path == "/v2/catalog_types" && r.Method == http.MethodPost:
+		var body client.CreateTypeRequestBody
+		Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+		catalogType := &client.CatalogTypeV2{
+			Id:          a.id("T"),
+			Name:        body.Name,
+			Description: body.Description,
+			TypeName:    *body.TypeName,
+			Annotations: map[string]string{},
+			Categories:  []client.CatalogTypeV2Categories{},
+			Schema:      client.CatalogTypeSchemaV2{Attributes: []client.CatalogTypeAttributeV2{}},
+			CreatedAt:   time.Now(),
+			UpdatedAt:   time.Now(),
+		}
+		if body.Annotations != nil {
+			catalogType.Annotations = *body.Annotations
+		}
+		if body.Ranked != nil {
+			catalogType.Ranked = *body.Ranked
+		}
+		a.types[catalogType.Id] = catalogType
+		write(http.StatusCreated, client.CreateTypeResponseBody{CatalogType: *catalogType})
+
+	case len(segments) == 5 && segments[1] == "catalog_types" && segments[4] == "update_schema":
+		catalogType, ok := a.types[segments[2]]
+		if !ok {
+			notFound()
+			return
+		}
+		var body client.UpdateTypeSchemaRequestBody
+		Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+		if body.Version != catalogType.Schema.Version {
+			write(http.StatusUnprocessableEntity, map[string]any{"type": "validation_error", "status": 422})
+			return
+		}
+		attributes := []client.CatalogTypeAttributeV2{}
+		for _, attr := range body.Attributes {
+			attribute := client.CatalogTypeAttributeV2{
+				Id:                *attr.Id,
+				Name:              attr.Name,
+				Type:              attr.Type,
+				Array:             attr.Array,
+				BacklinkAttribute: attr.BacklinkAttribute,
+			}
+			if attr.Mode != nil {
+				attribute.Mode = client.CatalogTypeAttributeV2Mode(*attr.Mode)
+			}
+			attributes = append(attributes, attribute)
+		}
+		catalogType.Schema = client.CatalogTypeSchemaV2{Version: catalogType.Schema.Version + 1, Attributes: attributes}
+		write(http.StatusOK, client.CreateTypeResponseBody{CatalogType: *catalogType})
+
+	case len(segments) == 3 && segments[1] == "catalog_types":
+		catalogType, ok := a.types[segments[2]]
+		if !ok {
+			notFound()
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			write(http.StatusOK, client.CreateTypeResponseBody{CatalogType: *catalogType})
+		case http.MethodDelete:
+			delete(a.types, catalogType.Id)
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodPut:
+			var body client.UpdateTypeRequestBody
+			Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+			catalogType.Name, catalogType.Description = body.Name, body.Description
+			if body.Annotations != nil {
+				catalogType.Annotations = *body.Annotations
+			}
+			if body.Categories != nil {
+				catalogType.Categories = []client.CatalogTypeV2Categories{}
+				for _, category := range *body.Categories {
+					catalogType.Categories = append(catalogType.Categories, client.CatalogTypeV2Categories(category))
+				}
+			}
+			if body.Ranked != nil {
+				catalogType.Ranked = *body.Ranked
+			}
+			catalogType.UpdatedAt = time.Now()
+			write(http.StatusOK, client.CreateTypeResponseBody{CatalogType: *catalogType})
+		}
+
+	case r.URL.Path == "/v2/catalog_entries" && r.Method == http.MethodGet:
+		catalogType, ok := a.types[r.URL.Query().Get("catalog_type_id")]
+		if !ok {
+			notFound()
+			return
+		}
+		entries := a.listEntries(catalogType.Id, r.URL.Query().Get("after"))
+		pagination := client.PaginationMetaResult{PageSize: 250}
+		if len(entries) > 250 {
+			entries = entries[:250]
+			pagination.After = &entries[len(entries)-1].Id
+		}
+		write(http.StatusOK, client.ListEntriesResponseBody{
+			CatalogEntries: entries,
+			CatalogType:    *catalogType,
+			PaginationMeta: pagination,
+		})
+
+	case r.URL.Path == "/v2/catalog_entries" && r.Method == http.MethodPost:
+		var body client.CreateEntryRequestBody
+		Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+		entry := &client.CatalogEntryV2{
+			Id:              a.id("E"),
+			CatalogTypeId:   body.CatalogTypeId,
+			Name:            body.Name,
+			ExternalId:      body.ExternalId,
+			AttributeValues: fakeAttributeValues(body.AttributeValues),
+			Aliases:         []string{},
+		}
+		if body.Aliases != nil {
+			entry.Aliases = *body.Aliases
+		}
+		if body.Rank != nil {
+			entry.Rank = *body.Rank
+		}
+		a.entries[entry.Id] = entry
+		write(http.StatusCreated, client.CreateEntryResponseBody{CatalogEntry: *entry})
+
+	case len(segments) == 3 && segments[1] == "catalog_entries":
+		entry, ok := a.entries[segments[2]]
+		if !ok {
+			notFound()
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			write(http.StatusOK, map[string]any{"catalog_entry": entry, "catalog_type": a.types[entry.CatalogTypeId]})
+		case http.MethodDelete:
+			delete(a.entries, entry.Id)
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodPut:
+			var body client.UpdateEntryRequestBody
+			Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+			entry.Name, entry.ExternalId = body.Name, body.ExternalId
+			entry.AttributeValues = fakeAttributeValues(body.AttributeValues)
+			if body.Aliases != nil {
+				entry.Aliases = *body.Aliases
+			}
+			if body.Rank != nil {
+				entry.Rank = *body.Rank
+			}
+			write(http.StatusOK, client.CreateEntryResponseBody{CatalogEntry: *entry})
+		}
+
+	default:
+		notFound()
+	}
+}
+
+// fakeAttributeValues turns the attribute values we send into those the API returns.
+func fakeAttributeValues(payload map[string]client.EngineParamBindingPayloadV2) map[string]client.CatalogEntryEngineParamBindingV2 {
+	values := map[string]client.CatalogEntryEngineParamBindingV2{}
+	for attributeID, binding := range payload {
+		value := client.CatalogEntryEngineParamBindingV2{}
+		if binding.Value != nil {
+			value.Value = &client.CatalogEntryEngineParamBindingValueV2{Literal: binding.Value.Literal}
+		}
+		if binding.ArrayValue != nil {
+			arrayValue := []client.CatalogEntryEngineParamBindingValueV2{}
+			for _, element := range *binding.ArrayValue {
+				arrayValue = append(arrayValue, client.CatalogEntryEngineParamBindingValueV2{Literal: element.Literal})
+			}
+			value.ArrayValue = &arrayValue
+		}
+		values[attributeID] = value
+	}
+
+	return values
+}
@@ -0,0 +1,427 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	stdsync "sync"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+)
+
+var (
+	AnnotationLockHolder    = "incident.io/catalog-importer/lock-holder"
+	AnnotationLockExpiresAt = "incident.io/catalog-importer/lock-expires-at"
+
+	lockAnnotations = []string{AnnotationLockHolder, AnnotationLockExpiresAt}
+)
+
+// syncLock is a lease on a sync ID, which stops two importers with the same sync ID from
+// reconciling the same catalog types at the same time.
+//
+// There's nowhere in the API to store a lock for a sync ID, so we keep the lease in the
+// annotations of one of the catalog types it manages. Every importer picks the same type,
+// as they all see the same list.
+//
+// If the sync ID doesn't manage any types yet, such as on the first sync, we keep the
+// lease in a local lock file instead. That only protects against syncs on the same
+// machine, but that's the common case of a scheduled sync overlapping a manual one.
+type syncLock struct {
+	logger  kitlog.Logger
+	cl      *client.ClientWithResponses
+	syncID  string
+	holder  string
+	timeout time.Duration
+
+	catalogTypeID string // set if the lease is in a catalog type's annotations
+	file          string // set if the lease is in a local lock file
+
+	mu        stdsync.Mutex // held while writing to the catalog type with the lease
+	expiresAt time.Time
+	stop      chan struct{}
+	stopped   chan struct{}
+	cancel    context.CancelCauseFunc
+}
+
+// minLockTimeout is the shortest lease we allow. We renew the lease every third of the
+// timeout, so anything shorter would spend the sync renewing it.
+const minLockTimeout = time.Second
+
+// validateLockTimeout checks the --lock-timeout flag, before we try taking the lock.
+func validateLockTimeout(timeout time.Duration) error {
+	if timeout < minLockTimeout {
+		return fmt.Errorf("--lock-timeout must be at least %s", minLockTimeout)
+	}
+
+	return nil
+}
+
+// syncLockOptions configures how we take the lease.
+type syncLockOptions struct {
+	Timeout time.Duration // how long the lease lasts before it must be renewed
+	Force   bool          // take the lease even if someone else holds it
+	FileDir string        // where to put lock files, defaulting to the temp dir
+}
+
+// syncLease is who holds the lock on a sync ID, and until when.
+type syncLease struct {
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Held is true if the lease hasn't yet expired.
+func (l syncLease) Held(now time.Time) bool {
+	return l.Holder != "" && now.Before(l.ExpiresAt)
+}
+
+// acquireSyncLock takes the lease for the sync ID, failing if another importer holds a
+// lease that hasn't yet expired, unless force is set.
+//
+// If we store the lease on a catalog type, we return the type as it is after we updated
+// its annotations.
+func acquireSyncLock(ctx context.Context, logger kitlog.Logger, cl *client.ClientWithResponses, catalogTypes []client.CatalogTypeV2, syncID string, opts syncLockOptions) (*syncLock, *client.CatalogTypeV2, error) {
+	hostname, _ := os.Hostname()
+	lock := &syncLock{
+		logger:  logger,
+		cl:      cl,
+		syncID:  syncID,
+		holder:  fmt.Sprintf("%s/%d/%s", hostname, os.Getpid(), uuid.NewString()[:8]),
+		timeout: opts.Timeout,
+	}
+
+	if len(catalogTypes) == 0 {
+		if err := lock.acquireFile(opts); err != nil {
+			return nil, nil, err
+		}
+
+		return lock, nil, nil
+	}
+
+	lockType := lo.MinBy(catalogTypes, func(a, b client.CatalogTypeV2) bool {
+		return a.TypeName < b.TypeName
+	})
+
+	if lease := lockHeld(lockType); lease.Held(time.Now()) {
+		if err := lock.checkForce(lease, opts.Force); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	lock.expiresAt = time.Now().Add(opts.Timeout)
+	annotations := lo.Assign(lockType.Annotations, lock.annotations())
+	if _, err := updateTypeAnnotations(ctx, cl, lockType, annotations); err != nil {
+		return nil, nil, errors.Wrap(err, "acquiring sync lock")
+	}
+
+	// Another importer may have taken the lease at the same time, in which case the last
+	// write wins. Read it back to check that was us.
+	result, err := cl.CatalogV2ShowTypeWithResponse(ctx, lockType.Id)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "checking sync lock")
+	}
+	lockedType := result.JSON200.CatalogType
+	if holder := lockedType.Annotations[AnnotationLockHolder]; holder != lock.holder {
+		return nil, nil, fmt.Errorf("sync ID %s was locked by %s while we were acquiring the lock", syncID, holder)
+	}
+
+	lock.catalogTypeID = lockedType.Id
+	logger.Log("msg", "acquired sync lock", "holder", lock.holder, "catalog_type_id", lock.catalogTypeID)
+
+	return lock, &lockedType, nil
+}
+
+// checkForce errors if someone else holds the lease, unless we're forcing the unlock.
+func (l *syncLock) checkForce(lease syncLease, force bool) error {
+	if !force {
+		return fmt.Errorf("sync ID %s is locked by %s until %s, another sync may be running "+
+			"(use --force-unlock if you're sure it isn't)", l.syncID, lease.Holder, lease.ExpiresAt.Format(time.RFC3339))
+	}
+
+	l.logger.Log("msg", "forcing unlock of sync ID", "holder", lease.Holder, "expires_at", lease.ExpiresAt)
+	return nil
+}
+
+// acquireFile takes the lease using a local lock file.
+func (l *syncLock) acquireFile(opts syncLockOptions) error {
+	l.file = lockFilePath(opts.FileDir, l.syncID)
+
+	if lease, err := readLockFile(l.file); err == nil {
+		if lease.Held(time.Now()) {
+			if err := l.checkForce(lease, opts.Force); err != nil {
+				return err
+			}
+		}
+
+		// The lease has expired or we're forcing it, so remove it and try to take it. If
+		// someone else beats us to it, creating the file below will fail.
+		if err := os.Remove(l.file); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "removing expired lock file")
+		}
+	}
+
+	l.expiresAt = time.Now().Add(opts.Timeout)
+	file, err := os.OpenFile(l.file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("sync ID %s was locked by another sync while we were acquiring the lock", l.syncID)
+		}
+
+		return errors.Wrap(err, "creating lock file")
+	}
+	defer file.Close()
+
+	if err := json.NewEncoder(file).Encode(l.lease()); err != nil {
+		return errors.Wrap(err, "writing lock file")
+	}
+
+	l.logger.Log("msg", "acquired sync lock", "holder", l.holder, "file", l.file)
+	return nil
+}
+
+// lockFilePath is where we keep the lock file for a sync ID.
+func lockFilePath(dir, syncID string) string {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	// Sync IDs often contain slashes, so hash them for a safe filename.
+	key := sha256.Sum256([]byte(syncID))
+	return filepath.Join(dir, fmt.Sprintf("catalog-importer-%s.lock", hex.EncodeToString(key[:8])))
+}
+
+func readLockFile(path string) (syncLease, error) {
+	var lease syncLease
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return lease, err
+	}
+
+	// If we can't parse the file, treat it as expired so we don't get stuck.
+	_ = json.Unmarshal(data, &lease)
+	return lease, nil
+}
+
+func (l *syncLock) lease() syncLease {
+	return syncLease{Holder: l.holder, ExpiresAt: l.expiresAt}
+}
+
+func (l *syncLock) annotations() map[string]string {
+	return map[string]string{
+		AnnotationLockHolder:    l.holder,
+		AnnotationLockExpiresAt: l.expiresAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// ExpiresAt is when the lease expires, unless we renew it.
+func (l *syncLock) ExpiresAt() time.Time {
+	defer l.mu.Unlock()
+	l.mu.Lock()
+
+	return l.expiresAt
+}
+
+// UpdateType calls update with the lock annotations that the catalog type must keep,
+// which are empty unless this type holds our lease. We stop renewing the lease while
+// update runs, so the two don't overwrite each other's changes to the type.
+func (l *syncLock) UpdateType(catalogTypeID string, update func(lockAnnotations map[string]string) error) error {
+	if l == nil || l.catalogTypeID != catalogTypeID {
+		return update(map[string]string{})
+	}
+
+	defer l.mu.Unlock()
+	l.mu.Lock()
+
+	return update(l.annotations())
+}
+
+// KeepAlive renews the lease in the background until it is released, so long syncs don't
+// lose it. The returned context is cancelled if we find someone else has taken the lease.
+func (l *syncLock) KeepAlive(ctx context.Context) context.Context {
+	if l == nil {
+		return ctx
+	}
+
+	ctx, l.cancel = context.WithCancelCause(ctx)
+	l.stop, l.stopped = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(l.stopped)
+
+		ticker := time.NewTicker(l.timeout / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-l.stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := l.renew(ctx); err != nil {
+					l.logger.Log("msg", "failed to renew sync lock", "error", err)
+					if errors.Is(err, errSyncLockLost) {
+						l.cancel(err)
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return ctx
+}
+
+var errSyncLockLost = fmt.Errorf("lost the lock on the sync ID to another sync")
+
+// renew extends the lease, provided we still hold it.
+func (l *syncLock) renew(ctx context.Context) error {
+	defer l.mu.Unlock()
+	l.mu.Lock()
+
+	expiresAt := time.Now().Add(l.timeout)
+	if l.file != "" {
+		lease, err := readLockFile(l.file)
+		if err != nil || lease.Holder != l.holder {
+			return errSyncLockLost
+		}
+
+		data, err := json.Marshal(syncLease{Holder: l.holder, ExpiresAt: expiresAt})
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(l.file, data, 0o644); err != nil {
+			return errors.Wrap(err, "writing lock file")
+		}
+	} else {
+		result, err := l.cl.CatalogV2ShowTypeWithResponse(ctx, l.catalogTypeID)
+		if err != nil {
+			return errors.Wrap(err, "checking sync lock")
+		}
+		catalogType := result.JSON200.CatalogType
+		if holder := catalogType.Annotations[AnnotationLockHolder]; holder != l.holder {
+			return errors.Wrap(errSyncLockLost, holder)
+		}
+
+		annotations := lo.Assign(catalogType.Annotations, map[string]string{
+			AnnotationLockExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+		})
+		if _, err := updateTypeAnnotations(ctx, l.cl, catalogType, annotations); err != nil {
+			return errors.Wrap(err, "renewing sync lock")
+		}
+	}
+
+	l.expiresAt = expiresAt
+	l.logger.Log("msg", "renewed sync lock", "holder", l.holder, "expires_at", expiresAt)
+
+	return nil
+}
+
+// Release gives up the lease, provided we still hold it.
+func (l *syncLock) Release(ctx context.Context) {
+	if l == nil {
+		return
+	}
+	if l.stop != nil {
+		close(l.stop)
+		<-l.stopped
+		l.cancel(nil)
+		l.stop = nil
+	}
+
+	if l.file != "" {
+		if lease, err := readLockFile(l.file); err != nil || lease.Holder != l.holder {
+			l.logger.Log("msg", "sync lock is no longer ours, not releasing", "holder", lease.Holder)
+			return
+		}
+		if err := os.Remove(l.file); err != nil {
+			l.logger.Log("msg", "failed to release sync lock", "error", err)
+			return
+		}
+
+		l.logger.Log("msg", "released sync lock", "holder", l.holder)
+		return
+	}
+
+	result, err := l.cl.CatalogV2ShowTypeWithResponse(ctx, l.catalogTypeID)
+	if err != nil {
+		l.logger.Log("msg", "failed to release sync lock", "error", err)
+		return
+	}
+	catalogType := result.JSON200.CatalogType
+	if holder := catalogType.Annotations[AnnotationLockHolder]; holder != l.holder {
+		l.logger.Log("msg", "sync lock is no longer ours, not releasing", "holder", holder)
+		return
+	}
+
+	annotations := lo.OmitByKeys(catalogType.Annotations, lockAnnotations)
+	if _, err := updateTypeAnnotations(ctx, l.cl, catalogType, annotations); err != nil {
+		l.logger.Log("msg", "failed to release sync lock", "error", err)
+		return
+	}
+
+	l.logger.Log("msg", "released sync lock", "holder", l.holder)
+}
+
+// lockSyncID takes the lock for a command that is about to change the catalog, and keeps
+// it alive until released. Any catalog type we took the lease on is updated in place, so
+// later changes to it keep the lease.
+func lockSyncID(ctx context.Context, logger kitlog.Logger, cl *client.ClientWithResponses, catalogTypes []client.CatalogTypeV2, syncID string, opts syncLockOptions) (*syncLock, context.Context, error) {
+	lock, lockedCatalogType, err := acquireSyncLock(ctx, logger, cl, catalogTypes, syncID, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if lockedCatalogType != nil {
+		for idx, catalogType := range catalogTypes {
+			if catalogType.Id == lockedCatalogType.Id {
+				catalogTypes[idx] = *lockedCatalogType
+			}
+		}
+	}
+	OUT("✔ Locked sync ID %s (expires %s)", syncID, lock.ExpiresAt().UTC().Format(time.RFC3339))
+
+	return lock, lock.KeepAlive(ctx), nil
+}
+
+// lockHeld returns the lease held on this catalog type, if any.
+func lockHeld(catalogType client.CatalogTypeV2) syncLease {
+	// If we can't parse the expiry, someone has been editing annotations by hand. This
+	// leaves it zero, which treats it as expired so we don't get stuck.
+	expiresAt, _ := time.Parse(time.RFC3339, catalogType.Annotations[AnnotationLockExpiresAt])
+
+	return syncLease{
+		Holder:    catalogType.Annotations[AnnotationLockHolder],
+		ExpiresAt: expiresAt,
+	}
+}
+
+// updateTypeAnnotations sets the annotations of a catalog type, leaving the rest of the
+// type as-is.
+func updateTypeAnnotations(ctx context.Context, cl *client.ClientWithResponses, catalogType client.CatalogTypeV2, annotations map[string]string) (*client.CatalogTypeV2, error) {
+	categories := lo.Map(catalogType.Categories, func(category client.CatalogTypeV2Categories, _ int) client.UpdateTypeRequestBodyCategories {
+		return client.UpdateTypeRequestBodyCategories(category)
+	})
+
+	result, err := cl.CatalogV2UpdateTypeWithResponse(ctx, catalogType.Id, client.CatalogV2UpdateTypeJSONRequestBody{
+		Name:          catalogType.Name,
+		Description:   catalogType.Description,
+		Ranked:        &catalogType.Ranked,
+		Categories:    lo.ToPtr(categories),
+		Annotations:   lo.ToPtr(annotations),
+		SourceRepoUrl: catalogType.SourceRepoUrl,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &result.JSON200.CatalogType, nil
+}
@@ -0,0 +1,244 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+)
+
+var _ = Describe("syncLock", func() {
+	var (
+		ctx    context.Context
+		logger kitlog.Logger
+		api    *fakeAPI
+		cl     *client.ClientWithResponses
+		opts   syncLockOptions
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		logger = kitlog.NewNopLogger()
+		api, cl = newFakeAPI(ctx)
+		opts = syncLockOptions{Timeout: time.Hour, FileDir: GinkgoT().TempDir()}
+	})
+
+	Context("with catalog types", func() {
+		var catalogTypes []client.CatalogTypeV2
+
+		BeforeEach(func() {
+			catalogTypes = []client.CatalogTypeV2{
+				api.AddType(client.CatalogTypeV2{TypeName: "Custom[\"Team\"]", Annotations: map[string]string{
+					AnnotationSyncID: "sync-id",
+					"owner":          "platform",
+				}}),
+				api.AddType(client.CatalogTypeV2{TypeName: "Custom[\"Service\"]", Annotations: map[string]string{
+					AnnotationSyncID: "sync-id",
+				}}),
+			}
+		})
+
+		holdLease := func(holder string, expiresAt time.Time) {
+			_, err := updateTypeAnnotations(ctx, cl, api.Type(catalogTypes[1].Id), map[string]string{
+				AnnotationSyncID:        "sync-id",
+				AnnotationLockHolder:    holder,
+				AnnotationLockExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			catalogTypes[1] = api.Type(catalogTypes[1].Id)
+		}
+
+		It("takes the lease on the first type by name", func() {
+			lock, lockedType, err := acquireSyncLock(ctx, logger, cl, catalogTypes, "sync-id", opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lockedType.Id).To(Equal(catalogTypes[1].Id))
+
+			lease := lockHeld(api.Type(catalogTypes[1].Id))
+			Expect(lease.Holder).To(Equal(lock.holder))
+			Expect(lease.Held(time.Now())).To(BeTrue())
+
+			By("releasing it, keeping the other annotations")
+			lock.Release(ctx)
+			Expect(api.Type(catalogTypes[1].Id).Annotations).To(Equal(map[string]string{
+				AnnotationSyncID: "sync-id",
+			}))
+		})
+
+		It("refuses a lease that is held", func() {
+			holdLease("someone-else", time.Now().Add(time.Minute))
+
+			_, _, err := acquireSyncLock(ctx, logger, cl, catalogTypes, "sync-id", opts)
+			Expect(err).To(MatchError(ContainSubstring("is locked by someone-else")))
+			Expect(lockHeld(api.Type(catalogTypes[1].Id)).Holder).To(Equal("someone-else"))
+		})
+
+		It("takes a lease that has expired", func() {
+			holdLease("someone-else", time.Now().Add(-time.Minute))
+
+			lock, _, err := acquireSyncLock(ctx, logger, cl, catalogTypes, "sync-id", opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lockHeld(api.Type(catalogTypes[1].Id)).Holder).To(Equal(lock.holder))
+		})
+
+		It("takes a lease that is held when forced", func() {
+			holdLease("someone-else", time.Now().Add(time.Minute))
+
+			opts.Force = true
+			lock, _, err := acquireSyncLock(ctx, logger, cl, catalogTypes, "sync-id", opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lockHeld(api.Type(catalogTypes[1].Id)).Holder).To(Equal(lock.holder))
+		})
+
+		It("renews the lease", func() {
+			opts.Timeout = time.Minute
+			lock, _, err := acquireSyncLock(ctx, logger, cl, catalogTypes, "sync-id", opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			lock.timeout = time.Hour
+			Expect(lock.renew(ctx)).To(Succeed())
+
+			lease := lockHeld(api.Type(catalogTypes[1].Id))
+			Expect(lease.Holder).To(Equal(lock.holder))
+			Expect(lease.ExpiresAt).To(BeTemporally(">", time.Now().Add(50*time.Minute)))
+		})
+
+		It("cancels the context when someone else takes the lease", func() {
+			opts.Timeout = 30 * time.Millisecond
+			lock, _, err := acquireSyncLock(ctx, logger, cl, catalogTypes, "sync-id", opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			lockCtx := lock.KeepAlive(ctx)
+			defer lock.Release(ctx)
+
+			holdLease("someone-else", time.Now().Add(time.Minute))
+			Eventually(lockCtx.Done()).Should(BeClosed())
+			Expect(context.Cause(lockCtx)).To(MatchError(errSyncLockLost))
+
+			By("not releasing a lease that isn't ours")
+			lock.Release(ctx)
+			Expect(lockHeld(api.Type(catalogTypes[1].Id)).Holder).To(Equal("someone-else"))
+		})
+
+		It("keeps the lease when updating the type that holds it", func() {
+			lock, _, err := acquireSyncLock(ctx, logger, cl, catalogTypes, "sync-id", opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			var leased, notLeased map[string]string
+			Expect(lock.UpdateType(catalogTypes[1].Id, func(lease map[string]string) error {
+				leased = lease
+				return nil
+			})).To(Succeed())
+			Expect(lock.UpdateType(catalogTypes[0].Id, func(lease map[string]string) error {
+				notLeased = lease
+				return nil
+			})).To(Succeed())
+
+			Expect(leased).To(HaveKeyWithValue(AnnotationLockHolder, lock.holder))
+			Expect(notLeased).To(BeEmpty())
+		})
+	})
+
+	Context("without catalog types", func() {
+		holdLease := func(holder string, expiresAt time.Time) {
+			data, err := json.Marshal(syncLease{Holder: holder, ExpiresAt: expiresAt})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.WriteFile(lockFilePath(opts.FileDir, "sync-id"), data, 0o644)).To(Succeed())
+		}
+
+		It("takes the lease with a lock file", func() {
+			lock, lockedType, err := acquireSyncLock(ctx, logger, cl, nil, "sync-id", opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lockedType).To(BeNil())
+			Expect(lock.file).To(HavePrefix(opts.FileDir))
+
+			lease, err := readLockFile(lock.file)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lease.Holder).To(Equal(lock.holder))
+			Expect(lease.Held(time.Now())).To(BeTrue())
+
+			By("refusing the lease to another sync")
+			_, _, err = acquireSyncLock(ctx, logger, cl, nil, "sync-id", opts)
+			Expect(err).To(MatchError(ContainSubstring("is locked by " + lock.holder)))
+
+			By("allowing a different sync ID")
+			_, _, err = acquireSyncLock(ctx, logger, cl, nil, "other-sync-id", opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("releasing it")
+			lock.Release(ctx)
+			Expect(lock.file).NotTo(BeAnExistingFile())
+		})
+
+		It("refuses a lease that is held", func() {
+			holdLease("someone-else", time.Now().Add(time.Minute))
+
+			_, _, err := acquireSyncLock(ctx, logger, cl, nil, "sync-id", opts)
+			Expect(err).To(MatchError(ContainSubstring("is locked by someone-else")))
+		})
+
+		It("takes a lease that has expired", func() {
+			holdLease("someone-else", time.Now().Add(-time.Minute))
+
+			lock, _, err := acquireSyncLock(ctx, logger, cl, nil, "sync-id", opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			lease, err := readLockFile(lock.file)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lease.Holder).To(Equal(lock.holder))
+		})
+
+		It("takes a lease that is held when forced", func() {
+			holdLease("someone-else", time.Now().Add(time.Minute))
+
+			opts.Force = true
+			lock, _, err := acquireSyncLock(ctx, logger, cl, nil, "sync-id", opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			lease, err := readLockFile(lock.file)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lease.Holder).To(Equal(lock.holder))
+		})
+
+		It("renews the lease, until someone else takes it", func() {
+			opts.Timeout = time.Minute
+			lock, _, err := acquireSyncLock(ctx, logger, cl, nil, "sync-id", opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			lock.timeout = time.Hour
+			Expect(lock.renew(ctx)).To(Succeed())
+
+			lease, err := readLockFile(lock.file)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lease.ExpiresAt).To(BeTemporally(">", time.Now().Add(50*time.Minute)))
+
+			holdLease("someone-else", time.Now().Add(time.Minute))
+			Expect(lock.renew(ctx)).To(MatchError(errSyncLockLost))
+
+			By("not releasing a lease that isn't ours")
+			lock.Release(ctx)
+			Expect(lock.file).To(BeAnExistingFile())
+		})
+	})
+
+	DescribeTable("validateLockTimeout",
+		func(timeout time.Duration, valid bool) {
+			err := validateLockTimeout(timeout)
+			if valid {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError("--lock-timeout must be at least 1s"))
+			}
+		},
+		Entry("zero", time.Duration(0), false),
+		Entry("negative", -time.Minute, false),
+		Entry("too short to renew", 2*time.Nanosecond, false),
+		Entry("a second", time.Second, true),
+		Entry("an hour", time.Hour, true),
+	)
+})
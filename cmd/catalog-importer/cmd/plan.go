@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/alecthomas/kingpin/v2"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+)
+
+type PlanOptions struct {
+	SyncOptions
+	OutputFile string
+}
+
+func (opt *PlanOptions) Bind(cmd *kingpin.CmdClause) *PlanOptions {
+	cmd.Flag("config", "Config file in either Jsonnet, YAML or JSON (e.g. importer.jsonnet)").
+		StringVar(&opt.ConfigFile)
+	cmd.Flag("api-endpoint", "Endpoint of the incident.io API").
+		Default("https://api.incident.io").
+		Envar("INCIDENT_ENDPOINT").
+		StringVar(&opt.APIEndpoint)
+	cmd.Flag("api-key", "API key for incident.io").
+		Envar("INCIDENT_API_KEY").
+		StringVar(&opt.APIKey)
+	cmd.Flag("source-repo-url", "URL of repo where catalog is being managed").
+		Envar("SOURCE_REPO_URL").
+		StringVar(&opt.SourceRepoUrl)
+	cmd.Flag("target", `Restrict running to only these outputs (e.g. Custom["Customer"])`).
+		StringsVar(&opt.Targets)
+	cmd.Flag("sample-length", "How many character to sample when logging about invalid source entries (for --debug only)").
+		Default("256").
+		IntVar(&opt.SampleLength)
+	cmd.Flag("allow-delete-all", "Allow removing all entries from a catalog entry").
+		BoolVar(&opt.AllowDeleteAll)
+	cmd.Flag("strict", "Fail the plan if any expression errors or produces a value of the wrong type").
+		BoolVar(&opt.Strict)
+	cmd.Flag("continue-on-error", "Keep planning other entries, outputs and pipelines after an error, then report all errors at the end").
+		BoolVar(&opt.ContinueOnError)
+	cmd.Flag("ignore-delete-threshold", "Plan deleting entries even if it exceeds the configured delete_threshold").
+		BoolVar(&opt.IgnoreDeleteThreshold)
+	cmd.Flag("adopt", "Plan taking over existing catalog types in config that aren't managed by any importer").
+		BoolVar(&opt.Adopt)
+	cmd.Flag("adopt-from", "Plan taking over catalog types in config that are managed by this other sync ID").
+		StringsVar(&opt.AdoptFrom)
+	cmd.Flag("out", "Where to write the JSON plan file").
+		Default("plan.json").
+		StringVar(&opt.OutputFile)
+
+	return opt
+}
+
+func (opt *PlanOptions) Run(ctx context.Context, logger kitlog.Logger) error {
+	cfg, err := loadConfigOrError(ctx, opt.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	plan := reconcile.NewPlan(Version(), cfg.SyncID, opt.SourceRepoUrl)
+
+	// A plan is a dry-run that records everything it would have done.
+	syncOpt := opt.SyncOptions
+	syncOpt.DryRun = true
+	syncOpt.Plan = plan
+
+	// When continuing on error, we still write the plan for everything that succeeded
+	// before returning the errors.
+	var syncErrors SyncErrors
+	if err := syncOpt.Run(ctx, logger, cfg); err != nil && !errors.As(err, &syncErrors) {
+		return errors.Wrap(err, "running sync")
+	}
+
+	plan.Sort()
+
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshalling plan")
+	}
+	if err := os.WriteFile(opt.OutputFile, data, 0644); err != nil {
+		return errors.Wrap(err, "writing plan")
+	}
+
+	OUT("\n✔ Wrote plan to %s", opt.OutputFile)
+	for _, typePlan := range plan.Types {
+		OUT("  %s (type=%s, entries: %d to create, %d to update, %d to delete)",
+			typePlan.TypeName, typePlan.Action,
+			typePlan.Count(reconcile.EntryActionCreate),
+			typePlan.Count(reconcile.EntryActionUpdate),
+			typePlan.Count(reconcile.EntryActionDelete),
+		)
+	}
+	if len(syncErrors) > 0 {
+		OUT("\n⚠ The plan has no entry changes for outputs that failed, and apply will leave them as they are")
+		return errors.Wrap(syncErrors, "running sync")
+	}
+	if !plan.HasChanges() {
+		OUT("\n✔ No changes, the catalog is up-to-date!")
+	}
+
+	return nil
+}
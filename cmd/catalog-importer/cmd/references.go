@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+)
+
+// referenceResolver builds the indexes used to resolve reference attributes, from the
+// entries in the live catalog and those built by this sync.
+type referenceResolver struct {
+	cl           *client.ClientWithResponses
+	catalogTypes map[string]client.CatalogTypeV2        // by type name
+	live         map[string][]client.CatalogEntryV2     // by type name, loaded on first use
+	models       map[string][]*output.CatalogEntryModel // by type name
+}
+
+func newReferenceResolver(cl *client.ClientWithResponses, catalogTypes ...[]client.CatalogTypeV2) *referenceResolver {
+	r := &referenceResolver{
+		cl:           cl,
+		catalogTypes: map[string]client.CatalogTypeV2{},
+		live:         map[string][]client.CatalogEntryV2{},
+		models:       map[string][]*output.CatalogEntryModel{},
+	}
+	for _, types := range catalogTypes {
+		for _, catalogType := range types {
+			r.catalogTypes[catalogType.TypeName] = catalogType
+		}
+	}
+
+	return r
+}
+
+// AddModels records the entries this sync has built for a type, so other outputs can
+// refer to them before they exist in the catalog.
+func (r *referenceResolver) AddModels(typeName string, entryModels []*output.CatalogEntryModel) {
+	r.models[typeName] = entryModels
+}
+
+// Index returns an index of every entry we know of for the type.
+func (r *referenceResolver) Index(ctx context.Context, typeName string) (*output.ReferenceIndex, error) {
+	entries, ok := r.live[typeName]
+	if !ok {
+		// Types we'd have created in a dry-run don't exist, so have no entries.
+		catalogType, exists := r.catalogTypes[typeName]
+		if exists && !strings.HasPrefix(catalogType.Id, "DRY-RUN") {
+			var err error
+			_, entries, err = reconcile.GetEntries(ctx, r.cl, catalogType.Id)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		r.live[typeName] = entries
+	}
+
+	index := output.NewReferenceIndex()
+	for _, entry := range entries {
+		index.AddEntry(entry)
+	}
+	for _, model := range r.models[typeName] {
+		index.AddModel(model)
+	}
+
+	return index, nil
+}
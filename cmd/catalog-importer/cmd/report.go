@@ -0,0 +1,377 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	stdsync "sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/config"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+)
+
+// RunReport is a machine-readable summary of a sync, written with --report so tooling
+// doesn't have to scrape our terminal output.
+type RunReport struct {
+	Version         string            `json:"version"`
+	SyncID          string            `json:"sync_id"`
+	ConfigHash      string            `json:"config_hash"`
+	DryRun          bool              `json:"dry_run"`
+	Targets         []string          `json:"targets,omitempty"`
+	StartedAt       time.Time         `json:"started_at"`
+	FinishedAt      time.Time         `json:"finished_at"`
+	DurationSeconds float64           `json:"duration_seconds"`
+	Success         bool              `json:"success"`
+	Error           string            `json:"error,omitempty"`
+	Types           []*TypeReport     `json:"types"`
+	Pipelines       []*PipelineReport `json:"pipelines"`
+	Warnings        []string          `json:"warnings"`
+	Errors          []SyncErrorReport `json:"errors"`
+
+	mu stdsync.Mutex
+}
+
+// TypeReport describes a change we made to a catalog type's schema.
+type TypeReport struct {
+	TypeName string               `json:"type_name"`
+	Action   reconcile.TypeAction `json:"action"`
+}
+
+type PipelineReport struct {
+	Sources []*SourceReport `json:"sources"`
+	Outputs []*OutputReport `json:"outputs"`
+}
+
+type SourceReport struct {
+	Name            string  `json:"name"`
+	Entries         int     `json:"entries"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Error           string  `json:"error,omitempty"`
+}
+
+type OutputReport struct {
+	TypeName        string                   `json:"type_name"`
+	Enum            bool                     `json:"enum,omitempty"`
+	Skipped         string                   `json:"skipped,omitempty"` // why we didn't sync this output
+	DurationSeconds float64                  `json:"duration_seconds"`
+	Counts          OutputCounts             `json:"counts"`
+	Entries         *reconcile.EntriesResult `json:"entries"`
+	Errors          []string                 `json:"errors"`
+
+	startedAt time.Time
+}
+
+type OutputCounts struct {
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Deleted   int `json:"deleted"`
+	Unchanged int `json:"unchanged"`
+	Skipped   int `json:"skipped"`
+}
+
+// SyncErrorReport is a SyncError, as it appears in the report.
+type SyncErrorReport struct {
+	Pipeline int    `json:"pipeline"`
+	Output   string `json:"output,omitempty"`
+	Entry    string `json:"entry,omitempty"`
+	Error    string `json:"error"`
+}
+
+func NewRunReport(dryRun bool) *RunReport {
+	return &RunReport{
+		Version:   Version(),
+		DryRun:    dryRun,
+		StartedAt: time.Now(),
+		Types:     []*TypeReport{},
+		Pipelines: []*PipelineReport{},
+		Warnings:  []string{},
+		Errors:    []SyncErrorReport{},
+	}
+}
+
+// SetConfig records which config we're syncing. We hash the config as we loaded it, so
+// runs of identical config can be matched up.
+func (r *RunReport) SetConfig(cfg *config.Config) {
+	data, _ := json.Marshal(cfg)
+	hash := sha256.Sum256(data)
+
+	r.SyncID = cfg.SyncID
+	r.ConfigHash = hex.EncodeToString(hash[:])
+}
+
+func (r *RunReport) AddType(typeName string, action reconcile.TypeAction) {
+	r.Types = append(r.Types, &TypeReport{TypeName: typeName, Action: action})
+}
+
+// HasType returns true if we've already recorded a change to this type.
+func (r *RunReport) HasType(typeName string) bool {
+	_, ok := lo.Find(r.Types, func(typeReport *TypeReport) bool {
+		return typeReport.TypeName == typeName
+	})
+
+	return ok
+}
+
+func (r *RunReport) Warn(msg string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Warnings = append(r.Warnings, fmt.Sprintf(msg, args...))
+}
+
+func (r *RunReport) AddPipeline() *PipelineReport {
+	pipeline := &PipelineReport{
+		Sources: []*SourceReport{},
+		Outputs: []*OutputReport{},
+	}
+	r.Pipelines = append(r.Pipelines, pipeline)
+
+	return pipeline
+}
+
+func (p *PipelineReport) AddSource(name string) *SourceReport {
+	source := &SourceReport{Name: name}
+	p.Sources = append(p.Sources, source)
+
+	return source
+}
+
+func (p *PipelineReport) AddOutput(typeName string) *OutputReport {
+	output := &OutputReport{
+		TypeName:  typeName,
+		Entries:   new(reconcile.EntriesResult),
+		Errors:    []string{},
+		startedAt: time.Now(),
+	}
+	p.Outputs = append(p.Outputs, output)
+
+	return output
+}
+
+// AddError records an error against the output, splitting errors that relate to many
+// entries so each is listed separately.
+func (o *OutputReport) AddError(err error) {
+	var entryErrors output.EntryErrors
+	if !errors.As(err, &entryErrors) {
+		o.Errors = append(o.Errors, err.Error())
+		return
+	}
+
+	for _, entryErr := range entryErrors {
+		o.Errors = append(o.Errors, entryErr.Error())
+	}
+}
+
+// Start times the output from now until Done. We time an output in two parts, building
+// its entries and then reconciling them, as we build every output before reconciling any.
+func (o *OutputReport) Start() {
+	o.startedAt = time.Now()
+}
+
+// Done marks the output as finished, so we know how long it took.
+func (o *OutputReport) Done() {
+	if o.startedAt.IsZero() {
+		return // we've already stopped timing
+	}
+
+	o.DurationSeconds += time.Since(o.startedAt).Seconds()
+	o.startedAt = time.Time{}
+}
+
+// Finish completes the report with the result of the sync.
+func (r *RunReport) Finish(syncErrors SyncErrors, err error) {
+	r.FinishedAt = time.Now()
+	r.DurationSeconds = r.FinishedAt.Sub(r.StartedAt).Seconds()
+	r.Success = err == nil
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	for _, syncErr := range syncErrors {
+		r.Errors = append(r.Errors, SyncErrorReport{
+			Pipeline: syncErr.Pipeline,
+			Output:   syncErr.Output,
+			Entry:    syncErr.Entry,
+			Error:    syncErr.Err.Error(),
+		})
+	}
+
+	for _, pipeline := range r.Pipelines {
+		for _, output := range pipeline.Outputs {
+			entries := output.Entries
+			for _, ids := range []*[]string{&entries.Created, &entries.Updated, &entries.Deleted, &entries.Unchanged, &entries.Skipped} {
+				if *ids == nil {
+					*ids = []string{}
+				}
+				sort.Strings(*ids)
+			}
+
+			output.Counts = OutputCounts{
+				Created:   len(entries.Created),
+				Updated:   len(entries.Updated),
+				Deleted:   len(entries.Deleted),
+				Unchanged: len(entries.Unchanged),
+				Skipped:   len(entries.Skipped),
+			}
+		}
+	}
+}
+
+// WriteJSON writes the report as JSON to the given path.
+func (r *RunReport) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshalling report")
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return errors.Wrap(err, "writing report")
+	}
+
+	return nil
+}
+
+// WriteJUnit writes the report as JUnit XML, with a test suite per pipeline and a test
+// case for each source and output, so CI can show which parts of the sync failed.
+func (r *RunReport) WriteJUnit(path string) error {
+	suites := junitTestSuites{
+		Name: "catalog-importer",
+		Time: r.DurationSeconds,
+	}
+
+	for idx, pipeline := range r.Pipelines {
+		suite := junitTestSuite{
+			Name: fmt.Sprintf("pipeline %d", idx),
+		}
+
+		for _, source := range pipeline.Sources {
+			testCase := junitTestCase{
+				ClassName: suite.Name,
+				Name:      fmt.Sprintf("source: %s", source.Name),
+				Time:      source.DurationSeconds,
+			}
+			if source.Error != "" {
+				testCase.Failure = &junitFailure{Message: "failed to load source", Body: source.Error}
+			}
+
+			suite.TestCases = append(suite.TestCases, testCase)
+		}
+
+		for _, output := range pipeline.Outputs {
+			testCase := junitTestCase{
+				ClassName: suite.Name,
+				Name:      fmt.Sprintf("output: %s", output.TypeName),
+				Time:      output.DurationSeconds,
+			}
+			if output.Skipped != "" {
+				testCase.Skipped = &junitSkipped{Message: output.Skipped}
+			}
+			if len(output.Errors) > 0 {
+				testCase.Failure = &junitFailure{
+					Message: fmt.Sprintf("%d errors", len(output.Errors)),
+					Body:    strings.Join(output.Errors, "\n"),
+				}
+			}
+
+			suite.TestCases = append(suite.TestCases, testCase)
+		}
+
+		for _, testCase := range suite.TestCases {
+			suite.Tests++
+			suite.Time += testCase.Time
+			if testCase.Failure != nil {
+				suite.Failures++
+			}
+			if testCase.Skipped != nil {
+				suite.Skipped++
+			}
+		}
+
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.TestSuites = append(suites.TestSuites, suite)
+	}
+
+	data, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshalling JUnit report")
+	}
+	if err := os.WriteFile(path, append([]byte(xml.Header), data...), 0644); err != nil {
+		return errors.Wrap(err, "writing JUnit report")
+	}
+
+	return nil
+}
+
+type junitTestSuites struct {
+	XMLName    xml.Name         `xml:"testsuites"`
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Time       float64          `xml:"time,attr"`
+	TestSuites []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      float64         `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// schemaAction compares the schema we want against the catalog type as it exists, to
+// report whether we're changing it. We compare everything we'd send when updating the
+// type, other than annotations which change on every sync.
+func schemaAction(model *output.CatalogTypeModel, catalogType *client.CatalogTypeV2) reconcile.TypeAction {
+	// Rebuild the model that would leave the type as it is now, so we can compare like
+	// with like.
+	have := reconcile.TypeSnapshot{CatalogType: *catalogType}.Model()
+
+	// The API doesn't promise to return categories in the order we sent them.
+	sortedCategories := func(categories []string) []string {
+		sorted := append([]string{}, categories...)
+		sort.Strings(sorted)
+
+		return sorted
+	}
+
+	same := model.Name == have.Name &&
+		model.Description == have.Description &&
+		model.Ranked == have.Ranked &&
+		sameJSON(sortedCategories(model.Categories), sortedCategories(have.Categories)) &&
+		sameJSON(model.Attributes, have.Attributes)
+	if !same {
+		return reconcile.TypeActionUpdate
+	}
+
+	return reconcile.TypeActionNone
+}
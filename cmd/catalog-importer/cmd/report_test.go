@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/samber/lo"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+)
+
+var _ = Describe("RunReport", func() {
+	var report *RunReport
+
+	BeforeEach(func() {
+		report = NewRunReport(false)
+
+		pipeline := report.AddPipeline()
+		pipeline.AddSource("local: services.json").Entries = 3
+		pipeline.AddSource("github: acme-corp/*").Error = "listing repositories: 401 Unauthorized"
+
+		services := pipeline.AddOutput(`Custom["Service"]`)
+		services.Entries.Created = []string{"payments", "billing"}
+		services.Entries.Unchanged = []string{"search"}
+		services.AddError(output.EntryErrors{
+			&reconcile.EntryError{ExternalID: "ledger", Err: fmt.Errorf("ledger failed")},
+			&reconcile.EntryError{ExternalID: "refunds", Err: fmt.Errorf("refunds failed")},
+		})
+		services.Done()
+
+		teams := pipeline.AddOutput(`Custom["Team"]`)
+		teams.Skipped = "source failed to load"
+
+		report.AddPipeline().AddOutput(`Custom["Tier"]`).Entries.Deleted = []string{"gold"}
+	})
+
+	Describe("Finish", func() {
+		It("counts and sorts the entries for each output", func() {
+			report.Finish(SyncErrors{{Pipeline: 0, Output: `Custom["Service"]`, Entry: "ledger", Err: fmt.Errorf("ledger failed")}}, fmt.Errorf("sync failed"))
+
+			Expect(report.Success).To(BeFalse())
+			Expect(report.Error).To(Equal("sync failed"))
+			Expect(report.Errors).To(Equal([]SyncErrorReport{
+				{Pipeline: 0, Output: `Custom["Service"]`, Entry: "ledger", Error: "ledger failed"},
+			}))
+
+			services := report.Pipelines[0].Outputs[0]
+			Expect(services.Counts).To(Equal(OutputCounts{Created: 2, Unchanged: 1}))
+			Expect(services.Entries.Created).To(Equal([]string{"billing", "payments"}))
+			Expect(services.Entries.Updated).To(Equal([]string{}))
+			Expect(services.Errors).To(Equal([]string{"ledger failed", "refunds failed"}))
+
+			Expect(report.Pipelines[1].Outputs[0].Counts).To(Equal(OutputCounts{Deleted: 1}))
+		})
+	})
+
+	Describe("WriteJSON", func() {
+		It("writes the report", func() {
+			report.Finish(nil, nil)
+
+			path := filepath.Join(GinkgoT().TempDir(), "report.json")
+			Expect(report.WriteJSON(path)).To(Succeed())
+
+			data, err := os.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+
+			var written map[string]any
+			Expect(json.Unmarshal(data, &written)).To(Succeed())
+			Expect(written).To(HaveKeyWithValue("success", true))
+			Expect(written).To(HaveKeyWithValue("pipelines", HaveLen(2)))
+		})
+	})
+
+	Describe("WriteJUnit", func() {
+		It("writes a test suite per pipeline, with a test case per source and output", func() {
+			report.Finish(nil, nil)
+
+			path := filepath.Join(GinkgoT().TempDir(), "report.xml")
+			Expect(report.WriteJUnit(path)).To(Succeed())
+
+			data, err := os.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(HavePrefix(xml.Header))
+
+			var suites junitTestSuites
+			Expect(xml.Unmarshal(data, &suites)).To(Succeed())
+
+			Expect(suites.Tests).To(Equal(5))
+			Expect(suites.Failures).To(Equal(2))
+			Expect(suites.TestSuites).To(HaveLen(2))
+
+			suite := suites.TestSuites[0]
+			Expect(suite.Name).To(Equal("pipeline 0"))
+			Expect(suite.Tests).To(Equal(4))
+			Expect(suite.Failures).To(Equal(2))
+			Expect(suite.Skipped).To(Equal(1))
+			Expect(lo.Map(suite.TestCases, func(testCase junitTestCase, _ int) string {
+				return testCase.Name
+			})).To(Equal([]string{
+				"source: local: services.json",
+				"source: github: acme-corp/*",
+				`output: Custom["Service"]`,
+				`output: Custom["Team"]`,
+			}))
+
+			Expect(suite.TestCases[0].Failure).To(BeNil())
+			Expect(suite.TestCases[1].Failure).To(Equal(&junitFailure{
+				Message: "failed to load source",
+				Body:    "listing repositories: 401 Unauthorized",
+			}))
+			Expect(suite.TestCases[2].Failure).To(Equal(&junitFailure{
+				Message: "2 errors",
+				Body:    "ledger failed\nrefunds failed",
+			}))
+			Expect(suite.TestCases[3].Skipped).To(Equal(&junitSkipped{Message: "source failed to load"}))
+
+			Expect(suites.TestSuites[1].Tests).To(Equal(1))
+			Expect(suites.TestSuites[1].Failures).To(BeZero())
+		})
+	})
+})
+
+var _ = Describe("schemaAction", func() {
+	var catalogType client.CatalogTypeV2
+
+	BeforeEach(func() {
+		catalogType = client.CatalogTypeV2{
+			Name:        "Service",
+			Description: "Our services",
+			TypeName:    `Custom["Service"]`,
+			Categories:  []client.CatalogTypeV2Categories{"service", "product"},
+			Annotations: map[string]string{AnnotationLastSyncAt: "2024-01-01T00:00:00Z"},
+			Schema: client.CatalogTypeSchemaV2{
+				Attributes: []client.CatalogTypeAttributeV2{
+					{Id: "tier", Name: "Tier", Type: "Number", Mode: client.CatalogTypeAttributeV2ModeManual},
+					{Id: "dependents", Name: "Dependents", Type: `Custom["Service"]`, Array: true,
+						Mode: client.CatalogTypeAttributeV2ModeBacklink, BacklinkAttribute: lo.ToPtr("depends_on")},
+					{Id: "owner_slack", Name: "Owner Slack", Type: "SlackChannel",
+						Mode: client.CatalogTypeAttributeV2ModePath, Path: &[]client.CatalogTypeAttributePathItemV2{
+							{AttributeId: "owner", AttributeName: "Owner"}, {AttributeId: "slack", AttributeName: "Slack"},
+						}},
+				},
+			},
+		}
+	})
+
+	// The model that leaves the type as it is, which each test then changes.
+	model := func() *output.CatalogTypeModel {
+		baseModel, _ := output.MarshalType(&output.Output{
+			Name:        "Service",
+			Description: "Our services",
+			TypeName:    `Custom["Service"]`,
+			Categories:  []string{"product", "service"},
+			Attributes: []*output.Attribute{
+				{ID: "tier", Name: "Tier", Type: null.StringFrom("Number")},
+				{ID: "dependents", Name: "Dependents", Type: null.StringFrom(`Custom["Service"]`), Array: true,
+					BacklinkAttribute: null.StringFrom("depends_on")},
+				{ID: "owner_slack", Name: "Owner Slack", Type: null.StringFrom("SlackChannel"),
+					Path: []string{"owner", "slack"}},
+			},
+		})
+
+		return baseModel
+	}
+
+	It("reports no change when the schema matches", func() {
+		Expect(schemaAction(model(), &catalogType)).To(Equal(reconcile.TypeActionNone))
+	})
+
+	DescribeTable("reports an update when anything we'd send has changed",
+		func(change func(*output.CatalogTypeModel)) {
+			changed := model()
+			change(changed)
+
+			Expect(schemaAction(changed, &catalogType)).To(Equal(reconcile.TypeActionUpdate))
+		},
+		Entry("name", func(model *output.CatalogTypeModel) {
+			model.Name = "Services"
+		}),
+		Entry("ranked", func(model *output.CatalogTypeModel) {
+			model.Ranked = true
+		}),
+		Entry("categories", func(model *output.CatalogTypeModel) {
+			model.Categories = []string{"service"}
+		}),
+		Entry("attribute mode", func(model *output.CatalogTypeModel) {
+			model.Attributes[0].Mode = lo.ToPtr(client.CatalogTypeAttributePayloadV2ModeExternal)
+		}),
+		Entry("backlink attribute", func(model *output.CatalogTypeModel) {
+			model.Attributes[1].BacklinkAttribute = lo.ToPtr("owned_by")
+		}),
+		Entry("path", func(model *output.CatalogTypeModel) {
+			model.Attributes[2].Path = &[]client.CatalogTypeAttributePathItemPayloadV2{{AttributeId: "owner"}}
+		}),
+	)
+})
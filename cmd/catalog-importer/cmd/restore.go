@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+)
+
+type RestoreOptions struct {
+	SnapshotFile string
+	APIEndpoint  string
+	APIKey       string
+	Targets      []string
+	DryRun       bool
+	LockTimeout  time.Duration
+	ForceUnlock  bool
+}
+
+func (opt *RestoreOptions) Bind(cmd *kingpin.CmdClause) *RestoreOptions {
+	cmd.Flag("snapshot", "Snapshot file written by sync --backup-dir").
+		Required().
+		StringVar(&opt.SnapshotFile)
+	cmd.Flag("api-endpoint", "Endpoint of the incident.io API").
+		Default("https://api.incident.io").
+		Envar("INCIDENT_ENDPOINT").
+		StringVar(&opt.APIEndpoint)
+	cmd.Flag("api-key", "API key for incident.io").
+		Envar("INCIDENT_API_KEY").
+		StringVar(&opt.APIKey)
+	cmd.Flag("target", `Restrict restoring to only these catalog types (e.g. Custom["Customer"])`).
+		StringsVar(&opt.Targets)
+	cmd.Flag("dry-run", "Only calculate the changes needed and print the diff, don't actually make changes").
+		Default("false").
+		BoolVar(&opt.DryRun)
+	cmd.Flag("lock-timeout", "How long the restore holds the lock on its sync ID before another sync can assume it crashed").
+		Default("1h").
+		DurationVar(&opt.LockTimeout)
+	cmd.Flag("force-unlock", "Take the lock on the sync ID even if another sync holds it").
+		BoolVar(&opt.ForceUnlock)
+
+	return opt
+}
+
+func (opt *RestoreOptions) Run(ctx context.Context, logger kitlog.Logger) error {
+	if !opt.DryRun {
+		if err := validateLockTimeout(opt.LockTimeout); err != nil {
+			return err
+		}
+	}
+
+	snapshot, err := readSnapshot(opt.SnapshotFile)
+	if err != nil {
+		return err
+	}
+	OUT("✔ Loaded snapshot of %d catalog types for sync ID %s (taken at %s)",
+		len(snapshot.Types), snapshot.SyncID, snapshot.CreatedAt.Format("2006-01-02 15:04:05"))
+
+	if len(opt.Targets) > 0 {
+		OUT("⊕ Filtering snapshot to targets (%s)", strings.Join(opt.Targets, ", "))
+		snapshot.Types = lo.Filter(snapshot.Types, func(typeSnapshot *reconcile.TypeSnapshot, _ int) bool {
+			return lo.Contains(opt.Targets, typeSnapshot.CatalogType.TypeName)
+		})
+	}
+
+	clientOptions := []client.ClientOption{}
+	if opt.DryRun {
+		OUT("⛨ --dry-run is set, building a read-only client")
+		clientOptions = append(clientOptions, client.WithReadOnly())
+	}
+
+	cl, err := client.New(ctx, opt.APIKey, opt.APIEndpoint, Version(), logger, clientOptions...)
+	if err != nil {
+		return err
+	}
+
+	result, err := cl.CatalogV2ListTypesWithResponse(ctx)
+	if err != nil {
+		return errors.Wrap(err, "listing catalog types")
+	}
+	OUT("✔ Connected to incident.io API (%s)", opt.APIEndpoint)
+
+	existingCatalogTypes := []client.CatalogTypeV2{}
+	for _, catalogType := range result.JSON200.CatalogTypes {
+		if catalogType.Annotations[AnnotationSyncID] == snapshot.SyncID {
+			existingCatalogTypes = append(existingCatalogTypes, catalogType)
+		}
+	}
+
+	// We don't want a sync for this sync ID to run while we're restoring.
+	var lock *syncLock
+	if !opt.DryRun {
+		lock, ctx, err = lockSyncID(ctx, logger, cl, existingCatalogTypes, snapshot.SyncID, syncLockOptions{
+			Timeout: opt.LockTimeout,
+			Force:   opt.ForceUnlock,
+		})
+		if err != nil {
+			return err
+		}
+		defer lock.Release(context.Background())
+	}
+
+	// Any type from the snapshot that has since been removed is created again, though it
+	// will have a different ID.
+	OUT("\n↻ Creating catalog types that no longer exist...")
+	catalogTypesByName := map[string]*client.CatalogTypeV2{}
+	for _, typeSnapshot := range snapshot.Types {
+		model := typeSnapshot.Model()
+
+		existingCatalogType, ok := lo.Find(existingCatalogTypes, func(catalogType client.CatalogTypeV2) bool {
+			return catalogType.TypeName == model.TypeName
+		})
+		if ok {
+			catalogTypesByName[model.TypeName] = &existingCatalogType
+			continue
+		}
+
+		var createdCatalogType *client.CatalogTypeV2
+		if opt.DryRun {
+			createdCatalogType = &client.CatalogTypeV2{
+				Id:          fmt.Sprintf("DRY-RUN-%s", model.TypeName),
+				Name:        model.Name,
+				Description: model.Description,
+				TypeName:    model.TypeName,
+			}
+		} else {
+			createdCatalogType, err = createCatalogType(ctx, cl, model, snapshot.SyncID, snapshot.SourceRepoUrl)
+			if err != nil {
+				return err
+			}
+		}
+
+		existingCatalogTypes = append(existingCatalogTypes, *createdCatalogType)
+		catalogTypesByName[model.TypeName] = createdCatalogType
+		OUT("  ✔ %s (id=%s)", model.TypeName, createdCatalogType.Id)
+	}
+
+	OUT("\n↻ Restoring catalog type schemas...")
+	if opt.DryRun {
+		for _, typeSnapshot := range snapshot.Types {
+			catalogType := catalogTypesByName[typeSnapshot.CatalogType.TypeName]
+			OUT("  ✔ %s (id=%s)", catalogType.TypeName, catalogType.Id)
+			DIFF("  ", catalogType.Schema.Attributes, typeSnapshot.CatalogType.Schema.Attributes)
+		}
+	} else {
+		models := lo.Map(snapshot.Types, func(typeSnapshot *reconcile.TypeSnapshot, _ int) *output.CatalogTypeModel {
+			return typeSnapshot.Model()
+		})
+		err := updateCatalogTypes(ctx, logger, cl, lock, models, catalogTypesByName, snapshot.SyncID, snapshot.SourceRepoUrl)
+		if err != nil {
+			return err
+		}
+	}
+
+	OUT("\n↻ Restoring entries...")
+	entriesClient := newEntriesClient(cl, existingCatalogTypes, opt.DryRun)
+	for _, typeSnapshot := range snapshot.Types {
+		catalogType := catalogTypesByName[typeSnapshot.CatalogType.TypeName]
+		OUT("\n    ↻ %s", catalogType.TypeName)
+
+		entryModels, withoutExternalID := typeSnapshot.EntryModels()
+		if len(withoutExternalID) > 0 {
+			OUT("      ⚠ Skipping %d entries in the snapshot without an external ID, which can't be restored:", len(withoutExternalID))
+			for _, entry := range withoutExternalID {
+				OUT("        - %s (id=%s)", entry.Name, entry.Id)
+			}
+		}
+
+		// The snapshot is the desired state of the whole type, so we don't need any config
+		// from an output beyond the type name.
+		//
+		// We can't tell whether an entry without an external ID was in the snapshot, so we
+		// leave those alone rather than delete something we can't restore.
+		outputType := &output.Output{TypeName: catalogType.TypeName}
+		result := new(reconcile.EntriesResult)
+		err := reconcile.Entries(ctx, logger, entriesClient, outputType, catalogType, entryModels, newEntriesProgress(!opt.DryRun),
+			reconcile.WithKeepUnidentified(), reconcile.WithResult(result))
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("restoring entries for %s", catalogType.TypeName))
+		}
+
+		if len(result.Skipped) > 0 {
+			sort.Strings(result.Skipped)
+			OUT("      ⚠ Kept %d entries without an external ID, which restore won't delete: %s",
+				len(result.Skipped), strings.Join(result.Skipped, ", "))
+		}
+	}
+
+	OUT("\n✔ Restored %d catalog types from %s", len(snapshot.Types), opt.SnapshotFile)
+
+	return nil
+}
+
+var snapshotFilenamePattern = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// writeSnapshot writes the snapshot into the directory, named after its sync ID and
+// when it was taken so backups sort in order.
+func writeSnapshot(dir string, snapshot *reconcile.Snapshot) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrap(err, "creating backup directory")
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return "", errors.Wrap(err, "marshalling snapshot")
+	}
+
+	filename := fmt.Sprintf("%s-%s.json",
+		snapshotFilenamePattern.ReplaceAllString(snapshot.SyncID, "-"),
+		snapshot.CreatedAt.UTC().Format("20060102T150405Z"))
+	path := filepath.Join(dir, filename)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", errors.Wrap(err, "writing snapshot")
+	}
+
+	return path, nil
+}
+
+func readSnapshot(path string) (*reconcile.Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading snapshot")
+	}
+
+	var snapshot reconcile.Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, errors.Wrap(err, "parsing snapshot")
+	}
+
+	return &snapshot, nil
+}
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/samber/lo"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+)
+
+var _ = Describe("RestoreOptions", func() {
+	var (
+		ctx          context.Context
+		api          *fakeAPI
+		cl           *client.ClientWithResponses
+		catalogType  client.CatalogTypeV2
+		entries      map[string]client.CatalogEntryV2
+		snapshotFile string
+		opt          *RestoreOptions
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		api, cl = newFakeAPI(ctx)
+
+		catalogType = api.AddType(client.CatalogTypeV2{
+			Name:        "Service",
+			TypeName:    `Custom["Service"]`,
+			Annotations: map[string]string{AnnotationSyncID: "sync-id"},
+			Schema: client.CatalogTypeSchemaV2{
+				Attributes: []client.CatalogTypeAttributeV2{
+					{Id: "tier", Name: "Tier", Type: "Number", Mode: client.CatalogTypeAttributeV2ModeManual},
+				},
+			},
+		})
+
+		entries = map[string]client.CatalogEntryV2{}
+		for _, entry := range []client.CatalogEntryV2{
+			{ExternalId: lo.ToPtr("payments"), Name: "Payments", AttributeValues: map[string]client.CatalogEntryEngineParamBindingV2{
+				"tier": {Value: &client.CatalogEntryEngineParamBindingValueV2{Literal: lo.ToPtr("1")}},
+			}},
+			{ExternalId: lo.ToPtr("billing"), Name: "Billing"},
+			{Name: "Built in the dashboard"},
+		} {
+			entry.CatalogTypeId = catalogType.Id
+			entries[entry.Name] = api.AddEntry(entry)
+		}
+
+		snapshot, err := reconcile.TakeSnapshot(ctx, cl, Version(), "sync-id", "", []client.CatalogTypeV2{catalogType})
+		Expect(err).NotTo(HaveOccurred())
+		snapshotFile, err = writeSnapshot(GinkgoT().TempDir(), snapshot)
+		Expect(err).NotTo(HaveOccurred())
+
+		opt = &RestoreOptions{
+			SnapshotFile: snapshotFile,
+			APIEndpoint:  api.URL,
+			APIKey:       "api-key",
+			LockTimeout:  time.Hour,
+		}
+	})
+
+	// breakCatalog makes the kind of changes a bad sync would.
+	breakCatalog := func() {
+		payments := entries["Payments"]
+		_, err := cl.CatalogV2UpdateEntryWithResponse(ctx, payments.Id, client.UpdateEntryRequestBody{
+			Name:            "Broken",
+			ExternalId:      payments.ExternalId,
+			AttributeValues: map[string]client.EngineParamBindingPayloadV2{},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = cl.CatalogV2DestroyEntryWithResponse(ctx, entries["Billing"].Id)
+		Expect(err).NotTo(HaveOccurred())
+
+		api.AddEntry(client.CatalogEntryV2{CatalogTypeId: catalogType.Id, ExternalId: lo.ToPtr("junk"), Name: "Junk"})
+		api.AddEntry(client.CatalogEntryV2{CatalogTypeId: catalogType.Id, Name: "Also built in the dashboard"})
+	}
+
+	names := func(catalogTypeID string) []string {
+		return lo.Map(api.Entries(catalogTypeID), func(entry client.CatalogEntryV2, _ int) string {
+			return entry.Name
+		})
+	}
+
+	It("restores the entries from the snapshot", func() {
+		breakCatalog()
+
+		Expect(opt.Run(ctx, kitlog.NewNopLogger())).To(Succeed())
+
+		restored := api.Entries(catalogType.Id)
+		Expect(restored).To(ContainElement(And(
+			HaveField("Id", entries["Payments"].Id),
+			HaveField("Name", "Payments"),
+			HaveField("AttributeValues", entries["Payments"].AttributeValues),
+		)))
+		Expect(restored).To(ContainElement(And(
+			HaveField("ExternalId", lo.ToPtr("billing")),
+			HaveField("Name", "Billing"),
+		)))
+
+		By("leaving entries without an external ID alone")
+		Expect(names(catalogType.Id)).To(ConsistOf(
+			"Payments", "Billing", "Built in the dashboard", "Also built in the dashboard",
+		))
+
+		By("restoring the schema")
+		Expect(api.Type(catalogType.Id).Schema.Attributes).To(Equal(catalogType.Schema.Attributes))
+
+		By("releasing the lock")
+		Expect(api.Type(catalogType.Id).Annotations).NotTo(HaveKey(AnnotationLockHolder))
+	})
+
+	It("recreates a type that has been removed", func() {
+		_, err := cl.CatalogV2DestroyTypeWithResponse(ctx, catalogType.Id)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(opt.Run(ctx, kitlog.NewNopLogger())).To(Succeed())
+
+		result, err := cl.CatalogV2ListTypesWithResponse(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.JSON200.CatalogTypes).To(HaveLen(1))
+
+		recreated := result.JSON200.CatalogTypes[0]
+		Expect(recreated.TypeName).To(Equal(catalogType.TypeName))
+		Expect(recreated.Annotations).To(HaveKeyWithValue(AnnotationSyncID, "sync-id"))
+		Expect(recreated.Schema.Attributes).To(Equal(catalogType.Schema.Attributes))
+
+		// We can't restore the entry without an external ID.
+		Expect(names(recreated.Id)).To(ConsistOf("Payments", "Billing"))
+	})
+
+	It("changes nothing in a dry-run", func() {
+		breakCatalog()
+		before := api.Entries(catalogType.Id)
+
+		opt.DryRun = true
+		Expect(opt.Run(ctx, kitlog.NewNopLogger())).To(Succeed())
+
+		Expect(api.Entries(catalogType.Id)).To(Equal(before))
+	})
+})
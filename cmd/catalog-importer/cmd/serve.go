@@ -0,0 +1,308 @@
+package cmd
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	stdsync "sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/samber/lo"
+
+	"github.com/incident-io/catalog-importer/v2/config"
+	"github.com/incident-io/catalog-importer/v2/metrics"
+)
+
+type ServeOptions struct {
+	SyncOptions
+	ListenAddress string
+	Interval      time.Duration
+	ConfigTTL     time.Duration
+	SyncSecret    string
+}
+
+func (opt *ServeOptions) Bind(cmd *kingpin.CmdClause) *ServeOptions {
+	opt.SyncOptions.Bind(cmd)
+
+	cmd.Flag("listen-address", "Address to serve health checks and the sync endpoint on").
+		Default(":8080").
+		StringVar(&opt.ListenAddress)
+	cmd.Flag("interval", "How often to run a full sync").
+		Default("1h").
+		DurationVar(&opt.Interval)
+	cmd.Flag("config-ttl", "How long to cache config before reloading it from disk").
+		Default("1m").
+		DurationVar(&opt.ConfigTTL)
+	cmd.Flag("sync-secret", "If set, POST /sync requires this as a bearer token or GitHub webhook secret").
+		Envar("SYNC_SECRET").
+		StringVar(&opt.SyncSecret)
+
+	return opt
+}
+
+func (opt *ServeOptions) Run(ctx context.Context, logger kitlog.Logger) error {
+	if opt.ConfigFile == "" {
+		return errors.New("No config file set! (--config)")
+	}
+	if opt.Interval <= 0 {
+		return errors.New("--interval must be positive")
+	}
+	if !opt.DryRun {
+		if err := validateLockTimeout(opt.LockTimeout); err != nil {
+			return err
+		}
+	}
+
+	loader := config.NewCachedLoader(logger, config.FileLoader(opt.ConfigFile), opt.ConfigTTL)
+
+	// Check the config is valid before we start, so a bad deploy fails fast.
+	if _, err := opt.loadConfig(ctx, loader); err != nil {
+		return err
+	}
+
+	syncer := newServeSyncer(logger, func(ctx context.Context, targets []string) error {
+		cfg, err := opt.loadConfig(ctx, loader)
+		if err != nil {
+			return err
+		}
+
+		syncOpt := opt.SyncOptions
+		syncOpt.Targets = targets
+		if len(targets) > 0 {
+			syncOpt.Prune = false // we can only prune when syncing everything
+		}
+
+		return syncOpt.Run(ctx, logger, cfg)
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "ok")
+	})
+	mux.Handle("/metrics", promhttp.HandlerFor(
+		prometheus.Gatherers{metrics.Registry, prometheus.DefaultGatherer}, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		status := syncer.Status()
+
+		// We're ready once we've finished our first sync, whatever the result.
+		code := http.StatusOK
+		if status.LastFinishedAt == nil {
+			code = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(status)
+	})
+	mux.HandleFunc("/sync", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, 25<<20)) // GitHub caps payloads at 25MB
+		if err != nil {
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		if opt.SyncSecret != "" && !authorizeSync(r, body, opt.SyncSecret) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// Targets are taken from the query string, as webhooks like GitHub's send their
+		// own payload in the body.
+		targets := r.URL.Query()["target"]
+		syncer.Trigger(targets)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]any{
+			"status":  "queued",
+			"targets": targets,
+		})
+	})
+
+	server := &http.Server{
+		Addr:              opt.ListenAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		OUT("✔ Listening on %s (interval=%s)", opt.ListenAddress, opt.Interval)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	syncerDone := make(chan struct{})
+	go func() {
+		defer close(syncerDone)
+		syncer.Run(ctx, opt.Interval)
+	}()
+
+	select {
+	case <-ctx.Done():
+	case err := <-serverErr:
+		return errors.Wrap(err, "serving HTTP")
+	}
+
+	OUT("\n↻ Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Log("msg", "failed to shutdown HTTP server", "error", err)
+	}
+
+	<-syncerDone
+
+	return nil
+}
+
+// loadConfig loads and validates config through the cached loader.
+func (opt *ServeOptions) loadConfig(ctx context.Context, loader config.Loader) (*config.Config, error) {
+	cfg, err := loader.Load(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading config")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, errors.Wrap(err, "validating config")
+	}
+
+	return cfg, nil
+}
+
+// authorizeSync accepts either a bearer token, or a GitHub webhook signature over the
+// request body.
+func authorizeSync(r *http.Request, body []byte, secret string) bool {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	}
+
+	if signature, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256="); ok {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+
+		expected := hex.EncodeToString(mac.Sum(nil))
+		return hmac.Equal([]byte(signature), []byte(expected))
+	}
+
+	return false
+}
+
+// serveSyncer runs syncs one at a time. Requests that arrive while a sync is running are
+// merged into a single pending sync, which runs as soon as the current one finishes.
+type serveSyncer struct {
+	logger kitlog.Logger
+	run    func(ctx context.Context, targets []string) error
+	wake   chan struct{}
+
+	mu      stdsync.Mutex
+	pending *serveSyncRequest
+	status  ServeSyncStatus
+}
+
+type serveSyncRequest struct {
+	targets []string // empty means sync everything
+}
+
+// ServeSyncStatus is reported by the readiness endpoint.
+type ServeSyncStatus struct {
+	Syncing        bool       `json:"syncing"`
+	LastStartedAt  *time.Time `json:"last_started_at,omitempty"`
+	LastFinishedAt *time.Time `json:"last_finished_at,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+func newServeSyncer(logger kitlog.Logger, run func(ctx context.Context, targets []string) error) *serveSyncer {
+	return &serveSyncer{
+		logger: logger,
+		run:    run,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Trigger queues a sync of the given targets, or everything if none are given.
+func (s *serveSyncer) Trigger(targets []string) {
+	s.mu.Lock()
+	switch {
+	case s.pending == nil:
+		s.pending = &serveSyncRequest{targets: targets}
+	case len(s.pending.targets) == 0 || len(targets) == 0:
+		s.pending.targets = nil
+	default:
+		s.pending.targets = lo.Uniq(append(s.pending.targets, targets...))
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default: // already woken
+	}
+}
+
+func (s *serveSyncer) Status() ServeSyncStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.status
+}
+
+// Run syncs everything immediately and then on every interval, along with any syncs that
+// are triggered, until the context is cancelled.
+func (s *serveSyncer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	s.Trigger(nil)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Trigger(nil)
+		case <-s.wake:
+			s.mu.Lock()
+			req := s.pending
+			s.pending = nil
+			if req != nil {
+				s.status.Syncing = true
+				s.status.LastStartedAt = lo.ToPtr(time.Now())
+			}
+			s.mu.Unlock()
+
+			if req == nil {
+				continue
+			}
+
+			s.logger.Log("msg", "starting sync", "targets", strings.Join(req.targets, ","))
+			err := s.run(ctx, req.targets)
+			if err != nil {
+				OUT("\n✘ Sync failed: %s", err)
+			}
+
+			s.mu.Lock()
+			s.status.Syncing = false
+			s.status.LastFinishedAt = lo.ToPtr(time.Now())
+			s.status.LastError = ""
+			if err != nil {
+				s.status.LastError = err.Error()
+			}
+			s.mu.Unlock()
+		}
+	}
+}
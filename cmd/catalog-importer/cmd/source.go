@@ -8,6 +8,7 @@ import (
 	"github.com/alecthomas/kingpin/v2"
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/incident-io/catalog-importer/v2/source"
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
 	"gopkg.in/yaml.v2"
@@ -42,6 +43,8 @@ func (opt *SourceOptions) Run(ctx context.Context, logger kitlog.Logger) error {
 
 		// Load entries from source
 		{
+			joinEntries := map[string][]source.Entry{}
+
 			OUT("\n  ↻ Loading data from sources...")
 			for _, source := range pipeline.Sources {
 				sourceLabel := lo.Must(source.Backend()).String()
@@ -65,17 +68,42 @@ func (opt *SourceOptions) Run(ctx context.Context, logger kitlog.Logger) error {
 						)
 					}
 
-					for _, entry := range parsedEntries {
-						data, err := yaml.Marshal(entry)
-						if err != nil {
-							return errors.Wrap(err, "marshaling YAML")
-						}
+					// Entries that are joined are printed once we've joined them.
+					if pipeline.Join.Includes(source.Name) {
+						joinEntries[source.Name] = append(joinEntries[source.Name], parsedEntries...)
+						continue
+					}
 
-						OUT("---\n" + string(data))
+					if err := printEntries(parsedEntries); err != nil {
+						return err
 					}
 				}
 			}
+
+			if pipeline.Join != nil {
+				joinedEntries, err := pipeline.Join.Apply(ctx, logger, joinEntries, nil)
+				if err != nil {
+					return errors.Wrap(err, "joining sources")
+				}
+
+				if err := printEntries(joinedEntries); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func printEntries(entries []source.Entry) error {
+	for _, entry := range entries {
+		data, err := yaml.Marshal(entry)
+		if err != nil {
+			return errors.Wrap(err, "marshaling YAML")
 		}
+
+		OUT("---\n" + string(data))
 	}
 
 	return nil
@@ -17,21 +17,37 @@ import (
 
 	"github.com/incident-io/catalog-importer/v2/client"
 	"github.com/incident-io/catalog-importer/v2/config"
+	"github.com/incident-io/catalog-importer/v2/metrics"
 	"github.com/incident-io/catalog-importer/v2/output"
 	"github.com/incident-io/catalog-importer/v2/reconcile"
 	"github.com/incident-io/catalog-importer/v2/source"
 )
 
 type SyncOptions struct {
-	ConfigFile     string
-	APIEndpoint    string
-	APIKey         string
-	Targets        []string
-	SampleLength   int
-	DryRun         bool
-	Prune          bool
-	AllowDeleteAll bool
-	SourceRepoUrl  string
+	ConfigFile            string
+	APIEndpoint           string
+	APIKey                string
+	Targets               []string
+	SampleLength          int
+	DryRun                bool
+	Prune                 bool
+	AllowDeleteAll        bool
+	SourceRepoUrl         string
+	Strict                bool
+	ContinueOnError       bool
+	IgnoreDeleteThreshold bool
+	Adopt                 bool
+	AdoptFrom             []string
+	LockTimeout           time.Duration
+	ForceUnlock           bool
+	BackupDir             string
+	MetricsTextfile       string
+	ReportFile            string
+	JUnitReportFile       string
+
+	// Plan, if set, records every change the sync would make. This is only valid with
+	// DryRun, and is how we build plans for the plan command.
+	Plan *reconcile.Plan
 }
 
 func (opt *SyncOptions) Bind(cmd *kingpin.CmdClause) *SyncOptions {
@@ -59,17 +75,59 @@ func (opt *SyncOptions) Bind(cmd *kingpin.CmdClause) *SyncOptions {
 		BoolVar(&opt.Prune)
 	cmd.Flag("allow-delete-all", "Allow removing all entries from a catalog entry").
 		BoolVar(&opt.AllowDeleteAll)
+	cmd.Flag("strict", "Fail the sync if any expression errors or produces a value of the wrong type").
+		BoolVar(&opt.Strict)
+	cmd.Flag("continue-on-error", "Keep syncing other entries, outputs and pipelines after an error, then report all errors at the end").
+		BoolVar(&opt.ContinueOnError)
+	cmd.Flag("ignore-delete-threshold", "Delete entries even if it exceeds the configured delete_threshold").
+		BoolVar(&opt.IgnoreDeleteThreshold)
+	cmd.Flag("adopt", "Take over existing catalog types in config that aren't managed by any importer").
+		BoolVar(&opt.Adopt)
+	cmd.Flag("adopt-from", "Take over catalog types in config that are managed by this other sync ID").
+		StringsVar(&opt.AdoptFrom)
+	cmd.Flag("lock-timeout", "How long a sync holds the lock on its sync ID before another sync can assume it crashed").
+		Default("1h").
+		DurationVar(&opt.LockTimeout)
+	cmd.Flag("force-unlock", "Take the lock on the sync ID even if another sync holds it").
+		BoolVar(&opt.ForceUnlock)
+	cmd.Flag("backup-dir", "Before making changes, write a snapshot of every catalog type for this sync ID into this directory").
+		StringVar(&opt.BackupDir)
+	cmd.Flag("metrics-textfile", "Write Prometheus metrics to this file after the sync (e.g. for the node exporter textfile collector)").
+		StringVar(&opt.MetricsTextfile)
+	cmd.Flag("report", "Write a JSON report summarising the sync to this file (e.g. report.json)").
+		StringVar(&opt.ReportFile)
+	cmd.Flag("report-junit", "Write a JUnit XML report with a test case per source and output to this file").
+		StringVar(&opt.JUnitReportFile)
 
 	return opt
 }
 
-func (opt *SyncOptions) Run(ctx context.Context, logger kitlog.Logger, cfg *config.Config) error {
+func (opt *SyncOptions) Run(ctx context.Context, logger kitlog.Logger, cfg *config.Config) (err error) {
+	start := time.Now()
+
+	// When continuing on error, we collect errors as we go and report them at the end.
+	syncErrors := SyncErrors{}
+
+	report := NewRunReport(opt.DryRun)
+	defer func() {
+		opt.recordMetrics(logger, start, err)
+		opt.writeReports(logger, report, syncErrors, err)
+	}()
+
 	if opt.Prune && opt.DryRun {
 		return errors.New("cannot use --dry-run with --prune")
 	}
 	if opt.Prune && len(opt.Targets) > 0 {
 		return errors.New("cannot use --targets with --prune")
 	}
+	if opt.Plan != nil && !opt.DryRun {
+		return errors.New("can only record a plan when running a dry-run")
+	}
+	if !opt.DryRun {
+		if err := validateLockTimeout(opt.LockTimeout); err != nil {
+			return err
+		}
+	}
 
 	// Load config if it hasn't been provided.
 	if cfg == nil {
@@ -80,7 +138,9 @@ func (opt *SyncOptions) Run(ctx context.Context, logger kitlog.Logger, cfg *conf
 		}
 	}
 	{
+		report.SetConfig(cfg)
 		if len(opt.Targets) > 0 {
+			report.Targets = opt.Targets
 			OUT("⊕ Filtering config to targets (%s)", strings.Join(opt.Targets, ", "))
 			cfg = cfg.Filter(opt.Targets)
 		}
@@ -90,6 +150,32 @@ func (opt *SyncOptions) Run(ctx context.Context, logger kitlog.Logger, cfg *conf
 			sources += len(pipeline.Sources)
 		}
 		OUT("✔ Loaded config (%d pipelines, %d sources, %d outputs)", len(cfg.Pipelines), outputs, sources)
+
+		// Strict mode can be enabled globally, in which case it applies to every output.
+		if opt.Strict || cfg.Strict {
+			OUT("⛨ Strict mode enabled, expression errors will fail the sync")
+			for _, outputType := range cfg.Outputs() {
+				outputType.Strict = true
+			}
+		}
+
+		// Outputs without their own delete threshold inherit the global one.
+		if cfg.DeleteThreshold != nil {
+			for _, outputType := range cfg.Outputs() {
+				if outputType.DeleteThreshold == nil {
+					outputType.DeleteThreshold = cfg.DeleteThreshold
+				}
+			}
+		}
+
+		// Adoption can be enabled from the command line, in which case it applies to every
+		// output.
+		if opt.Adopt || len(opt.AdoptFrom) > 0 {
+			for _, outputType := range cfg.Outputs() {
+				outputType.Adopt = outputType.Adopt || opt.Adopt
+				outputType.AdoptFrom = lo.Uniq(append(outputType.AdoptFrom, opt.AdoptFrom...))
+			}
+		}
 	}
 
 	clientOptions := []client.ClientOption{}
@@ -136,6 +222,35 @@ func (opt *SyncOptions) Run(ctx context.Context, logger kitlog.Logger, cfg *conf
 	OUT("✔ Found %d catalog types, with %d that match our sync ID (%s)",
 		len(result.JSON200.CatalogTypes), len(existingCatalogTypes), cfg.SyncID)
 
+	// Lock the sync ID before we make any changes, so two importers with the same sync ID
+	// can't reconcile the same types at once.
+	var lock *syncLock
+	if !opt.DryRun {
+		lock, ctx, err = lockSyncID(ctx, logger, cl, existingCatalogTypes, cfg.SyncID, syncLockOptions{
+			Timeout: opt.LockTimeout,
+			Force:   opt.ForceUnlock,
+		})
+		if err != nil {
+			return err
+		}
+		defer lock.Release(context.Background()) // release even if we were cancelled
+	}
+
+	// Snapshot everything we manage before we change it, so a bad sync can be restored.
+	if opt.BackupDir != "" && !opt.DryRun {
+		snapshot, err := reconcile.TakeSnapshot(ctx, cl, Version(), cfg.SyncID, opt.SourceRepoUrl, existingCatalogTypes)
+		if err != nil {
+			return errors.Wrap(err, "taking backup")
+		}
+
+		backupFile, err := writeSnapshot(opt.BackupDir, snapshot)
+		if err != nil {
+			return err
+		}
+
+		OUT("✔ Backed up %d catalog types to %s", len(snapshot.Types), backupFile)
+	}
+
 	// Remove unmanaged types
 	if opt.Prune {
 		OUT("\n↻ Prune enabled (--prune), removing types that are no longer in config...")
@@ -168,10 +283,78 @@ func (opt *SyncOptions) Run(ctx context.Context, logger kitlog.Logger, cfg *conf
 					return errors.Wrap(err, "removing catalog type")
 				}
 				OUT("  ⌫ %s", catalogType.TypeName)
+				report.AddType(catalogType.TypeName, reconcile.TypeActionDelete)
 			}
 		}
 	}
 
+	// Adopt catalog types that already exist but belong to another importer (or none), if
+	// the output allows it. Otherwise we'd fail when trying to create them.
+	adoptedFrom := map[string]string{} // type name to the sync ID we adopted it from
+	{
+		toAdopt := []client.CatalogTypeV2{}
+		for _, outputType := range cfg.Outputs() {
+			baseModel, enumModels := output.MarshalType(outputType)
+			for _, model := range append(enumModels, baseModel) {
+				catalogType, exists := lo.Find(result.JSON200.CatalogTypes, func(catalogType client.CatalogTypeV2) bool {
+					return catalogType.TypeName == model.TypeName
+				})
+				if !exists {
+					continue
+				}
+				if _, alreadyAdopting := adoptedFrom[model.TypeName]; alreadyAdopting {
+					continue // enum types can be shared between outputs
+				}
+
+				syncID := catalogType.Annotations[AnnotationSyncID]
+				if syncID == cfg.SyncID {
+					continue
+				}
+				if !outputType.CanAdopt(syncID) {
+					if syncID == "" {
+						return fmt.Errorf("catalog type %s already exists but isn't managed by an importer: "+
+							"set adopt on the output (or use --adopt) to take it over", model.TypeName)
+					}
+
+					return fmt.Errorf("catalog type %s is managed by a different sync ID (%s): "+
+						"add it to adopt_from on the output (or use --adopt-from) to take it over", model.TypeName, syncID)
+				}
+
+				toAdopt = append(toAdopt, catalogType)
+				adoptedFrom[model.TypeName] = syncID
+			}
+		}
+
+		if len(toAdopt) > 0 {
+			OUT("\n↻ Adopting existing catalog types...")
+		}
+		for _, catalogType := range toAdopt {
+			logger := kitlog.With(logger, "type_name", catalogType.TypeName, "catalog_type_id", catalogType.Id)
+
+			from := "unmanaged"
+			if syncID := adoptedFrom[catalogType.TypeName]; syncID != "" {
+				from = fmt.Sprintf("from sync ID %s", syncID)
+			}
+
+			if opt.DryRun {
+				logger.Log("msg", "catalog type can be adopted, skipping for --dry-run")
+				OUT("  ✔ %s (id=%s, would adopt %s)", catalogType.TypeName, catalogType.Id, from)
+			} else {
+				logger.Log("msg", "adopting catalog type", "catalog_type_sync_id", adoptedFrom[catalogType.TypeName])
+				adopted, err := adoptCatalogType(ctx, cl, catalogType, cfg.SyncID)
+				if err != nil {
+					return err
+				}
+
+				catalogType = *adopted
+				OUT("  ✔ %s (id=%s, adopted %s)", catalogType.TypeName, catalogType.Id, from)
+			}
+
+			existingCatalogTypes = append(existingCatalogTypes, catalogType)
+			report.AddType(catalogType.TypeName, reconcile.TypeActionAdopt)
+		}
+	}
+
 	// Create missing catalog types
 	OUT("\n↻ Creating catalog types that don't yet exist...")
 	for _, outputType := range cfg.Outputs() {
@@ -199,29 +382,18 @@ func (opt *SyncOptions) Run(ctx context.Context, logger kitlog.Logger, cfg *conf
 				}
 			} else {
 				logger.Log("msg", "catalog type does not already exist, creating")
-				categories := lo.Map(model.Categories, func(category string, _ int) client.CreateTypeRequestBodyCategories {
-					return client.CreateTypeRequestBodyCategories(category)
-				})
-
-				result, err := cl.CatalogV2CreateTypeWithResponse(ctx, client.CreateTypeRequestBody{
-					Name:          model.Name,
-					Description:   model.Description,
-					Ranked:        &model.Ranked,
-					TypeName:      lo.ToPtr(model.TypeName),
-					Categories:    lo.ToPtr(categories),
-					Annotations:   lo.ToPtr(getAnnotations(cfg.SyncID)),
-					SourceRepoUrl: &opt.SourceRepoUrl,
-				})
+				created, err := createCatalogType(ctx, cl, model, cfg.SyncID, opt.SourceRepoUrl)
 				if err != nil {
-					return errors.Wrap(err, fmt.Sprintf("creating catalog type with name %s", model.TypeName))
+					return err
 				}
 
-				createdCatalogType = result.JSON201.CatalogType
+				createdCatalogType = *created
 				logger.Log("msg", "created catalog type", "catalog_type_id", createdCatalogType.Id)
 			}
 
 			existingCatalogTypes = append(existingCatalogTypes, createdCatalogType)
 			OUT("  ✔ %s (id=%s)", model.TypeName, createdCatalogType.Id)
+			report.AddType(model.TypeName, reconcile.TypeActionCreate)
 		}
 	}
 
@@ -242,6 +414,9 @@ func (opt *SyncOptions) Run(ctx context.Context, logger kitlog.Logger, cfg *conf
 			}
 
 			catalogTypesByOutput[model.TypeName] = catalogType
+			if !report.HasType(model.TypeName) {
+				report.AddType(model.TypeName, schemaAction(model, catalogType))
+			}
 		}
 	}
 
@@ -257,8 +432,14 @@ func (opt *SyncOptions) Run(ctx context.Context, logger kitlog.Logger, cfg *conf
 					logger.Log("msg", "dry-run active, which means we fake a response")
 					updatedCatalogType = *catalogType // they start the same
 
-					// Then we pretend like we've already updated the schema, which means we rebuild the
-					// attributes.
+					// Then we pretend like we've already updated the type, which means we take the
+					// details from the model and rebuild the attributes.
+					updatedCatalogType.Name = model.Name
+					updatedCatalogType.Description = model.Description
+					updatedCatalogType.Ranked = model.Ranked
+					updatedCatalogType.Categories = lo.Map(model.Categories, func(category string, _ int) client.CatalogTypeV2Categories {
+						return client.CatalogTypeV2Categories(category)
+					})
 					updatedCatalogType.Schema = client.CatalogTypeSchemaV2{
 						Version:    updatedCatalogType.Schema.Version,
 						Attributes: []client.CatalogTypeAttributeV2{},
@@ -276,10 +457,6 @@ func (opt *SyncOptions) Run(ctx context.Context, logger kitlog.Logger, cfg *conf
 							path = &newPath
 						}
 
-						updatedCatalogType.Categories = lo.Map(model.Categories, func(category string, _ int) client.CatalogTypeV2Categories {
-							return client.CatalogTypeV2Categories(category)
-						})
-
 						updatedCatalogType.Schema.Attributes = append(updatedCatalogType.Schema.Attributes, client.CatalogTypeAttributeV2{
 							Id:                *attr.Id,
 							Name:              attr.Name,
@@ -306,122 +483,119 @@ func (opt *SyncOptions) Run(ctx context.Context, logger kitlog.Logger, cfg *conf
 				}
 
 				DIFF("  ", catalogTypeToCompare, updatedCatalogType)
-			}
-		}
-	} else {
-		// Update all the type schemas except for new derived attributes, which could reference
-		// attributes that don't exist yet.
-		catalogTypeVersions := map[string]int64{}
-		for _, outputType := range cfg.Outputs() {
-			baseModel, enumModels := output.MarshalType(outputType)
-			for _, model := range append(enumModels, baseModel) {
-				catalogType := catalogTypesByOutput[model.TypeName]
 
-				attributesWithoutNewDerived := []client.CatalogTypeAttributePayloadV2{}
-				for _, attr := range model.Attributes {
-					isBacklink := *attr.Mode == client.CatalogTypeAttributePayloadV2ModeBacklink
-					isPath := *attr.Mode == client.CatalogTypeAttributePayloadV2ModePath
-					if isBacklink || isPath {
-						_, inCurrentSchema := lo.Find(catalogType.Schema.Attributes, func(existingAttr client.CatalogTypeAttributeV2) bool {
-							return existingAttr.Id == *attr.Id
-						})
-						if inCurrentSchema {
-							attributesWithoutNewDerived = append(attributesWithoutNewDerived, attr)
-						}
+				if opt.Plan != nil {
+					action := reconcile.TypeActionNone
+					if strings.HasPrefix(catalogType.Id, "DRY-RUN") {
+						action = reconcile.TypeActionCreate
+					} else if _, adopted := adoptedFrom[model.TypeName]; adopted {
+						action = reconcile.TypeActionAdopt
 					} else {
-						attributesWithoutNewDerived = append(attributesWithoutNewDerived, attr)
+						action = schemaAction(model, catalogType)
 					}
-				}
-
-				categories := lo.Map(model.Categories, func(category string, _ int) client.UpdateTypeRequestBodyCategories {
-					return client.UpdateTypeRequestBodyCategories(category)
-				})
 
-				logger.Log("msg", "updating catalog type", "catalog_type_id", catalogType.Id)
-				result, err := cl.CatalogV2UpdateTypeWithResponse(ctx, catalogType.Id, client.CatalogV2UpdateTypeJSONRequestBody{
-					Name:          model.Name,
-					Description:   model.Description,
-					Ranked:        &model.Ranked,
-					Categories:    lo.ToPtr(categories),
-					Annotations:   lo.ToPtr(getAnnotations(cfg.SyncID)),
-					SourceRepoUrl: &opt.SourceRepoUrl,
-				})
-				if err != nil {
-					return errors.Wrap(err, fmt.Sprintf("updating catalog type with name %s", model.TypeName))
-				}
-
-				version := result.JSON200.CatalogType.Schema.Version
-				logger.Log("msg", "updating catalog type schema", "catalog_type_id", catalogType.Id, "version", version)
-				schema, err := cl.CatalogV2UpdateTypeSchemaWithResponse(ctx, catalogType.Id, client.CatalogV2UpdateTypeSchemaJSONRequestBody{
-					Version:    version,
-					Attributes: attributesWithoutNewDerived,
-				})
-				if err != nil {
-					return errors.Wrap(err, "updating catalog type schema")
+					opt.Plan.AddType(action, model, catalogType)
 				}
-
-				catalogTypeVersions[catalogType.Id] = schema.JSON200.CatalogType.Schema.Version
-
-				OUT("  ✔ %s (id=%s)", model.TypeName, catalogType.Id)
 			}
 		}
-
-		// Then go through again and create any types that do have new derived attributes (backlinks or path)
-		OUT("\n↻ Syncing derived attributes...")
+	} else {
+		models := []*output.CatalogTypeModel{}
 		for _, outputType := range cfg.Outputs() {
 			baseModel, enumModels := output.MarshalType(outputType)
-			for _, model := range append(enumModels, baseModel) {
-				catalogType := catalogTypesByOutput[model.TypeName]
-
-				hasNewDerived := false
-				for _, attr := range model.Attributes {
-					if attr.Mode != nil && (attr.BacklinkAttribute != nil || attr.Path != nil) {
-						_, inCurrentSchema := lo.Find(catalogType.Schema.Attributes, func(existingAttr client.CatalogTypeAttributeV2) bool {
-							return existingAttr.Id == *attr.Id
-						})
-
-						if !inCurrentSchema {
-							hasNewDerived = true
-						}
-					}
-				}
+			models = append(models, append(enumModels, baseModel)...)
+		}
 
-				if !hasNewDerived {
-					continue
-				}
-				version := catalogTypeVersions[catalogType.Id]
-				logger.Log("msg", "updating catalog type schema: creating derived attribute(s)", "catalog_type_id", catalogType.Id, "version", version)
+		err := updateCatalogTypes(ctx, logger, cl, lock, models, catalogTypesByOutput, cfg.SyncID, opt.SourceRepoUrl)
+		if err != nil {
+			return err
+		}
+	}
 
-				_, err = cl.CatalogV2UpdateTypeSchemaWithResponse(ctx, catalogType.Id, client.CatalogV2UpdateTypeSchemaJSONRequestBody{
-					Version:    version,
-					Attributes: model.Attributes,
-				})
-				if err != nil {
-					return errors.Wrap(err, "updating catalog type schema")
-				}
+	// Attributes can refer to entries by name or alias, which we resolve against both the
+	// live catalog and the entries we build in this sync. Our managed types now include
+	// those we created above, which weren't in the original list.
+	references := newReferenceResolver(cl, result.JSON200.CatalogTypes, existingCatalogTypes)
+
+	// We build the entries for every output before reconciling any of them, so references
+	// resolve against entries from any output in the config, whatever order they're in.
+	builtOutputs := []*builtOutput{}
+
+	// Record the error and move onto the next output if we're continuing on error,
+	// otherwise return it.
+	failOutput := func(built *builtOutput, err error) error {
+		withOrigin(err, built.origins)
+		built.report.AddError(err)
+		built.report.Done()
+		if !opt.ContinueOnError {
+			return err
+		}
 
-				OUT("  ✔ %s (id=%s)", model.TypeName, catalogType.Id)
-			}
+		syncErrors.Add(built.pipelineIdx, built.outputType.TypeName, err, built.origins)
+		return nil
+	}
+	// Entry errors allow us to continue with the entries that succeeded, though we mustn't
+	// delete anything in case it was one of the entries that failed.
+	isEntryErrors := func(built *builtOutput, err error) bool {
+		var entryErrors output.EntryErrors
+		if opt.ContinueOnError && errors.As(err, &entryErrors) {
+			OUT("      ✘ Failed to process %d entries, will not delete entries for this output", len(entryErrors))
+			syncErrors.Add(built.pipelineIdx, built.outputType.TypeName, err, built.origins)
+			withOrigin(err, built.origins)
+			built.report.AddError(err)
+			report.Warn("%s: not deleting entries, as %d entries failed to build", built.outputType.TypeName, len(entryErrors))
+			built.hasErrors = true
+
+			return true
 		}
+
+		return false
 	}
 
-	for _, pipeline := range cfg.Pipelines {
+eachPipeline:
+	for pipelineIdx, pipeline := range cfg.Pipelines {
 		OUT("\n↻ Syncing pipeline... (%s)", strings.Join(lo.Map(pipeline.Outputs, func(op *output.Output, _ int) string {
 			return op.TypeName
 		}), ", "))
 
+		// If we fail to parse or build any entries in this pipeline, we won't delete entries
+		// from the catalog, as that may remove the entries we failed to process.
+		pipelineHasErrors := false
+		pipelineReport := report.AddPipeline()
+
 		// Load entries from source
 		sourcedEntries := []source.Entry{}
+		origins := source.Origins{}
+		joinEntries := map[string][]source.Entry{} // by source name, for sources in the join
 		{
 			OUT("\n  ↻ Loading data from sources...")
 			for _, source := range pipeline.Sources {
 				sourceLabel := lo.Must(source.Backend()).String()
 
+				sourceReport := pipelineReport.AddSource(sourceLabel)
+
+				loadStart := time.Now()
 				sourceEntries, err := source.Load(ctx, logger)
+				sourceReport.DurationSeconds = time.Since(loadStart).Seconds()
+				metrics.SourceLoadDuration.WithLabelValues(sourceLabel).Observe(sourceReport.DurationSeconds)
 				if err != nil {
-					return errors.Wrap(err, fmt.Sprintf("loading entries from source: %s", sourceLabel))
+					err = errors.Wrap(err, fmt.Sprintf("loading entries from source: %s", sourceLabel))
+					sourceReport.Error = err.Error()
+					if !opt.ContinueOnError {
+						return err
+					}
+
+					// Without all the entries from the source we can't safely sync any of the
+					// outputs, so skip the entire pipeline.
+					OUT("    ✘ %s (failed to load, skipping pipeline)", sourceLabel)
+					syncErrors.AddEntry(pipelineIdx, sourceLabel, err)
+					for _, outputType := range pipeline.Outputs {
+						pipelineReport.AddOutput(outputType.TypeName).Skipped = fmt.Sprintf("failed to load source: %s", sourceLabel)
+					}
+
+					continue eachPipeline
 				}
 
+				sourceEntryCount := 0
 				for _, sourceEntry := range sourceEntries {
 					parsedEntries, err := sourceEntry.Parse()
 					if err != nil {
@@ -434,89 +608,432 @@ func (opt *SyncOptions) Run(ctx context.Context, logger kitlog.Logger, cfg *conf
 							"error", errors.Wrap(err, "parsing source entry"),
 							"sample", sample,
 						)
+						report.Warn("%s: failed to parse source entry: %s", sourceEntry.Origin, err)
+
+						if opt.ContinueOnError {
+							syncErrors.AddEntry(pipelineIdx, sourceEntry.Origin, errors.Wrap(err, "parsing source entry"))
+							pipelineHasErrors = true
+						}
 					}
 
-					sourcedEntries = append(sourcedEntries, parsedEntries...)
+					origins.Add(sourceEntry.Origin, parsedEntries...)
+					if pipeline.Join.Includes(source.Name) {
+						joinEntries[source.Name] = append(joinEntries[source.Name], parsedEntries...)
+					} else {
+						sourcedEntries = append(sourcedEntries, parsedEntries...)
+					}
+					sourceEntryCount += len(parsedEntries)
 				}
+				metrics.SourceEntries.WithLabelValues(sourceLabel).Set(float64(sourceEntryCount))
+				sourceReport.Entries = sourceEntryCount
 
-				OUT("    ✔ %s (found %d entries)", sourceLabel, len(sourcedEntries))
+				OUT("    ✔ %s (found %d entries)", sourceLabel, sourceEntryCount)
+			}
+
+			if pipeline.Join != nil {
+				joinedEntries, err := pipeline.Join.Apply(ctx, logger, joinEntries, origins)
+				if err != nil {
+					err = errors.Wrap(err, "joining sources")
+					if !opt.ContinueOnError {
+						return err
+					}
+
+					// Joined entries are the input to every output, so we can't sync any of them.
+					OUT("    ✘ join (failed, skipping pipeline)")
+					syncErrors.AddEntry(pipelineIdx, "join", err)
+					for _, outputType := range pipeline.Outputs {
+						pipelineReport.AddOutput(outputType.TypeName).Skipped = "failed to join sources"
+					}
+
+					continue eachPipeline
+				}
+
+				sourcedEntries = append(joinedEntries, sourcedEntries...)
+
+				OUT("    ✔ join (%d entries after joining %s)", len(joinedEntries), strings.Join(pipeline.Join.SourceNames(), ", "))
 			}
 		}
 
-		OUT("\n  ↻ Syncing entries...")
+		OUT("\n  ↻ Building entries...")
 		for idx, outputType := range pipeline.Outputs {
 			OUT("\n    ↻ %s", outputType.TypeName)
 
+			built := &builtOutput{
+				pipelineIdx:    pipelineIdx,
+				pipelineReport: pipelineReport,
+				outputType:     outputType,
+				origins:        origins,
+				report:         pipelineReport.AddOutput(outputType.TypeName),
+				hasErrors:      pipelineHasErrors,
+			}
+			fail := func(err error) error {
+				return failOutput(built, errors.Wrap(err, fmt.Sprintf("outputs.%d (type_name='%s')", idx, outputType.TypeName)))
+			}
+
 			// Filter source for each of the output types
 			entries, err := output.Collect(ctx, logger, outputType, sourcedEntries)
-			if err != nil {
-				return errors.Wrap(err, fmt.Sprintf("outputs.%d (type_name='%s')", idx, outputType.TypeName))
+			if err != nil && !isEntryErrors(built, err) {
+				if err := fail(err); err != nil {
+					return err
+				}
+
+				continue
 			}
 			OUT("      ✔ Building entries... (found %d entries matching filters)", len(entries))
 
+			// Outputs with group_by build an entry per group, rather than per source entry.
+			if outputType.GroupBy != nil {
+				entries, err = output.Group(ctx, logger, outputType, entries, origins)
+				if err != nil && !isEntryErrors(built, err) {
+					if err := fail(err); err != nil {
+						return err
+					}
+
+					continue
+				}
+				OUT("      ✔ Grouping entries... (found %d groups)", len(entries))
+			}
+
 			// Marshal entries using the JS expressions.
-			entryModels, err := output.MarshalEntries(ctx, logger, outputType, entries)
-			if err != nil {
-				return errors.Wrap(err, fmt.Sprintf("outputs.%d (type_name='%s')", idx, outputType.TypeName))
+			built.entryModels, err = output.MarshalEntries(ctx, logger, outputType, entries)
+			if err != nil && !isEntryErrors(built, err) {
+				if err := fail(err); err != nil {
+					return err
+				}
+
+				continue
 			}
 
-			// As a precaution, error if we think there are no entries for this output and we
-			// haven't explicitly permitted deleting all entries.
-			if len(entryModels) == 0 && !opt.AllowDeleteAll {
-				return errors.New(fmt.Sprintf("outputs (type_name = '%s'): found 0 matching entries and would delete everything but --allow-delete-all not set", outputType.TypeName))
+			references.AddModels(outputType.TypeName, built.entryModels)
+			builtOutputs = append(builtOutputs, built)
+			built.report.Done() // until we start reconciling
+		}
+	}
+
+	OUT("\n↻ Syncing entries...")
+	for _, built := range builtOutputs {
+		outputType, entryModels := built.outputType, built.entryModels
+		OUT("\n    ↻ %s", outputType.TypeName)
+		built.report.Start()
+
+		// Rewrite any references by name or alias to the external ID of the entry.
+		unresolved, err := output.ResolveReferences(outputType, entryModels, func(typeName string) (*output.ReferenceIndex, error) {
+			return references.Index(ctx, typeName)
+		})
+		if err != nil {
+			err = errors.Wrap(err, fmt.Sprintf("outputs (type_name = '%s'): resolving references", outputType.TypeName))
+			if err := failOutput(built, err); err != nil {
+				return err
+			}
+
+			continue
+		}
+		if len(unresolved) > 0 {
+			OUT("      ! Could not resolve %d references:", len(unresolved))
+			for _, reference := range unresolved {
+				OUT("        %s", reference.Error())
+				report.Warn("%s: unresolved reference: %s", outputType.TypeName, reference.Error())
 			}
+		}
 
-			// This can be reused for both model and enum types.
-			entriesClient := newEntriesClient(cl, existingCatalogTypes, opt.DryRun)
+		// As a precaution, error if we think there are no entries for this output and we
+		// haven't explicitly permitted deleting all entries. If the output had errors we
+		// won't delete anything anyway, so there's no need to report this too.
+		if len(entryModels) == 0 && !opt.AllowDeleteAll && !built.hasErrors {
+			if err := failOutput(built, errors.New(fmt.Sprintf("outputs (type_name = '%s'): found 0 matching entries and would delete everything but --allow-delete-all not set", outputType.TypeName))); err != nil {
+				return err
+			}
 
-			{
-				logger.Log("msg", "reconciling catalog entries", "output", outputType.TypeName)
-				catalogType := catalogTypesByOutput[outputType.TypeName]
+			continue
+		}
 
-				err = reconcile.Entries(ctx, logger, entriesClient, outputType, catalogType, entryModels, newEntriesProgress(!opt.DryRun))
-				if err != nil {
-					return errors.Wrap(err, fmt.Sprintf("outputs (type_name = '%s'): reconciling catalog entries", outputType.TypeName))
+		// This can be reused for both model and enum types.
+		entriesClient := newEntriesClient(cl, existingCatalogTypes, opt.DryRun)
+		if opt.Plan != nil {
+			entriesClient = opt.Plan.Record(entriesClient)
+		}
+
+		entriesOptions := []reconcile.EntriesOption{}
+		if opt.ContinueOnError {
+			entriesOptions = append(entriesOptions, reconcile.WithContinueOnError())
+		}
+		if built.hasErrors {
+			entriesOptions = append(entriesOptions, reconcile.WithoutDeletes())
+		}
+		if outputType.DeleteThreshold != nil && !opt.IgnoreDeleteThreshold {
+			entriesOptions = append(entriesOptions, reconcile.WithDeleteThreshold(outputType.DeleteThreshold))
+		}
+
+		{
+			logger.Log("msg", "reconciling catalog entries", "output", outputType.TypeName)
+			catalogType := catalogTypesByOutput[outputType.TypeName]
+
+			err = reconcile.Entries(ctx, logger, entriesClient, outputType, catalogType, entryModels, newEntriesProgress(!opt.DryRun),
+				append(entriesOptions, reconcile.WithResult(built.report.Entries))...)
+			if err != nil {
+				if err := failOutput(built, errors.Wrap(err, fmt.Sprintf("outputs (type_name = '%s'): reconciling catalog entries", outputType.TypeName))); err != nil {
+					return err
 				}
 			}
+			built.report.Done()
+		}
 
-			// Process enum attributes, which require generating from the result of the parent
-			// model's attribute.
-			_, enumModels := output.MarshalType(outputType)
-			for _, enumModel := range enumModels {
-				// We've got an enum attribute, which means we need to sync the enum values.
-				valueSet := map[string]bool{}
-				for _, entry := range entryModels {
-					value := entry.AttributeValues[enumModel.SourceAttribute.ID]
-					if value.Value != nil {
-						valueSet[*value.Value.Literal] = true
-					}
-					if value.ArrayValue != nil {
-						for _, elementValue := range *value.ArrayValue {
-							valueSet[*elementValue.Literal] = true
-						}
+		// Process enum attributes, which require generating from the result of the parent
+		// model's attribute.
+		_, enumModels := output.MarshalType(outputType)
+		for _, enumModel := range enumModels {
+			// We've got an enum attribute, which means we need to sync the enum values.
+			valueSet := map[string]bool{}
+			for _, entry := range entryModels {
+				value := entry.AttributeValues[enumModel.SourceAttribute.ID]
+				if value.Value != nil {
+					valueSet[*value.Value.Literal] = true
+				}
+				if value.ArrayValue != nil {
+					for _, elementValue := range *value.ArrayValue {
+						valueSet[*elementValue.Literal] = true
 					}
 				}
+			}
 
-				enumModels := []*output.CatalogEntryModel{}
-				for value := range valueSet {
-					enumModels = append(enumModels, &output.CatalogEntryModel{
-						ExternalID:      value,
-						Name:            value,
-						Aliases:         []string{},
-						AttributeValues: map[string]client.EngineParamBindingPayloadV2{},
-					})
+			enumModels := []*output.CatalogEntryModel{}
+			for value := range valueSet {
+				enumModels = append(enumModels, &output.CatalogEntryModel{
+					ExternalID:      value,
+					Name:            value,
+					Aliases:         []string{},
+					AttributeValues: map[string]client.EngineParamBindingPayloadV2{},
+				})
+			}
+
+			OUT("\n    ↻ %s (enum)", enumModel.TypeName)
+			enumReport := built.pipelineReport.AddOutput(enumModel.TypeName)
+			enumReport.Enum = true
+
+			catalogType := catalogTypesByOutput[enumModel.TypeName]
+			err := reconcile.Entries(ctx, logger, entriesClient, outputType, catalogType, enumModels, newEntriesProgress(!opt.DryRun),
+				append(entriesOptions, reconcile.WithResult(enumReport.Entries))...)
+			enumReport.Done()
+			if err != nil {
+				err = errors.Wrap(err,
+					fmt.Sprintf("outputs (type_name = '%s'): enum for attribute (id = '%s'): %s: reconciling catalog entries",
+						outputType.TypeName, enumModel.SourceAttribute.ID, enumModel.TypeName))
+				if err := failOutput(built, err); err != nil {
+					return err
 				}
+			}
+		}
+	}
 
-				OUT("\n    ↻ %s (enum)", enumModel.TypeName)
-				catalogType := catalogTypesByOutput[enumModel.TypeName]
-				err := reconcile.Entries(ctx, logger, entriesClient, outputType, catalogType, enumModels, newEntriesProgress(!opt.DryRun))
-				if err != nil {
-					return errors.Wrap(err,
-						fmt.Sprintf("outputs (type_name = '%s'): enum for attribute (id = '%s'): %s: reconciling catalog entries",
-							outputType.TypeName, enumModel.SourceAttribute.ID, enumModel.TypeName))
+	if len(syncErrors) > 0 {
+		OUT("\n✘ Sync finished with %d errors:\n", len(syncErrors))
+		syncErrors.Print()
+
+		return syncErrors
+	}
+
+	return nil
+}
+
+// recordMetrics records the result of a sync, writing metrics to the textfile if
+// configured.
+func (opt *SyncOptions) recordMetrics(logger kitlog.Logger, start time.Time, err error) {
+	metrics.SyncDuration.Set(time.Since(start).Seconds())
+	if err != nil {
+		metrics.SyncRuns.WithLabelValues("failure").Inc()
+	} else {
+		metrics.SyncRuns.WithLabelValues("success").Inc()
+		metrics.LastSuccess.SetToCurrentTime()
+	}
+
+	if opt.MetricsTextfile != "" {
+		if err := metrics.WriteTextfile(opt.MetricsTextfile); err != nil {
+			logger.Log("msg", "failed to write metrics textfile", "error", err)
+		}
+	}
+}
+
+// writeReports writes the run report in any formats that were requested.
+func (opt *SyncOptions) writeReports(logger kitlog.Logger, report *RunReport, syncErrors SyncErrors, err error) {
+	if opt.ReportFile == "" && opt.JUnitReportFile == "" {
+		return
+	}
+
+	report.Finish(syncErrors, err)
+	if opt.ReportFile != "" {
+		if err := report.WriteJSON(opt.ReportFile); err != nil {
+			logger.Log("msg", "failed to write report", "error", err)
+		}
+	}
+	if opt.JUnitReportFile != "" {
+		if err := report.WriteJUnit(opt.JUnitReportFile); err != nil {
+			logger.Log("msg", "failed to write JUnit report", "error", err)
+		}
+	}
+}
+
+// builtOutput is an output whose entries we've built, ready to be reconciled.
+type builtOutput struct {
+	pipelineIdx    int
+	pipelineReport *PipelineReport
+	outputType     *output.Output
+	origins        source.Origins
+	report         *OutputReport
+	entryModels    []*output.CatalogEntryModel
+	hasErrors      bool // if true, we failed to build some entries so mustn't delete any
+}
+
+// withOrigin annotates an expression evaluation error with the origin of the entry that
+// caused it, if we know it.
+func withOrigin(err error, origins source.Origins) {
+	var entryErrors output.EntryErrors
+	if errors.As(err, &entryErrors) {
+		for _, entryErr := range entryErrors {
+			withOrigin(entryErr, origins)
+		}
+
+		return
+	}
+
+	var evaluationErr *output.EvaluationError
+	if errors.As(err, &evaluationErr) {
+		evaluationErr.Origin = origins.Get(evaluationErr.Entry)
+	}
+}
+
+// createCatalogType creates a new catalog type for the model, annotated so that we know
+// it's managed by this sync ID.
+func createCatalogType(ctx context.Context, cl *client.ClientWithResponses, model *output.CatalogTypeModel, syncID, sourceRepoUrl string) (*client.CatalogTypeV2, error) {
+	categories := lo.Map(model.Categories, func(category string, _ int) client.CreateTypeRequestBodyCategories {
+		return client.CreateTypeRequestBodyCategories(category)
+	})
+
+	result, err := cl.CatalogV2CreateTypeWithResponse(ctx, client.CreateTypeRequestBody{
+		Name:          model.Name,
+		Description:   model.Description,
+		Ranked:        &model.Ranked,
+		TypeName:      lo.ToPtr(model.TypeName),
+		Categories:    lo.ToPtr(categories),
+		Annotations:   lo.ToPtr(getAnnotations(syncID)),
+		SourceRepoUrl: &sourceRepoUrl,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("creating catalog type with name %s", model.TypeName))
+	}
+
+	return &result.JSON201.CatalogType, nil
+}
+
+// adoptCatalogType stamps our annotations onto an existing catalog type so that it's
+// managed by this sync ID, leaving everything else as-is until we sync its schema.
+func adoptCatalogType(ctx context.Context, cl *client.ClientWithResponses, catalogType client.CatalogTypeV2, syncID string) (*client.CatalogTypeV2, error) {
+	adopted, err := updateTypeAnnotations(ctx, cl, catalogType, typeAnnotations(catalogType.Annotations, nil, syncID))
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("adopting catalog type with name %s", catalogType.TypeName))
+	}
+
+	return adopted, nil
+}
+
+// updateCatalogTypes pushes each model into its catalog type, which is found in the
+// lookup by type name.
+//
+// We first update all the type schemas except for new derived attributes (backlinks or
+// paths), which could reference attributes that don't exist yet, then go back and add
+// the new derived attributes once everything else is in place.
+func updateCatalogTypes(ctx context.Context, logger kitlog.Logger, cl *client.ClientWithResponses, lock *syncLock, models []*output.CatalogTypeModel, catalogTypesByName map[string]*client.CatalogTypeV2, syncID, sourceRepoUrl string) error {
+	catalogTypeVersions := map[string]int64{}
+	for _, model := range models {
+		catalogType := catalogTypesByName[model.TypeName]
+
+		attributesWithoutNewDerived := []client.CatalogTypeAttributePayloadV2{}
+		for _, attr := range model.Attributes {
+			isBacklink := *attr.Mode == client.CatalogTypeAttributePayloadV2ModeBacklink
+			isPath := *attr.Mode == client.CatalogTypeAttributePayloadV2ModePath
+			if isBacklink || isPath {
+				_, inCurrentSchema := lo.Find(catalogType.Schema.Attributes, func(existingAttr client.CatalogTypeAttributeV2) bool {
+					return existingAttr.Id == *attr.Id
+				})
+				if inCurrentSchema {
+					attributesWithoutNewDerived = append(attributesWithoutNewDerived, attr)
+				}
+			} else {
+				attributesWithoutNewDerived = append(attributesWithoutNewDerived, attr)
+			}
+		}
+
+		categories := lo.Map(model.Categories, func(category string, _ int) client.UpdateTypeRequestBodyCategories {
+			return client.UpdateTypeRequestBodyCategories(category)
+		})
+
+		logger.Log("msg", "updating catalog type", "catalog_type_id", catalogType.Id)
+		var result *client.CatalogV2UpdateTypeResponse
+		err := lock.UpdateType(catalogType.Id, func(lease map[string]string) error {
+			annotations := typeAnnotations(catalogType.Annotations, lease, syncID)
+
+			var err error
+			result, err = cl.CatalogV2UpdateTypeWithResponse(ctx, catalogType.Id, client.CatalogV2UpdateTypeJSONRequestBody{
+				Name:          model.Name,
+				Description:   model.Description,
+				Ranked:        &model.Ranked,
+				Categories:    lo.ToPtr(categories),
+				Annotations:   lo.ToPtr(annotations),
+				SourceRepoUrl: &sourceRepoUrl,
+			})
+			return err
+		})
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("updating catalog type with name %s", model.TypeName))
+		}
+
+		version := result.JSON200.CatalogType.Schema.Version
+		logger.Log("msg", "updating catalog type schema", "catalog_type_id", catalogType.Id, "version", version)
+		schema, err := cl.CatalogV2UpdateTypeSchemaWithResponse(ctx, catalogType.Id, client.CatalogV2UpdateTypeSchemaJSONRequestBody{
+			Version:    version,
+			Attributes: attributesWithoutNewDerived,
+		})
+		if err != nil {
+			return errors.Wrap(err, "updating catalog type schema")
+		}
+
+		catalogTypeVersions[catalogType.Id] = schema.JSON200.CatalogType.Schema.Version
+
+		OUT("  ✔ %s (id=%s)", model.TypeName, catalogType.Id)
+	}
+
+	// Then go through again and create any types that do have new derived attributes (backlinks or path)
+	OUT("\n↻ Syncing derived attributes...")
+	for _, model := range models {
+		catalogType := catalogTypesByName[model.TypeName]
+
+		hasNewDerived := false
+		for _, attr := range model.Attributes {
+			if attr.Mode != nil && (attr.BacklinkAttribute != nil || attr.Path != nil) {
+				_, inCurrentSchema := lo.Find(catalogType.Schema.Attributes, func(existingAttr client.CatalogTypeAttributeV2) bool {
+					return existingAttr.Id == *attr.Id
+				})
+
+				if !inCurrentSchema {
+					hasNewDerived = true
 				}
 			}
 		}
+
+		if !hasNewDerived {
+			continue
+		}
+		version := catalogTypeVersions[catalogType.Id]
+		logger.Log("msg", "updating catalog type schema: creating derived attribute(s)", "catalog_type_id", catalogType.Id, "version", version)
+
+		_, err := cl.CatalogV2UpdateTypeSchemaWithResponse(ctx, catalogType.Id, client.CatalogV2UpdateTypeSchemaJSONRequestBody{
+			Version:    version,
+			Attributes: model.Attributes,
+		})
+		if err != nil {
+			return errors.Wrap(err, "updating catalog type schema")
+		}
+
+		OUT("  ✔ %s (id=%s)", model.TypeName, catalogType.Id)
 	}
 
 	return nil
@@ -560,36 +1077,10 @@ func newEntriesClient(cl *client.ClientWithResponses, existingCatalogTypes []cli
 			return entry, nil
 		},
 		Update: func(ctx context.Context, entry *client.CatalogEntryV2, payload client.UpdateEntryRequestBody) (*client.CatalogEntryV2, error) {
-			existingPayload := client.UpdateEntryRequestBody{
-				Aliases:         lo.ToPtr(entry.Aliases),
-				AttributeValues: map[string]client.EngineParamBindingPayloadV2{},
-				ExternalId:      entry.ExternalId,
-				Name:            entry.Name,
-				Rank:            &entry.Rank,
-			}
+			existingPayload := reconcile.EntryPayload(*entry)
 			if payload.Rank == nil && entry.Rank == 0 {
 				existingPayload.Rank = nil
 			}
-			for attrID, attr := range entry.AttributeValues {
-				result := client.EngineParamBindingPayloadV2{}
-				if attr.Value != nil {
-					result.Value = &client.EngineParamBindingValuePayloadV2{
-						Literal: attr.Value.Literal,
-					}
-				}
-				if attr.ArrayValue != nil {
-					arrayValue := []client.EngineParamBindingValuePayloadV2{}
-					for _, elementValue := range *attr.ArrayValue {
-						arrayValue = append(arrayValue, client.EngineParamBindingValuePayloadV2{
-							Literal: elementValue.Literal,
-						})
-					}
-
-					result.ArrayValue = &arrayValue
-				}
-
-				existingPayload.AttributeValues[attrID] = result
-			}
 
 			DIFF("      ", existingPayload, payload)
 			return entry, nil
@@ -661,6 +1152,7 @@ func newEntriesProgress(showBars bool) *reconcile.EntriesProgress {
 }
 
 var (
+	AnnotationPrefix     = "incident.io/catalog-importer/"
 	AnnotationSyncID     = "incident.io/catalog-importer/sync-id"
 	AnnotationLastSyncAt = "incident.io/catalog-importer/last-sync-at"
 	AnnotationVersion    = "incident.io/catalog-importer/version"
@@ -674,6 +1166,17 @@ func getAnnotations(syncID string) map[string]string {
 	}
 }
 
+// typeAnnotations builds the annotations for a catalog type we manage. Anything with our
+// prefix belongs to us, so we replace it, other than the lock on the sync ID if this type
+// holds it. We keep everything else, in case someone else is using it.
+func typeAnnotations(existing, lease map[string]string, syncID string) map[string]string {
+	others := lo.OmitBy(existing, func(key, _ string) bool {
+		return strings.HasPrefix(key, AnnotationPrefix)
+	})
+
+	return lo.Assign(others, lease, getAnnotations(syncID))
+}
+
 func newProgressBar(total int64, opts ...progressbar.Option) *progressbar.ProgressBar {
 	return progressbar.NewOptions64(
 		total,
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+	"github.com/rodaine/table"
+
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+	"github.com/incident-io/catalog-importer/v2/source"
+)
+
+// SyncError is an error recorded when running with --continue-on-error, which we report
+// in a summary once the sync has finished.
+type SyncError struct {
+	Pipeline int    // index of the pipeline in the config
+	Output   string // type name of the output, if the error relates to one
+	Entry    string // identifies the entry, if the error relates to one
+	Err      error
+}
+
+// SyncErrors collects errors over the course of a sync.
+type SyncErrors []SyncError
+
+// Add records an error, splitting it into an error per entry if the error came from
+// processing many entries.
+func (s *SyncErrors) Add(pipeline int, outputType string, err error, origins source.Origins) {
+	var entryErrors output.EntryErrors
+	if !errors.As(err, &entryErrors) {
+		*s = append(*s, SyncError{Pipeline: pipeline, Output: outputType, Err: err})
+		return
+	}
+
+	for _, entryErr := range entryErrors {
+		syncErr := SyncError{Pipeline: pipeline, Output: outputType, Err: entryErr}
+
+		var (
+			evaluationErr *output.EvaluationError
+			reconcileErr  *reconcile.EntryError
+		)
+		switch {
+		case errors.As(entryErr, &evaluationErr):
+			syncErr.Entry = origins.Get(evaluationErr.Entry)
+			syncErr.Err = fmt.Errorf("%s: expression %q: %w",
+				evaluationErr.Field, evaluationErr.Expression, evaluationErr.Err)
+		case errors.As(entryErr, &reconcileErr):
+			syncErr.Entry = fmt.Sprintf("external_id=%s", reconcileErr.ExternalID)
+		}
+
+		*s = append(*s, syncErr)
+	}
+}
+
+// Error summarises the errors, which is what a sync returns when it finishes with errors.
+func (s SyncErrors) Error() string {
+	return fmt.Sprintf("sync finished with %d errors", len(s))
+}
+
+// AddEntry records an error about a single entry, such as a source file we couldn't parse.
+func (s *SyncErrors) AddEntry(pipeline int, entry string, err error) {
+	*s = append(*s, SyncError{Pipeline: pipeline, Entry: entry, Err: err})
+}
+
+// Print renders a summary table of all errors.
+func (s SyncErrors) Print() {
+	headerFmt := color.New(color.Bold).SprintfFunc()
+	columnFmt := color.New(color.FgYellow).SprintfFunc()
+
+	tbl := table.New("Pipeline", "Output", "Entry", "Error")
+	tbl.WithHeaderFormatter(headerFmt).WithFirstColumnFormatter(columnFmt).WithWriter(os.Stderr)
+
+	for _, syncErr := range s {
+		tbl.AddRow(syncErr.Pipeline, syncErr.Output, syncErr.Entry, syncErr.Err.Error())
+	}
+
+	tbl.Print()
+}
@@ -0,0 +1,259 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/samber/lo"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/config"
+	"github.com/incident-io/catalog-importer/v2/output"
+)
+
+var _ = Describe("catalog type annotations", func() {
+	var (
+		ctx context.Context
+		api *fakeAPI
+		cl  *client.ClientWithResponses
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		api, cl = newFakeAPI(ctx)
+	})
+
+	foreignLease := map[string]string{
+		AnnotationLockHolder:    "someone-else",
+		AnnotationLockExpiresAt: "2099-01-01T00:00:00Z",
+	}
+
+	Describe("adoptCatalogType", func() {
+		It("drops the previous importer's annotations and keeps the rest", func() {
+			catalogType := api.AddType(client.CatalogTypeV2{
+				TypeName: "Custom[\"Team\"]",
+				Annotations: map[string]string{
+					AnnotationSyncID:        "previous-sync-id",
+					AnnotationLockHolder:    "someone-else",
+					AnnotationLockExpiresAt: "2099-01-01T00:00:00Z",
+					"owner":                 "platform",
+				},
+			})
+
+			adopted, err := adoptCatalogType(ctx, cl, catalogType, "sync-id")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(adopted.Annotations).To(HaveKeyWithValue(AnnotationSyncID, "sync-id"))
+			Expect(adopted.Annotations).To(HaveKeyWithValue("owner", "platform"))
+			Expect(adopted.Annotations).NotTo(HaveKey(AnnotationLockHolder))
+			Expect(adopted.Annotations).NotTo(HaveKey(AnnotationLockExpiresAt))
+		})
+	})
+
+	Describe("updateCatalogTypes", func() {
+		var (
+			catalogTypes []client.CatalogTypeV2
+			models       []*output.CatalogTypeModel
+		)
+
+		BeforeEach(func() {
+			for _, typeName := range []string{"Custom[\"Service\"]", "Custom[\"Team\"]"} {
+				catalogTypes = append(catalogTypes, api.AddType(client.CatalogTypeV2{
+					TypeName: typeName,
+					Annotations: map[string]string{
+						AnnotationSyncID:     "sync-id",
+						AnnotationLastSyncAt: "2024-01-01T00:00:00Z",
+						"owner":              "platform",
+					},
+				}))
+				models = append(models, &output.CatalogTypeModel{
+					Name:       typeName,
+					TypeName:   typeName,
+					Attributes: []client.CatalogTypeAttributePayloadV2{},
+				})
+			}
+		})
+
+		update := func(lock *syncLock) {
+			catalogTypesByName := map[string]*client.CatalogTypeV2{}
+			for idx := range catalogTypes {
+				catalogType := api.Type(catalogTypes[idx].Id)
+				catalogTypesByName[catalogType.TypeName] = &catalogType
+			}
+
+			Expect(updateCatalogTypes(ctx, kitlog.NewNopLogger(), cl, lock, models, catalogTypesByName, "sync-id", "")).To(Succeed())
+		}
+
+		It("keeps annotations that aren't ours", func() {
+			update(nil)
+
+			for _, catalogType := range catalogTypes {
+				annotations := api.Type(catalogType.Id).Annotations
+				Expect(annotations).To(HaveKeyWithValue("owner", "platform"))
+				Expect(annotations).To(HaveKeyWithValue(AnnotationSyncID, "sync-id"))
+				Expect(annotations).NotTo(HaveKeyWithValue(AnnotationLastSyncAt, "2024-01-01T00:00:00Z"))
+			}
+		})
+
+		It("keeps our lock, but not one that isn't ours", func() {
+			lock, _, err := acquireSyncLock(ctx, kitlog.NewNopLogger(), cl, catalogTypes[:1], "sync-id", syncLockOptions{Timeout: time.Hour})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = updateTypeAnnotations(ctx, cl, api.Type(catalogTypes[1].Id), foreignLease)
+			Expect(err).NotTo(HaveOccurred())
+
+			update(lock)
+
+			Expect(api.Type(catalogTypes[0].Id).Annotations).To(HaveKeyWithValue(AnnotationLockHolder, lock.holder))
+			Expect(api.Type(catalogTypes[1].Id).Annotations).NotTo(HaveKey(AnnotationLockHolder))
+		})
+	})
+})
+
+var _ = Describe("SyncOptions", func() {
+	var (
+		ctx         context.Context
+		api         *fakeAPI
+		catalogType client.CatalogTypeV2
+		opt         *SyncOptions
+		cfg         *config.Config
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		api, _ = newFakeAPI(ctx)
+
+		catalogType = api.AddType(client.CatalogTypeV2{
+			Name:        "Service",
+			Description: "Services",
+			TypeName:    `Custom["Service"]`,
+			Annotations: map[string]string{AnnotationSyncID: "sync-id"},
+		})
+		api.AddEntry(client.CatalogEntryV2{
+			CatalogTypeId: catalogType.Id,
+			ExternalId:    lo.ToPtr("retired"),
+			Name:          "Retired",
+		})
+
+		opt = &SyncOptions{
+			APIEndpoint: api.URL,
+			APIKey:      "api-key",
+			LockTimeout: time.Hour,
+		}
+	})
+
+	names := func() []string {
+		return lo.Map(api.Entries(catalogType.Id), func(entry client.CatalogEntryV2, _ int) string {
+			return entry.Name
+		})
+	}
+
+	When("a GraphQL source has more than one page", func() {
+		BeforeEach(func() {
+			// Three services, served in pages of two.
+			services := []map[string]any{
+				{"id": "payments", "name": "Payments"},
+				{"id": "billing", "name": "Billing"},
+				{"id": "search", "name": "Search"},
+			}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body struct {
+					Variables map[string]string `json:"variables"`
+				}
+				Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+				page, _ := strconv.Atoi(body.Variables["page"])
+
+				results := []map[string]any{}
+				if page*2 < len(services) {
+					results = services[page*2 : lo.Min([]int{page*2 + 2, len(services)})]
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"services": results}})
+			}))
+			DeferCleanup(server.Close)
+
+			var err error
+			cfg, err = config.Parse("importer.json", []byte(fmt.Sprintf(`{
+  "sync_id": "sync-id",
+  "pipelines": [{
+    "sources": [{
+      "graphql": {
+        "endpoint": %q,
+        "query": "query($page: Int) { services(page: $page) { id name } }",
+        "result": "services"
+      }
+    }],
+    "outputs": [{
+      "name": "Service",
+      "description": "Services",
+      "type_name": "Custom[\"Service\"]",
+      "source": {"name": "$.name", "external_id": "$.id"},
+      "attributes": []
+    }]
+  }]
+}`, server.URL)))
+			Expect(err).NotTo(HaveOccurred())
+
+			opt.ContinueOnError = true
+		})
+
+		It("syncs every page without errors, removing entries that are gone", func() {
+			Expect(opt.Run(ctx, kitlog.NewNopLogger(), cfg)).To(Succeed())
+
+			Expect(names()).To(ConsistOf("Payments", "Billing", "Search"))
+		})
+	})
+
+	When("an output refers to entries built by a later output", func() {
+		BeforeEach(func() {
+			var err error
+			cfg, err = config.Parse("importer.jsonnet", []byte(`{
+  sync_id: 'sync-id',
+  pipelines: [
+    {
+      sources: [{ inline: { entries: [{ id: 'payments', name: 'Payments', team: 'Core Platform' }] } }],
+      outputs: [{
+        name: 'Service',
+        description: 'Services',
+        type_name: 'Custom["Service"]',
+        source: { name: '$.name', external_id: '$.id' },
+        attributes: [{
+          id: 'team', name: 'Team', type: 'Custom["Team"]', source: '$.team',
+          resolve: { by: ['name'], unresolved: 'error' },
+        }],
+      }],
+    },
+    {
+      sources: [{ inline: { entries: [{ id: 'core-platform', name: 'Core Platform' }] } }],
+      outputs: [{
+        name: 'Team',
+        description: 'Teams',
+        type_name: 'Custom["Team"]',
+        source: { name: '$.name', external_id: '$.id' },
+        attributes: [{ id: 'name', name: 'Name', source: '$.name' }],
+      }],
+    },
+  ],
+}`))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("resolves references to entries that don't exist yet", func() {
+			Expect(opt.Run(ctx, kitlog.NewNopLogger(), cfg)).To(Succeed())
+
+			Expect(api.Entries(catalogType.Id)).To(ConsistOf(And(
+				HaveField("Name", "Payments"),
+				HaveField("AttributeValues", HaveKeyWithValue("team", HaveField("Value.Literal", lo.ToPtr("core-platform")))),
+			)))
+		})
+	})
+})
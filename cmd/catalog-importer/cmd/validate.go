@@ -6,18 +6,35 @@ import (
 	"fmt"
 
 	"github.com/pkg/errors"
+	"github.com/samber/lo"
 
 	"github.com/alecthomas/kingpin/v2"
 	kitlog "github.com/go-kit/kit/log"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/config"
+	"github.com/incident-io/catalog-importer/v2/output"
 )
 
 type ValidateOptions struct {
-	ConfigFile string
+	ConfigFile  string
+	Online      bool
+	APIEndpoint string
+	APIKey      string
 }
 
 func (opt *ValidateOptions) Bind(cmd *kingpin.CmdClause) *ValidateOptions {
 	cmd.Flag("config", "Config file in either Jsonnet, YAML or JSON (e.g. importer.jsonnet)").
 		StringVar(&opt.ConfigFile)
+	cmd.Flag("online", "Also check attribute types, backlinks and paths against the catalog types in your incident.io account").
+		BoolVar(&opt.Online)
+	cmd.Flag("api-endpoint", "Endpoint of the incident.io API").
+		Default("https://api.incident.io").
+		Envar("INCIDENT_ENDPOINT").
+		StringVar(&opt.APIEndpoint)
+	cmd.Flag("api-key", "API key for incident.io").
+		Envar("INCIDENT_API_KEY").
+		StringVar(&opt.APIKey)
 
 	return opt
 }
@@ -28,6 +45,12 @@ func (opt *ValidateOptions) Run(ctx context.Context, logger kitlog.Logger) error
 		return err
 	}
 
+	if opt.Online {
+		if err := opt.validateOnline(ctx, logger, cfg); err != nil {
+			return err
+		}
+	}
+
 	BANNER("Config printed below")
 	output, err := json.MarshalIndent(cfg, "", "  ")
 	if err != nil {
@@ -38,3 +61,77 @@ func (opt *ValidateOptions) Run(ctx context.Context, logger kitlog.Logger) error
 
 	return nil
 }
+
+// validateOnline checks the config against the account, catching mistakes that would
+// otherwise only fail when we update the catalog type schemas.
+func (opt *ValidateOptions) validateOnline(ctx context.Context, logger kitlog.Logger, cfg *config.Config) error {
+	cl, err := client.New(ctx, opt.APIKey, opt.APIEndpoint, Version(), logger, client.WithReadOnly())
+	if err != nil {
+		return err
+	}
+
+	resources, err := cl.CatalogV2ListResourcesWithResponse(ctx)
+	if err != nil {
+		return errors.Wrap(err, "listing catalog resources")
+	}
+	catalogTypes, err := cl.CatalogV2ListTypesWithResponse(ctx)
+	if err != nil {
+		return errors.Wrap(err, "listing catalog types")
+	}
+	OUT("✔ Connected to incident.io API (%s)", opt.APIEndpoint)
+
+	known := config.TypeSchemas{}
+	known.AddResources(resources.JSON200.Resources)
+	known.AddCatalogTypes(catalogTypes.JSON200.CatalogTypes)
+
+	errs := []error{}
+	if err := cfg.ValidateTypes(known); err != nil {
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			errs = append(errs, joined.Unwrap()...)
+		} else {
+			errs = append(errs, err)
+		}
+	}
+
+	// Types in the config that already exist must belong to us, or be ones we can adopt.
+	checkTypeName := func(location, typeName string, outputType *output.Output) {
+		catalogType, exists := lo.Find(catalogTypes.JSON200.CatalogTypes, func(catalogType client.CatalogTypeV2) bool {
+			return catalogType.TypeName == typeName
+		})
+		if !exists {
+			return
+		}
+
+		syncID := catalogType.Annotations[AnnotationSyncID]
+		switch {
+		case syncID == cfg.SyncID || outputType.CanAdopt(syncID):
+		case syncID == "":
+			errs = append(errs, fmt.Errorf("%s: catalog type %s already exists but isn't managed by an importer, set adopt to take it over", location, typeName))
+		default:
+			errs = append(errs, fmt.Errorf("%s: catalog type %s is managed by a different sync ID (%s), add it to adopt_from to take it over", location, typeName, syncID))
+		}
+	}
+	for pipelineIdx, pipeline := range cfg.Pipelines {
+		for outputIdx, outputType := range pipeline.Outputs {
+			location := fmt.Sprintf("pipelines[%d].outputs[%d]", pipelineIdx, outputIdx)
+			checkTypeName(location+".type_name", outputType.TypeName, outputType)
+			for attrIdx, attr := range outputType.Attributes {
+				if attr.Enum != nil {
+					checkTypeName(fmt.Sprintf("%s.attributes[%d].enum.type_name", location, attrIdx), attr.Enum.TypeName, outputType)
+				}
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		OUT("✘ Found %d problems with the config:\n", len(errs))
+		for _, err := range errs {
+			OUT("  %s", err.Error())
+		}
+
+		return fmt.Errorf("config has %d problems that would fail a sync", len(errs))
+	}
+	OUT("✔ Checked attributes against %d resources and %d catalog types", len(resources.JSON200.Resources), len(catalogTypes.JSON200.CatalogTypes))
+
+	return nil
+}
@@ -9,6 +9,6 @@ import (
 
 func main() {
 	if err := cmd.Run(context.Background()); err != nil {
-		kingpin.Fatalf(err.Error())
+		kingpin.Fatalf("%s", err.Error())
 	}
 }
@@ -27,23 +27,38 @@ func init() {
 
 type Config struct {
 	SyncID    string      `json:"sync_id,omitempty"`
+	Strict    bool        `json:"strict,omitempty"`
 	Pipelines []*Pipeline `json:"pipelines"`
+
+	// DeleteThreshold applies to every output that doesn't set its own.
+	DeleteThreshold *output.DeleteThreshold `json:"delete_threshold,omitempty"`
 }
 
 func (c Config) Validate() error {
-	return validation.ValidateStruct(&c,
+	err := validation.ValidateStruct(&c,
 		validation.Field(&c.SyncID, validation.Required.
 			Error("must provide a sync_id to track which resources are managed by this config, and to support clean-up when an output is removed")),
 		validation.Field(&c.Pipelines),
+		validation.Field(&c.DeleteThreshold),
 	)
+	if err != nil {
+		return err
+	}
+
+	// Only once every output is valid can we check the references between them.
+	return c.validateReferences()
 }
 
 // Filter return a new config adjusted so all that remains is configuration pertaining to
 // the given type names.
 func (c Config) Filter(typeNames []string) *Config {
 	clone := c
-	for idx := range clone.Pipelines {
-		clone.Pipelines[idx].Outputs = lo.Filter(clone.Pipelines[idx].Outputs, func(output *output.Output, _ int) bool {
+
+	// Copy each pipeline so we leave the original config untouched, as it may be cached
+	// and reused for later syncs.
+	clone.Pipelines = lo.Map(c.Pipelines, func(pipeline *Pipeline, _ int) *Pipeline {
+		pipelineClone := *pipeline
+		pipelineClone.Outputs = lo.Filter(pipeline.Outputs, func(output *output.Output, _ int) bool {
 			for _, target := range typeNames {
 				if target == output.TypeName {
 					return true
@@ -52,7 +67,9 @@ func (c Config) Filter(typeNames []string) *Config {
 
 			return false
 		})
-	}
+
+		return &pipelineClone
+	})
 
 	clone.Pipelines = lo.Filter(clone.Pipelines, func(pipeline *Pipeline, _ int) bool {
 		return len(pipeline.Outputs) > 0
@@ -77,9 +94,38 @@ func (c Config) Load(context.Context) (Config, error) {
 
 type Pipeline struct {
 	Sources []*source.Source `json:"sources"`
+	Join    *Join            `json:"join,omitempty"`
 	Outputs []*output.Output `json:"outputs"`
 }
 
 func (p Pipeline) Validate() error {
-	return validation.ValidateStruct(&p)
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Sources, validation.By(func(value any) error {
+			names := lo.FilterMap(p.Sources, func(source *source.Source, _ int) (string, bool) {
+				return source.Name, source.Name != ""
+			})
+			if duplicates := lo.FindDuplicates(names); len(duplicates) > 0 {
+				return fmt.Errorf("more than one source is named '%s'", duplicates[0])
+			}
+
+			return nil
+		})),
+		validation.Field(&p.Join, validation.By(func(value any) error {
+			if p.Join == nil {
+				return nil
+			}
+
+			for _, joinSource := range p.Join.Sources {
+				_, ok := lo.Find(p.Sources, func(source *source.Source) bool {
+					return source.Name == joinSource.Name
+				})
+				if !ok {
+					return fmt.Errorf("no source in this pipeline is named '%s'", joinSource.Name)
+				}
+			}
+
+			return nil
+		})),
+		validation.Field(&p.Outputs),
+	)
 }
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"github.com/incident-io/catalog-importer/v2/output"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	Describe("Validate", func() {
+		It("rejects outputs with invalid expressions", func() {
+			cfg, err := parse([]byte(`
+{
+	"sync_id": "something",
+	"pipelines": [
+		{
+			"sources": [
+				{
+					"inline": {
+						"entries": [{"id": "one", "name": "One"}]
+					}
+				}
+			],
+			"outputs": [
+				{
+					"name": "Service",
+					"description": "Services",
+					"type_name": "Custom[\"Service\"]",
+					"source": {
+						"name": "$.name",
+						"external_id": "$.id"
+					},
+					"attributes": [
+						{
+							"id": "owner",
+							"name": "Owner",
+							"type": "String",
+							"source": "$.metadata.owner)"
+						}
+					]
+				}
+			]
+		}
+	]
+}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring(`compiling "$.metadata.owner)"`)))
+		})
+	})
+
+	Describe("Validate sources", func() {
+		configWithSource := func(source string) string {
+			return `
+{
+	"sync_id": "something",
+	"pipelines": [
+		{
+			"sources": [` + source + `],
+			"outputs": [
+				{
+					"name": "Service",
+					"description": "Services",
+					"type_name": "Custom[\"Service\"]",
+					"source": {
+						"name": "$.name",
+						"external_id": "$.id"
+					},
+					"attributes": [
+						{
+							"id": "owner",
+							"name": "Owner",
+							"type": "String",
+							"source": "$.owner"
+						}
+					]
+				}
+			]
+		}
+	]
+}`
+		}
+
+		It("rejects invalid source backends", func() {
+			for source, message := range map[string]string{
+				`{"http": {"endpoint": "not a url"}}`:                                              "endpoint: must be a valid URL",
+				`{"http": {"endpoint": "https://example.com", "method": "DELETE"}}`:                "method must be either GET or POST",
+				`{"http": {"endpoint": "https://example.com", "paginate": {"strategy": "bogus"}}}`: "strategy must be one of",
+				`{"sql": {"driver": "oracle", "dsn": "x", "query": "select 1"}}`:                   "driver",
+				`{"github": {"repos": ["acme/*"], "filter": {"include": ["("]}}}`:                  "include",
+			} {
+				cfg, err := parse([]byte(configWithSource(source)))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cfg.Validate()).To(MatchError(ContainSubstring(message)), source)
+			}
+		})
+
+		It("validates GitHub repo patterns", func() {
+			cfg, err := parse([]byte(configWithSource(`{"github": {"repos": ["acme"], "files": ["catalog-info.yaml"]}}`)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("repos must be of the form owner/repo")))
+
+			cfg, err = parse([]byte(configWithSource(`{"github": {"repos": ["acme-corp/svc-*", "acme-corp/!svc-legacy"], "files": ["catalog-info.yaml"]}}`)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.Validate()).To(Succeed())
+		})
+
+		It("accepts valid source backends", func() {
+			cfg, err := parse([]byte(configWithSource(`{"http": {"endpoint": "https://example.com"}}`)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.Validate()).To(Succeed())
+		})
+	})
+
+	Describe("Filter", func() {
+		It("leaves the original config untouched", func() {
+			cfg := &Config{
+				SyncID: "something",
+				Pipelines: []*Pipeline{
+					{
+						Outputs: []*output.Output{
+							{TypeName: `Custom["Service"]`},
+							{TypeName: `Custom["Team"]`},
+						},
+					},
+				},
+			}
+
+			filtered := cfg.Filter([]string{`Custom["Team"]`})
+			Expect(filtered.Outputs()).To(HaveLen(1))
+			Expect(cfg.Outputs()).To(HaveLen(2))
+		})
+	})
+})
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	kitlog "github.com/go-kit/log"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/incident-io/catalog-importer/v2/expr"
+	"github.com/incident-io/catalog-importer/v2/source"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"gopkg.in/guregu/null.v3"
+)
+
+const (
+	JoinTypeInner = "inner" // only keys found in every source
+	JoinTypeLeft  = "left"  // only keys found in the first source
+	JoinTypeOuter = "outer" // keys found in any source
+
+	JoinConflictFirst = "first" // the first source to set a field wins
+	JoinConflictLast  = "last"  // the last source to set a field wins
+	JoinConflictError = "error" // fail if sources set a field to different values
+)
+
+// Join merges entries from several named sources into a single entry per key, before
+// the outputs run. This allows an output to take attributes from many systems, such as
+// ownership from Backstage and on-call rotations from a CSV.
+//
+// Entries are merged at the top level only: if two sources set the same field, the
+// conflict rule decides which value we keep.
+type Join struct {
+	Type     string        `json:"type"`
+	Key      null.String   `json:"key"`
+	Conflict string        `json:"conflict"`
+	Sources  []*JoinSource `json:"sources"`
+}
+
+// JoinSource refers to a source in the pipeline by name, optionally with its own key
+// expression if its entries identify themselves differently.
+type JoinSource struct {
+	Name string      `json:"name"`
+	Key  null.String `json:"key"`
+}
+
+func (j Join) Validate() error {
+	return validation.ValidateStruct(&j,
+		validation.Field(&j.Type, validation.In(JoinTypeInner, JoinTypeLeft, JoinTypeOuter)),
+		validation.Field(&j.Key, validation.By(validateExpression)),
+		validation.Field(&j.Conflict, validation.In(JoinConflictFirst, JoinConflictLast, JoinConflictError)),
+		validation.Field(&j.Sources, validation.Required, validation.Length(2, 0), validation.By(func(value any) error {
+			if duplicates := lo.FindDuplicates(j.SourceNames()); len(duplicates) > 0 {
+				return fmt.Errorf("source '%s' is joined more than once", duplicates[0])
+			}
+
+			for _, joinSource := range j.Sources {
+				if !joinSource.Key.Valid && !j.Key.Valid {
+					return fmt.Errorf("source '%s' has no key, and there is no default key for the join", joinSource.Name)
+				}
+			}
+
+			return nil
+		})),
+	)
+}
+
+func (s JoinSource) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Name, validation.Required),
+		validation.Field(&s.Key, validation.By(validateExpression)),
+	)
+}
+
+func validateExpression(value any) error {
+	expression, _ := value.(null.String)
+	if !expression.Valid {
+		return nil
+	}
+
+	_, err := expr.Compile(expression.String)
+	return err
+}
+
+// Includes returns true if the named source is part of the join. It's safe to call on a
+// nil join, which includes nothing.
+func (j *Join) Includes(name string) bool {
+	if j == nil || name == "" {
+		return false
+	}
+
+	return lo.Contains(j.SourceNames(), name)
+}
+
+func (j Join) SourceNames() []string {
+	return lo.Map(j.Sources, func(joinSource *JoinSource, _ int) string {
+		return joinSource.Name
+	})
+}
+
+// Apply joins the entries loaded by each source, keyed by source name. Entries that have
+// no key are left out of the join.
+//
+// We record the origin of each joined entry in origins, from the origins of the source
+// entries that contributed to it.
+func (j Join) Apply(ctx context.Context, logger kitlog.Logger, entriesBySource map[string][]source.Entry, origins source.Origins) ([]source.Entry, error) {
+	joinType := lo.Ternary(j.Type == "", JoinTypeLeft, j.Type)
+	conflict := lo.Ternary(j.Conflict == "", JoinConflictFirst, j.Conflict)
+
+	var (
+		keys         = []string{}                  // in the order we first saw them
+		joined       = map[string]source.Entry{}   // by key
+		contributors = map[string][]source.Entry{} // by key
+		sources      = map[string]int{}            // how many sources had each key
+	)
+	for idx, joinSource := range j.Sources {
+		keySource := lo.Ternary(joinSource.Key.Valid, joinSource.Key, j.Key).String
+		program, err := expr.Compile(keySource)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("join.sources[%d].key", idx))
+		}
+
+		seen := map[string]bool{}
+		withoutKey := 0
+		for _, entry := range entriesBySource[joinSource.Name] {
+			key, err := expr.EvaluateSingleValue[string](ctx, logger, program, entry, expr.WithStrict())
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("join.sources[%d]: evaluating key", idx))
+			}
+			if key == nil || *key == "" {
+				withoutKey++
+				continue
+			}
+			if seen[*key] {
+				return nil, fmt.Errorf("join.sources[%d]: source '%s' has more than one entry with key '%s'", idx, joinSource.Name, *key)
+			}
+			seen[*key] = true
+
+			existing, ok := joined[*key]
+			if !ok {
+				// Only an outer join takes keys that the first source doesn't have.
+				if idx > 0 && joinType != JoinTypeOuter {
+					continue
+				}
+
+				existing = source.Entry{}
+				joined[*key] = existing
+				keys = append(keys, *key)
+			}
+			sources[*key]++
+			contributors[*key] = append(contributors[*key], entry)
+
+			for field, value := range entry {
+				current, exists := existing[field]
+				if !exists {
+					existing[field] = value
+					continue
+				}
+
+				switch conflict {
+				case JoinConflictLast:
+					existing[field] = value
+				case JoinConflictError:
+					if !reflect.DeepEqual(current, value) {
+						return nil, fmt.Errorf("join.sources[%d]: source '%s' has a different value for '%s' than an earlier source, for key '%s'",
+							idx, joinSource.Name, field, *key)
+					}
+				}
+			}
+		}
+
+		if withoutKey > 0 {
+			logger.Log("msg", "skipped entries with no key when joining", "source", joinSource.Name, "count", withoutKey)
+		}
+	}
+
+	entries := []source.Entry{}
+	for _, key := range keys {
+		if joinType == JoinTypeInner && sources[key] < len(j.Sources) {
+			continue
+		}
+
+		origins.AddFrom("join", joined[key], contributors[key]...)
+		entries = append(entries, joined[key])
+	}
+
+	return entries, nil
+}
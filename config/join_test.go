@@ -0,0 +1,147 @@
+package config
+
+import (
+	"context"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/incident-io/catalog-importer/v2/source"
+	"gopkg.in/guregu/null.v3"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Join", func() {
+	var (
+		join            Join
+		entriesBySource map[string][]source.Entry
+		origins         source.Origins
+	)
+
+	BeforeEach(func() {
+		join = Join{
+			Key: null.StringFrom("$.id"),
+			Sources: []*JoinSource{
+				{Name: "backstage"},
+				{Name: "oncall", Key: null.StringFrom("$.service")},
+			},
+		}
+		entriesBySource = map[string][]source.Entry{
+			"backstage": {
+				{"id": "api", "owner": "platform"},
+				{"id": "web", "owner": "product"},
+			},
+			"oncall": {
+				{"service": "api", "rotation": "platform-primary"},
+				{"service": "worker", "rotation": "jobs-primary"},
+			},
+		}
+	})
+
+	BeforeEach(func() {
+		origins = source.Origins{}
+	})
+
+	apply := func() ([]source.Entry, error) {
+		return join.Apply(context.Background(), kitlog.NewNopLogger(), entriesBySource, origins)
+	}
+
+	It("defaults to a left join", func() {
+		entries, err := apply()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(Equal([]source.Entry{
+			{"id": "api", "owner": "platform", "service": "api", "rotation": "platform-primary"},
+			{"id": "web", "owner": "product"},
+		}))
+	})
+
+	It("keeps only keys in every source for an inner join", func() {
+		join.Type = JoinTypeInner
+
+		entries, err := apply()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(Equal([]source.Entry{
+			{"id": "api", "owner": "platform", "service": "api", "rotation": "platform-primary"},
+		}))
+	})
+
+	It("keeps keys from any source for an outer join", func() {
+		join.Type = JoinTypeOuter
+
+		entries, err := apply()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(3))
+		Expect(entries[2]).To(Equal(source.Entry{"service": "worker", "rotation": "jobs-primary"}))
+	})
+
+	It("records the origins of the entries that were joined", func() {
+		origins.Add("backstage", entriesBySource["backstage"]...)
+		origins.Add("oncall", entriesBySource["oncall"]...)
+
+		entries, err := apply()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(origins.Get(entries[0])).To(Equal("join (backstage, oncall)"))
+		Expect(origins.Get(entries[1])).To(Equal("join (backstage)"))
+	})
+
+	When("sources set the same field", func() {
+		BeforeEach(func() {
+			entriesBySource["oncall"][0]["owner"] = "sre"
+		})
+
+		It("keeps the first value by default", func() {
+			entries, err := apply()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries[0]["owner"]).To(Equal("platform"))
+		})
+
+		It("keeps the last value when conflict is last", func() {
+			join.Conflict = JoinConflictLast
+
+			entries, err := apply()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries[0]["owner"]).To(Equal("sre"))
+		})
+
+		It("fails when conflict is error", func() {
+			join.Conflict = JoinConflictError
+
+			_, err := apply()
+			Expect(err).To(MatchError(ContainSubstring("source 'oncall' has a different value for 'owner' than an earlier source, for key 'api'")))
+		})
+	})
+
+	It("fails if a source has duplicate keys", func() {
+		entriesBySource["backstage"] = append(entriesBySource["backstage"], source.Entry{"id": "api"})
+
+		_, err := apply()
+		Expect(err).To(MatchError(ContainSubstring("source 'backstage' has more than one entry with key 'api'")))
+	})
+
+	Describe("Validate", func() {
+		It("requires every source to have a key", func() {
+			join.Key = null.String{}
+			Expect(join.Validate()).To(MatchError(ContainSubstring("source 'backstage' has no key")))
+		})
+
+		It("rejects invalid key expressions", func() {
+			join.Sources[1].Key = null.StringFrom("$.service)")
+			Expect(join.Validate()).To(MatchError(ContainSubstring(`compiling "$.service)"`)))
+		})
+
+		It("rejects unknown join types", func() {
+			join.Type = "cross"
+			Expect(join.Validate()).To(MatchError(ContainSubstring("type")))
+		})
+
+		It("rejects joining a source that isn't in the pipeline", func() {
+			pipeline := Pipeline{
+				Sources: []*source.Source{
+					{Name: "backstage", Inline: &source.SourceInline{}},
+				},
+				Join: &join,
+			}
+			Expect(pipeline.Validate()).To(MatchError(ContainSubstring("no source in this pipeline is named 'oncall'")))
+		})
+	})
+})
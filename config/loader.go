@@ -3,6 +3,7 @@ package config
 import (
 	"context"
 	"io/ioutil"
+	"sync"
 	"time"
 
 	kitlog "github.com/go-kit/kit/log"
@@ -40,6 +41,7 @@ func NewCachedLoader(logger kitlog.Logger, loader Loader, ttl time.Duration) Loa
 }
 
 type cachedLoader struct {
+	mu          sync.Mutex
 	logger      kitlog.Logger
 	loader      Loader
 	ttl         time.Duration
@@ -48,6 +50,9 @@ type cachedLoader struct {
 }
 
 func (c *cachedLoader) Load(ctx context.Context) (cfg *Config, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if c.cfg == nil || time.Since(c.lastUpdated) > c.ttl {
 		c.logger.Log("event", "loading_cofig", "msg", "cache expired, loading config")
 		cfg, err := c.loader.Load(ctx)
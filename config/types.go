@@ -0,0 +1,276 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/samber/lo"
+)
+
+// TypeSchemas describes the catalog types we can refer to from attributes, by type name,
+// where each maps attribute ID to the attribute's type.
+//
+// Types with no attributes, such as primitives like String, have a nil schema.
+type TypeSchemas map[string]map[string]string
+
+// AddResources adds every resource that can be used as an attribute type.
+func (s TypeSchemas) AddResources(resources []client.CatalogResourceV2) {
+	for _, resource := range resources {
+		if _, ok := s[resource.Type]; !ok {
+			s[resource.Type] = nil
+		}
+	}
+}
+
+// AddCatalogTypes adds the schemas of catalog types that exist in the account.
+func (s TypeSchemas) AddCatalogTypes(catalogTypes []client.CatalogTypeV2) {
+	for _, catalogType := range catalogTypes {
+		schema := map[string]string{}
+		for _, attr := range catalogType.Schema.Attributes {
+			schema[attr.Id] = attr.Type
+		}
+
+		s[catalogType.TypeName] = schema
+	}
+}
+
+// TypeSchemas returns the schemas of every catalog type this config defines, including
+// the types we generate for enum attributes.
+func (c Config) TypeSchemas() TypeSchemas {
+	schemas := TypeSchemas{}
+	for _, outputType := range c.Outputs() {
+		schema := map[string]string{}
+		for _, attr := range outputType.Attributes {
+			schema[attr.ID] = attributeType(attr)
+			if attr.Enum != nil {
+				schemas[attr.Enum.TypeName] = map[string]string{
+					"description": "String",
+				}
+			}
+		}
+
+		schemas[outputType.TypeName] = schema
+	}
+
+	return schemas
+}
+
+// LocatedError is a problem with a specific field in the config, such as
+// pipelines[0].outputs[2].attributes[5].path[1].
+type LocatedError struct {
+	Location string
+	Err      error
+}
+
+func (e *LocatedError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Location, e.Err)
+}
+
+func (e *LocatedError) Unwrap() error {
+	return e.Err
+}
+
+// eachAttribute calls do for every attribute in the config, in order, along with its
+// location.
+func (c Config) eachAttribute(do func(location string, outputType *output.Output, attr *output.Attribute)) {
+	for pipelineIdx, pipeline := range c.Pipelines {
+		for outputIdx, outputType := range pipeline.Outputs {
+			for attrIdx, attr := range outputType.Attributes {
+				do(fmt.Sprintf("pipelines[%d].outputs[%d].attributes[%d]", pipelineIdx, outputIdx, attrIdx), outputType, attr)
+			}
+		}
+	}
+}
+
+// ValidateTypes checks that every attribute in the config refers to types and attributes
+// that exist, either in the config or in the known types (normally those available in the
+// account). Each error is a LocatedError.
+func (c Config) ValidateTypes(known TypeSchemas) error {
+	schemas := TypeSchemas{}
+	for typeName, schema := range known {
+		schemas[typeName] = schema
+	}
+	for typeName, schema := range c.TypeSchemas() {
+		schemas[typeName] = schema // the config is what the schema will become
+	}
+
+	errs := []error{}
+	c.eachAttribute(func(location string, outputType *output.Output, attr *output.Attribute) {
+		if attr.Enum == nil {
+			if _, ok := schemas[attr.Type.String]; !ok {
+				errs = append(errs, &LocatedError{location + ".type",
+					fmt.Errorf("unknown type '%s', which is neither in the config nor available in your account", attr.Type.String)})
+				return
+			}
+		}
+
+		errs = append(errs, validateAttributeReferences(schemas, location, outputType, attr)...)
+	})
+
+	return errors.Join(errs...)
+}
+
+// validateReferences checks backlinks and paths as far as we can using only the types in
+// the config, skipping any that pass through types defined elsewhere.
+func (c Config) validateReferences() error {
+	schemas := c.TypeSchemas()
+
+	errs := []error{}
+	c.eachAttribute(func(location string, outputType *output.Output, attr *output.Attribute) {
+		errs = append(errs, validateAttributeReferences(schemas, location, outputType, attr)...)
+	})
+	errs = append(errs, c.validateReferenceCycles(schemas)...)
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	validationErrors := validation.Errors{}
+	for _, err := range errs {
+		located := err.(*LocatedError)
+		validationErrors[located.Location] = located.Err
+	}
+
+	return validationErrors
+}
+
+// validateAttributeReferences checks the backlink and path of an attribute resolve to
+// attributes in the given schemas. Types that aren't in the schemas are skipped, as we
+// can't check them.
+func validateAttributeReferences(schemas TypeSchemas, location string, outputType *output.Output, attr *output.Attribute) []error {
+	errs := []error{}
+
+	if attr.BacklinkAttribute.Valid {
+		targetType, backlink := attr.Type.String, attr.BacklinkAttribute.String
+		if schema, known := schemas[targetType]; known {
+			backlinkType, ok := schema[backlink]
+			switch {
+			case !ok:
+				errs = append(errs, &LocatedError{location + ".backlink_attribute",
+					fmt.Errorf("attribute '%s' not found on %s", backlink, targetType)})
+			case backlinkType != outputType.TypeName:
+				errs = append(errs, &LocatedError{location + ".backlink_attribute",
+					fmt.Errorf("attribute '%s' on %s refers to %s, not %s", backlink, targetType, backlinkType, outputType.TypeName)})
+			}
+		}
+	}
+
+	if attr.Path != nil {
+		currentType := outputType.TypeName
+		for hop, attributeID := range attr.Path {
+			schema, known := schemas[currentType]
+			if !known {
+				return errs
+			}
+
+			nextType, ok := schema[attributeID]
+			if !ok {
+				errs = append(errs, &LocatedError{fmt.Sprintf("%s.path[%d]", location, hop),
+					fmt.Errorf("attribute '%s' not found on %s", attributeID, currentType)})
+				return errs
+			}
+
+			currentType = nextType
+		}
+
+		if expected := attributeType(attr); currentType != expected {
+			errs = append(errs, &LocatedError{location + ".path",
+				fmt.Errorf("ends at an attribute of type %s, but the attribute has type %s", currentType, expected)})
+		}
+	}
+
+	return errs
+}
+
+// validateReferenceCycles finds backlink and path attributes that depend on themselves,
+// such as a path that passes through another path attribute leading back to the first.
+func (c Config) validateReferenceCycles(schemas TypeSchemas) []error {
+	type node struct{ typeName, attributeID string }
+	var (
+		order     = []node{}          // derived attributes, in config order
+		locations = map[node]string{} // where each is in the config
+		deps      = map[node][]node{} // attributes each depends on
+	)
+	c.eachAttribute(func(location string, outputType *output.Output, attr *output.Attribute) {
+		self := node{outputType.TypeName, attr.ID}
+		switch {
+		case attr.BacklinkAttribute.Valid:
+			deps[self] = []node{{attr.Type.String, attr.BacklinkAttribute.String}}
+		case attr.Path != nil:
+			currentType := outputType.TypeName
+			for _, attributeID := range attr.Path {
+				deps[self] = append(deps[self], node{currentType, attributeID})
+				currentType = schemas[currentType][attributeID]
+			}
+		default:
+			return
+		}
+
+		order = append(order, self)
+		locations[self] = location
+	})
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	var (
+		errs  = []error{}
+		state = map[node]int{}
+		stack = []node{}
+	)
+	var visit func(current node) bool
+	visit = func(current node) bool {
+		switch state[current] {
+		case visiting:
+			cycleStart := lo.IndexOf(stack, current)
+			cycle := append(append([]node{}, stack[cycleStart:]...), current)
+			errs = append(errs, &LocatedError{locations[stack[cycleStart]],
+				fmt.Errorf("attribute '%s' on %s depends on itself through %s", current.attributeID, current.typeName,
+					strings.Join(lo.Map(cycle, func(n node, _ int) string {
+						return fmt.Sprintf("%s.%s", n.typeName, n.attributeID)
+					}), " -> "))})
+			return true
+		case visited:
+			return false
+		}
+
+		state[current] = visiting
+		stack = append(stack, current)
+		defer func() {
+			state[current] = visited
+			stack = stack[:len(stack)-1]
+		}()
+
+		for _, dep := range deps[current] {
+			if _, derived := locations[dep]; derived && visit(dep) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for _, current := range order {
+		if state[current] == unvisited {
+			visit(current)
+		}
+	}
+
+	return errs
+}
+
+// attributeType is the type of the attribute in the catalog, which for enums is the type
+// we generate for them.
+func attributeType(attr *output.Attribute) string {
+	if attr.Enum != nil {
+		return attr.Enum.TypeName
+	}
+
+	return attr.Type.String
+}
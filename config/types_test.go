@@ -0,0 +1,169 @@
+package config
+
+import (
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"gopkg.in/guregu/null.v3"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ValidateTypes", func() {
+	var (
+		cfg   Config
+		known TypeSchemas
+	)
+
+	BeforeEach(func() {
+		cfg = Config{
+			Pipelines: []*Pipeline{
+				{
+					Outputs: []*output.Output{
+						{
+							TypeName: `Custom["Service"]`,
+							Attributes: []*output.Attribute{
+								{ID: "owner", Type: null.StringFrom(`Custom["Team"]`)},
+								{ID: "repository", Type: null.StringFrom("GitHubRepository")},
+								{ID: "tier", Enum: &output.AttributeEnum{TypeName: `Custom["ServiceTier"]`}},
+								{ID: "owner_slack", Type: null.StringFrom("SlackChannel"), Path: []string{"owner", "slack_channel"}},
+							},
+						},
+					},
+				},
+			},
+		}
+
+		known = TypeSchemas{}
+		known.AddResources([]client.CatalogResourceV2{
+			{Type: "String"}, {Type: "SlackChannel"}, {Type: "GitHubRepository"},
+		})
+		known.AddCatalogTypes([]client.CatalogTypeV2{
+			{
+				TypeName: `Custom["Team"]`,
+				Schema: client.CatalogTypeSchemaV2{
+					Attributes: []client.CatalogTypeAttributeV2{
+						{Id: "slack_channel", Type: "SlackChannel"},
+						{Id: "services", Type: `Custom["Service"]`},
+					},
+				},
+			},
+		})
+	})
+
+	It("accepts types from the config and the account", func() {
+		Expect(cfg.ValidateTypes(known)).To(Succeed())
+	})
+
+	It("rejects unknown types", func() {
+		cfg.Pipelines[0].Outputs[0].Attributes[1].Type = null.StringFrom("GitLabRepository")
+		Expect(cfg.ValidateTypes(known)).To(MatchError(
+			`pipelines[0].outputs[0].attributes[1].type: unknown type 'GitLabRepository', which is neither in the config nor available in your account`))
+	})
+
+	It("rejects paths through attributes that don't exist", func() {
+		cfg.Pipelines[0].Outputs[0].Attributes[3].Path = []string{"owner", "slack"}
+		Expect(cfg.ValidateTypes(known)).To(MatchError(
+			`pipelines[0].outputs[0].attributes[3].path[1]: attribute 'slack' not found on Custom["Team"]`))
+	})
+
+	It("rejects paths that end at a different type", func() {
+		cfg.Pipelines[0].Outputs[0].Attributes[3].Type = null.StringFrom("String")
+		Expect(cfg.ValidateTypes(known)).To(MatchError(ContainSubstring(
+			"path: ends at an attribute of type SlackChannel, but the attribute has type String")))
+	})
+
+	It("checks backlinks refer back to the output", func() {
+		cfg.Pipelines[0].Outputs[0].Attributes[0].BacklinkAttribute = null.StringFrom("services")
+		Expect(cfg.ValidateTypes(known)).To(Succeed())
+
+		cfg.Pipelines[0].Outputs[0].Attributes[0].BacklinkAttribute = null.StringFrom("slack_channel")
+		Expect(cfg.ValidateTypes(known)).To(MatchError(ContainSubstring(
+			`backlink_attribute: attribute 'slack_channel' on Custom["Team"] refers to SlackChannel, not Custom["Service"]`)))
+	})
+})
+
+var _ = Describe("Config.Validate references", func() {
+	var cfg Config
+
+	attribute := func(id, attrType string) *output.Attribute {
+		return &output.Attribute{ID: id, Name: id, Type: null.StringFrom(attrType)}
+	}
+	outputFor := func(typeName string, attributes ...*output.Attribute) *output.Output {
+		return &output.Output{
+			Name:        typeName,
+			Description: typeName,
+			TypeName:    typeName,
+			Source:      output.SourceConfig{Name: "$.name", ExternalID: "$.id"},
+			Attributes:  attributes,
+		}
+	}
+
+	BeforeEach(func() {
+		cfg = Config{
+			SyncID: "test",
+			Pipelines: []*Pipeline{
+				{
+					Outputs: []*output.Output{
+						outputFor(`Custom["Team"]`,
+							attribute("slack_channel", "SlackChannel"),
+							attribute("manager", "SlackUser"),
+						),
+						outputFor(`Custom["Service"]`,
+							attribute("owner", `Custom["Team"]`),
+							attribute("repository", "GitHubRepository"),
+						),
+					},
+				},
+			},
+		}
+	})
+
+	It("accepts paths through types in the config", func() {
+		attr := attribute("owner_slack", "SlackChannel")
+		attr.Path = []string{"owner", "slack_channel"}
+		cfg.Pipelines[0].Outputs[1].Attributes = append(cfg.Pipelines[0].Outputs[1].Attributes, attr)
+
+		Expect(cfg.Validate()).To(Succeed())
+	})
+
+	It("skips hops through types defined outside the config", func() {
+		attr := attribute("repository_owner", "GitHubUser")
+		attr.Path = []string{"repository", "owner"}
+		cfg.Pipelines[0].Outputs[1].Attributes = append(cfg.Pipelines[0].Outputs[1].Attributes, attr)
+
+		Expect(cfg.Validate()).To(Succeed())
+	})
+
+	It("rejects paths through attributes that don't exist", func() {
+		attr := attribute("owner_slack", "SlackChannel")
+		attr.Path = []string{"team", "slack_channel"}
+		cfg.Pipelines[0].Outputs[1].Attributes = append(cfg.Pipelines[0].Outputs[1].Attributes, attr)
+
+		Expect(cfg.Validate()).To(MatchError(
+			`pipelines[0].outputs[1].attributes[2].path[0]: attribute 'team' not found on Custom["Service"].`))
+	})
+
+	It("rejects backlinks to attributes that don't refer back", func() {
+		attr := attribute("services", `Custom["Service"]`)
+		attr.Array, attr.BacklinkAttribute = true, null.StringFrom("repository")
+		cfg.Pipelines[0].Outputs[0].Attributes = append(cfg.Pipelines[0].Outputs[0].Attributes, attr)
+
+		Expect(cfg.Validate()).To(MatchError(ContainSubstring(
+			`pipelines[0].outputs[0].attributes[2].backlink_attribute: attribute 'repository' on Custom["Service"] refers to GitHubRepository, not Custom["Team"]`)))
+
+		attr.BacklinkAttribute = null.StringFrom("owner")
+		Expect(cfg.Validate()).To(Succeed())
+	})
+
+	It("rejects attributes that depend on themselves", func() {
+		parent := attribute("parent", `Custom["Team"]`)
+		parent.Path = []string{"grandparent"}
+		grandparent := attribute("grandparent", `Custom["Team"]`)
+		grandparent.Path = []string{"parent"}
+		cfg.Pipelines[0].Outputs[0].Attributes = append(cfg.Pipelines[0].Outputs[0].Attributes, parent, grandparent)
+
+		Expect(cfg.Validate()).To(MatchError(
+			`pipelines[0].outputs[0].attributes[2]: attribute 'parent' on Custom["Team"] depends on itself through Custom["Team"].parent -> Custom["Team"].grandparent -> Custom["Team"].parent.`))
+	})
+})
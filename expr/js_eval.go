@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	kitlog "github.com/go-kit/log"
@@ -14,75 +15,205 @@ import (
 	underscore "github.com/robertkrimen/otto/underscore"
 )
 
-var vm *otto.Otto
-
 func init() {
-
 	underscore.Enable()
+}
 
-	// Create a Javascript virtual machine that we'll use for evaluating the source
-	// expression. We must be very careful: this is executing code on behalf of others, so
-	// comes with all normal warnings.
-	vm = otto.New()
-	vm.Interrupt = make(chan func(), 1)
+// Program is a compiled Javascript expression, which can be evaluated many times (and
+// concurrently) without re-parsing the source.
+type Program struct {
+	Source string
+	script *otto.Script
+}
 
+// Compile parses the source expression, returning an error if it isn't valid Javascript.
+func Compile(source string) (*Program, error) {
+	return defaultEvaluator.Compile(source)
 }
 
-// EvaluateJavascript can evaluate a source Javascript program having set the given
-// subject into the `$` variable.
-func EvaluateJavascript(ctx context.Context, logger kitlog.Logger, source string, subject any) (result otto.Value, err error) {
-	var halted bool
-	defer func() {
-		if caught := recover(); caught != nil {
-			if halted {
-				err = errors.Wrap(errors.New("timed out executing Javascript code"), err.Error())
-			} else {
-				panic(caught) // it wasn't our interrupt handler, repanic
-			}
-		}
-	}()
+// MustCompile is like Compile but panics if the expression can't be compiled.
+func MustCompile(source string) *Program {
+	program, err := Compile(source)
+	if err != nil {
+		panic(err)
+	}
+
+	return program
+}
+
+// EvaluateOption configures how an expression is evaluated.
+type EvaluateOption func(*evaluateOptions)
 
-	if vm == nil {
-		panic("Javascript virtual machine not initialised")
+type evaluateOptions struct {
+	strict  bool
+	onError func(error)
+}
+
+// WithStrict makes evaluation fail when an expression errors at runtime or produces a
+// value we can't convert into the requested type, instead of quietly returning nil.
+func WithStrict() EvaluateOption {
+	return func(opts *evaluateOptions) {
+		opts.strict = true
 	}
+}
 
-	// Start a new function bounded context.
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+// WithOnError calls the given function whenever an expression errors at runtime, whether
+// or not we're in strict mode. This is useful for counting errors that would otherwise
+// be quietly ignored.
+func WithOnError(onError func(error)) EvaluateOption {
+	return func(opts *evaluateOptions) {
+		opts.onError = onError
+	}
+}
+
+func buildEvaluateOptions(opts []EvaluateOption) evaluateOptions {
+	options := evaluateOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return options
+}
+
+// defaultEvaluator is used by the package level Evaluate functions, and is shared by
+// every caller. It's safe for concurrent use, so this is fine.
+var defaultEvaluator = NewEvaluator()
+
+// errTimeout is raised from the interrupt handler when an evaluation takes too long.
+var errTimeout = errors.New("timed out executing Javascript")
+
+// Evaluator evaluates Javascript expressions against a subject, and is safe for concurrent
+// use.
+//
+// Each evaluation checks out its own Javascript virtual machine from a pool, so
+// expressions running in parallel can't see each other's subject, and a timeout in one
+// evaluation can't interrupt another. Virtual machines are expensive to create (they
+// each load underscore) so we return them to the pool for reuse once we're done.
+type Evaluator struct {
+	pool    sync.Pool
+	timeout time.Duration
+}
+
+func NewEvaluator() *Evaluator {
+	return &Evaluator{
+		pool: sync.Pool{
+			New: func() any {
+				// We must be very careful: this is executing code on behalf of others, so
+				// comes with all normal warnings.
+				vm := otto.New()
+				vm.Interrupt = make(chan func(), 1)
+
+				return vm
+			},
+		},
+		timeout: 250 * time.Millisecond,
+	}
+}
+
+// Compile parses the source expression into a program that can be run by any of the
+// evaluator's virtual machines.
+func (e *Evaluator) Compile(source string) (*Program, error) {
+	vm := e.pool.Get().(*otto.Otto)
+	defer e.pool.Put(vm)
+
+	script, err := vm.Compile("", source)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("compiling \"%s\"", source))
+	}
+
+	return &Program{Source: source, script: script}, nil
+}
+
+// Evaluate runs the compiled Javascript program having set the given subject into the `$`
+// variable, then calls handle with the result.
+//
+// The result is only valid for the duration of the handle call, as values like objects
+// and arrays are tied to the virtual machine that produced them, which is returned to the
+// pool after handle returns.
+func (e *Evaluator) Evaluate(ctx context.Context, logger kitlog.Logger, program *Program, subject any, handle func(result otto.Value) error, opts ...EvaluateOption) (err error) {
+	options := buildEvaluateOptions(opts)
+
+	vm := e.pool.Get().(*otto.Otto)
+	defer e.pool.Put(vm)
 
 	// If we haven't finished execution after our timeout, we trigger the interrupt handler.
-	SafelyGo(func() {
+	// We wait for the watcher to exit and drain any interrupt it queued before the machine
+	// goes back into the pool, otherwise it would fire during the next evaluation.
+	finished, watcherDone := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+
+		timer := time.NewTimer(e.timeout)
+		defer timer.Stop()
+
 		select {
-		case <-time.After(250 * time.Millisecond):
+		case <-timer.C:
 			vm.Interrupt <- func() {
-				panic("timed out executing Javascript")
+				panic(errTimeout)
 			}
 		case <-ctx.Done():
+			vm.Interrupt <- func() {
+				panic(ctx.Err())
+			}
+		case <-finished:
 			// do nothing, we finished executing
 		}
-	})
+	}()
+	defer func() {
+		close(finished)
+		<-watcherDone
+		select {
+		case <-vm.Interrupt:
+		default:
+		}
+	}()
+
+	defer func() {
+		if caught := recover(); caught != nil {
+			if caught == errTimeout || caught == ctx.Err() {
+				err = errors.Wrap(caught.(error), fmt.Sprintf("evaluating \"%s\"", program.Source))
+			} else {
+				panic(caught) // it wasn't our interrupt handler, repanic
+			}
+		}
+	}()
 
 	// Set the subject of the expression in a variable called $ as a simple handle to access
 	// everything.
 	_ = vm.Set("$", subject)
 
-	// Evaluate the source (eg. the script) against the subject, set above.
-	outResult, err := vm.Run(source)
+	// Evaluate the program (eg. the script) against the subject, set above.
+	result, err := vm.Run(program.script)
 	if err != nil {
+		if options.onError != nil {
+			options.onError(err)
+		}
+		if options.strict {
+			return errors.Wrap(err, fmt.Sprintf("evaluating \"%s\"", program.Source))
+		}
+
 		// If we've failed to evaluate an expression, let's continue on, but give them some good debug info.
-		level.Debug(logger).Log("msg", fmt.Sprintf("Could not evaluate expression \"%s\": %s. Returning nil", source, string(err.Error())))
-		return outResult, nil
+		level.Debug(logger).Log("msg", fmt.Sprintf("Could not evaluate expression \"%s\": %s. Returning nil", program.Source, string(err.Error())))
+		result = otto.UndefinedValue()
 	}
 
-	return outResult, nil
-
+	return handle(result)
 }
 
-func EvaluateArray[ReturnType any](ctx context.Context, logger kitlog.Logger, source string, subject any) ([]ReturnType, error) {
-	result, err := EvaluateJavascript(ctx, logger, source, subject)
+func EvaluateArray[ReturnType any](ctx context.Context, logger kitlog.Logger, program *Program, subject any, opts ...EvaluateOption) (resultValues []ReturnType, err error) {
+	err = defaultEvaluator.Evaluate(ctx, logger, program, subject, func(result otto.Value) error {
+		resultValues, err = evaluateArray[ReturnType](ctx, logger, program.Source, result, opts...)
+		return err
+	}, opts...)
 	if err != nil {
 		return nil, errors.Wrap(err, "evaluating array value")
 	}
+
+	return resultValues, nil
+}
+
+func evaluateArray[ReturnType any](ctx context.Context, logger kitlog.Logger, source string, result otto.Value, opts ...EvaluateOption) ([]ReturnType, error) {
+	options := buildEvaluateOptions(opts)
 	if result.IsNull() || result.IsUndefined() {
 		return nil, nil
 	}
@@ -104,6 +235,8 @@ func EvaluateArray[ReturnType any](ctx context.Context, logger kitlog.Logger, so
 
 				evaluatedValues = append(evaluatedValues, element)
 			}
+		} else if options.strict {
+			return nil, fmt.Errorf("expected an array but expression %s evaluated to an object", source)
 		}
 	} else {
 		// Even if the input doesn't seem to be multi-value,
@@ -115,8 +248,12 @@ func EvaluateArray[ReturnType any](ctx context.Context, logger kitlog.Logger, so
 	// Now parse each nested value and return the final slice.
 	resultValues := []ReturnType{}
 	for _, evaluatedValue := range evaluatedValues {
-		resultValue, err := EvaluateResultType[ReturnType](ctx, logger, source, evaluatedValue)
+		resultValue, err := EvaluateResultType[ReturnType](ctx, logger, source, evaluatedValue, opts...)
 		if err != nil {
+			if options.strict {
+				return nil, err
+			}
+
 			return nil, nil
 		}
 		if resultValue != nil {
@@ -127,25 +264,30 @@ func EvaluateArray[ReturnType any](ctx context.Context, logger kitlog.Logger, so
 	return resultValues, nil
 }
 
-func EvaluateSingleValue[ReturnType any](ctx context.Context, logger kitlog.Logger, source string, subject any) (*ReturnType, error) {
-	var emptyResult *ReturnType
-	result, err := EvaluateJavascript(ctx, logger, source, subject)
-	if err != nil {
-		return emptyResult, errors.Wrap(err, "evaluating single value")
-	}
-	if result.IsNull() || result.IsUndefined() {
-		return nil, nil
-	}
+func EvaluateSingleValue[ReturnType any](ctx context.Context, logger kitlog.Logger, program *Program, subject any, opts ...EvaluateOption) (resultValue *ReturnType, err error) {
+	var evaluateErr error
+	err = defaultEvaluator.Evaluate(ctx, logger, program, subject, func(result otto.Value) error {
+		if result.IsNull() || result.IsUndefined() {
+			return nil
+		}
 
-	resultValue, err := EvaluateResultType[ReturnType](ctx, logger, source, result)
+		// Type errors are returned as-is, rather than wrapped as an evaluation failure.
+		resultValue, evaluateErr = EvaluateResultType[ReturnType](ctx, logger, program.Source, result, opts...)
+		return nil
+	}, opts...)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "evaluating single value")
+	}
+	if evaluateErr != nil {
+		return nil, evaluateErr
 	}
 
 	return resultValue, nil
 }
 
-func EvaluateResultType[ReturnType any](ctx context.Context, logger kitlog.Logger, source string, result otto.Value) (*ReturnType, error) {
+func EvaluateResultType[ReturnType any](ctx context.Context, logger kitlog.Logger, source string, result otto.Value, opts ...EvaluateOption) (*ReturnType, error) {
+	options := buildEvaluateOptions(opts)
+
 	var resultValue *ReturnType
 	switch {
 	case result.IsBoolean():
@@ -217,25 +359,21 @@ func EvaluateResultType[ReturnType any](ctx context.Context, logger kitlog.Logge
 		return resultValue, nil
 
 	case isArray(result):
+		if options.strict {
+			return nil, fmt.Errorf("expected a single value but expression %s evaluated to an array", source)
+		}
 		logger.Log("\n  Source %s evaluates to an array. Assuming this is handled separately\n", source)
 		return resultValue, nil
 
 	default:
+		if options.strict {
+			return nil, fmt.Errorf("unsupported Javascript value type found by expression %s: %s", source, result.Class())
+		}
 		fmt.Fprintf(os.Stderr, "\n  Unsupported Javascript value type found by expression %s: %+v.\n", source, result)
 		return resultValue, nil
 	}
 }
 
-func SafelyGo(do func()) {
-	go func() {
-		defer func() {
-			recover()
-		}()
-
-		do()
-	}()
-}
-
 func isArray(value otto.Value) bool {
 	return value.IsObject() &&
 		(value.Object().Class() == "Array" || value.Object().Class() == "GoSlice")
@@ -2,7 +2,9 @@ package expr
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"sync"
 
 	kitlog "github.com/go-kit/log"
 	"github.com/incident-io/catalog-importer/v2/source"
@@ -44,41 +46,41 @@ var _ = Describe("Javascript evaluation", func() {
 	When("parsing attribute sources", func() {
 		It("returns the correct top-level attribute", func() {
 			topLevelSrc := "$.name"
-			evaluatedResult, err := EvaluateSingleValue[string](ctx, logger, topLevelSrc, sourceEntry)
+			evaluatedResult, err := EvaluateSingleValue[string](ctx, logger, MustCompile(topLevelSrc), sourceEntry)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(*evaluatedResult).To(Equal(sourceEntry["name"]))
 		})
 
 		It("returns a bool as expected", func() {
 			topLevelSrc := "$.important"
-			evaluatedResult, err := EvaluateSingleValue[bool](ctx, logger, topLevelSrc, sourceEntry)
+			evaluatedResult, err := EvaluateSingleValue[bool](ctx, logger, MustCompile(topLevelSrc), sourceEntry)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(*evaluatedResult).To(Equal(sourceEntry["important"]))
 		})
 
 		It("returns a number as expected", func() {
 			topLevelSrc := "$.importance_score"
-			evaluatedResult, err := EvaluateSingleValue[int](ctx, logger, topLevelSrc, sourceEntry)
+			evaluatedResult, err := EvaluateSingleValue[int](ctx, logger, MustCompile(topLevelSrc), sourceEntry)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(*evaluatedResult).To(Equal(sourceEntry["importance_score"]))
 		})
 
 		It("returns a string as expected", func() {
 			topLevelSrc := "$.description"
-			evaluatedResult, err := EvaluateSingleValue[string](ctx, logger, topLevelSrc, sourceEntry)
+			evaluatedResult, err := EvaluateSingleValue[string](ctx, logger, MustCompile(topLevelSrc), sourceEntry)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(*evaluatedResult).To(Equal(sourceEntry["description"]))
 		})
 
 		It("does not parse a value if given the wrong type", func() {
 			topLevelSrc := "$.description"
-			_, err := EvaluateSingleValue[int](ctx, logger, topLevelSrc, sourceEntry)
+			_, err := EvaluateSingleValue[int](ctx, logger, MustCompile(topLevelSrc), sourceEntry)
 			Expect(err).To(HaveOccurred(), "could not convert result of string to int")
 		})
 
 		It("returns nil if the type is not supported", func() {
 			topLevelSrc := "$.metadata"
-			evaluatedResult, err := EvaluateSingleValue[string](ctx, logger, topLevelSrc, sourceEntry)
+			evaluatedResult, err := EvaluateSingleValue[string](ctx, logger, MustCompile(topLevelSrc), sourceEntry)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(evaluatedResult).To(BeNil())
 		})
@@ -86,21 +88,21 @@ var _ = Describe("Javascript evaluation", func() {
 
 	It("manipulates string values as expected", func() {
 		topLevelSrc := "$.name.replace('Component', 'Replacement')"
-		evaluatedResult, err := EvaluateSingleValue[string](ctx, logger, topLevelSrc, sourceEntry)
+		evaluatedResult, err := EvaluateSingleValue[string](ctx, logger, MustCompile(topLevelSrc), sourceEntry)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(*evaluatedResult).To(Equal("Replacement name"))
 	})
 
 	It("parses nested values as expected", func() {
 		topLevelSrc := "$.metadata.namespace"
-		evaluatedResult, err := EvaluateSingleValue[string](ctx, logger, topLevelSrc, sourceEntry)
+		evaluatedResult, err := EvaluateSingleValue[string](ctx, logger, MustCompile(topLevelSrc), sourceEntry)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(*evaluatedResult).To(Equal(sourceEntry["metadata"].(map[string]any)["namespace"]))
 	})
 
 	It("handles possible null values with _.get", func() {
 		nestedSrc := "_.get($.metadata, \"badKey\", \"default value\")"
-		evaluatedResult, err := EvaluateSingleValue[string](ctx, logger, nestedSrc, sourceEntry)
+		evaluatedResult, err := EvaluateSingleValue[string](ctx, logger, MustCompile(nestedSrc), sourceEntry)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(*evaluatedResult).To(Equal("default value"))
 	})
@@ -111,14 +113,14 @@ var _ = Describe("Javascript evaluation", func() {
 			entryName, ok := sourceEntryWithArray["name"].(string)
 			Expect(ok).To(BeTrue())
 			expectedResult := []string{entryName}
-			evaluatedResult, err := EvaluateArray[string](ctx, logger, topLevelSrc, sourceEntryWithArray)
+			evaluatedResult, err := EvaluateArray[string](ctx, logger, MustCompile(topLevelSrc), sourceEntryWithArray)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(evaluatedResult).To(Equal(expectedResult))
 		})
 
 		It("works as expected when given actual array input", func() {
 			topLevelSrc := "$.domains"
-			evaluatedResult, err := EvaluateArray[string](ctx, logger, topLevelSrc, sourceEntryWithArray)
+			evaluatedResult, err := EvaluateArray[string](ctx, logger, MustCompile(topLevelSrc), sourceEntryWithArray)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(evaluatedResult).To(Equal(sourceEntryWithArray["domains"]))
 		})
@@ -127,18 +129,105 @@ var _ = Describe("Javascript evaluation", func() {
 	When("sending invalid source javascript", func() {
 		It("returns nothing if I send a key that isn't present on the entry", func() {
 			topLevelSrc := "$.ghostkey"
-			evaluatedResult, err := EvaluateSingleValue[string](ctx, logger, topLevelSrc, sourceEntry)
+			evaluatedResult, err := EvaluateSingleValue[string](ctx, logger, MustCompile(topLevelSrc), sourceEntry)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(evaluatedResult).To(BeNil())
 		})
 
 		It("returns nil if my JS is invalid", func() {
 			topLevelSrc := "$badKey"
-			evaluatedResult, err := EvaluateArray[string](ctx, logger, topLevelSrc, sourceEntryWithArray)
+			evaluatedResult, err := EvaluateArray[string](ctx, logger, MustCompile(topLevelSrc), sourceEntryWithArray)
 			Expect(err).NotTo(HaveOccurred())
 			// Expecting an array with an empty string here, as that is the empty state for this function
 			Expect(evaluatedResult).To(BeNil())
 		})
 	})
 
+	When("evaluating in strict mode", func() {
+		It("returns runtime errors", func() {
+			_, err := EvaluateSingleValue[string](ctx, logger, MustCompile("$.metdata.namespace"), sourceEntry, WithStrict())
+			Expect(err).To(MatchError(ContainSubstring("TypeError")))
+		})
+
+		It("returns an error for unsupported value types", func() {
+			_, err := EvaluateSingleValue[string](ctx, logger, MustCompile("$.metadata"), sourceEntry, WithStrict())
+			Expect(err).To(MatchError(ContainSubstring("unsupported Javascript value type")))
+		})
+
+		It("returns an error when expecting a single value but given an array", func() {
+			_, err := EvaluateSingleValue[string](ctx, logger, MustCompile("$.domains"), sourceEntryWithArray, WithStrict())
+			Expect(err).To(MatchError(ContainSubstring("evaluated to an array")))
+		})
+
+		It("returns an error for arrays with values of the wrong type", func() {
+			_, err := EvaluateArray[int](ctx, logger, MustCompile("$.domains"), sourceEntryWithArray, WithStrict())
+			Expect(err).To(MatchError(ContainSubstring("could not convert result of string")))
+		})
+
+		It("still returns nil for missing values", func() {
+			evaluatedResult, err := EvaluateSingleValue[string](ctx, logger, MustCompile("$.ghostkey"), sourceEntry, WithStrict())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(evaluatedResult).To(BeNil())
+		})
+	})
+
+	When("given an error handler", func() {
+		It("calls it for runtime errors, even when not strict", func() {
+			var errs []error
+			evaluatedResult, err := EvaluateSingleValue[string](ctx, logger, MustCompile("$.metdata.namespace"), sourceEntry, WithOnError(func(err error) {
+				errs = append(errs, err)
+			}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(evaluatedResult).To(BeNil())
+			Expect(errs).To(ConsistOf(MatchError(ContainSubstring("TypeError"))))
+		})
+	})
+
+	When("compiling expressions", func() {
+		It("returns an error for invalid syntax", func() {
+			_, err := Compile("$.name.replace(")
+			Expect(err).To(MatchError(ContainSubstring("compiling \"$.name.replace(\"")))
+		})
+	})
+
+	When("evaluating concurrently", func() {
+		It("keeps each subject separate", func() {
+			program := MustCompile("$.name")
+
+			var wg sync.WaitGroup
+			results := make([]string, 100)
+			for worker := 0; worker < 4; worker++ {
+				worker := worker
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					for idx := worker; idx < len(results); idx += 4 {
+						result, err := EvaluateSingleValue[string](ctx, logger, program, source.Entry{
+							"name": fmt.Sprintf("entry-%d", idx),
+						})
+						Expect(err).NotTo(HaveOccurred())
+						results[idx] = *result
+					}
+				}()
+			}
+			wg.Wait()
+
+			for idx, result := range results {
+				Expect(result).To(Equal(fmt.Sprintf("entry-%d", idx)))
+			}
+		})
+	})
+
+	When("an expression runs for too long", func() {
+		It("times out without affecting the next evaluation", func() {
+			_, err := EvaluateSingleValue[string](ctx, logger, MustCompile("while(true) {}"), sourceEntry)
+			Expect(err).To(MatchError(ContainSubstring("timed out executing Javascript")))
+
+			evaluatedResult, err := EvaluateSingleValue[string](ctx, logger, MustCompile("$.name"), sourceEntry)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*evaluatedResult).To(Equal(sourceEntry["name"]))
+		})
+	})
 })
@@ -0,0 +1,93 @@
+// Package metrics holds the Prometheus metrics we record while syncing, which are served
+// from /metrics when running the serve command, or written to a textfile for one-shot
+// runs.
+package metrics
+
+import (
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "catalog_importer"
+
+// Registry contains all importer metrics. We avoid the default registry so a textfile
+// only contains our own metrics, and won't clash with those of the node exporter that
+// collects it.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	SourceLoadDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "source_load_duration_seconds",
+		Help:      "Time taken to load entries from each source.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"source"})
+
+	SourceEntries = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "source_entries",
+		Help:      "Number of entries loaded from each source in the last sync.",
+	}, []string{"source"})
+
+	OutputEntries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "output_entries_total",
+		Help:      "Catalog entries processed for each output, by action (created, updated, deleted or unchanged).",
+	}, []string{"type_name", "action"})
+
+	ExpressionErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "expression_errors_total",
+		Help:      "Expressions that errored when evaluated against an entry.",
+	}, []string{"type_name"})
+
+	APIRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_requests_total",
+		Help:      "Requests made to the incident.io API, including retries, by method and status code.",
+	}, []string{"method", "code"})
+
+	APIRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "Latency of each request made to the incident.io API.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	APIRetries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_retries_total",
+		Help:      "Requests to the incident.io API that were retried.",
+	}, []string{"method"})
+
+	SyncRuns = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sync_runs_total",
+		Help:      "Syncs that have finished, by result (success or failure).",
+	}, []string{"result"})
+
+	SyncDuration = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sync_duration_seconds",
+		Help:      "Time taken by the last sync.",
+	})
+
+	LastSuccess = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix timestamp of the last sync that finished without error.",
+	})
+)
+
+// WriteTextfile writes all metrics to the given path in the Prometheus text format, for
+// collection by the node exporter's textfile collector.
+func WriteTextfile(path string) error {
+	if err := prometheus.WriteToTextfile(path, Registry); err != nil {
+		return errors.Wrap(err, "writing metrics textfile")
+	}
+
+	return nil
+}
@@ -2,33 +2,67 @@ package output
 
 import (
 	"context"
+	"runtime"
 
 	kitlog "github.com/go-kit/log"
 	"github.com/incident-io/catalog-importer/v2/expr"
 	"github.com/incident-io/catalog-importer/v2/source"
 	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
 )
 
 // Collect filters the list of entries against the source filter on the output, returning
 // a list of all entries which pass the filter.
+//
+// If the filter fails for some entries, we return those that passed alongside an
+// EntryErrors describing each failure.
 func Collect(ctx context.Context, logger kitlog.Logger, output *Output, entries []source.Entry) ([]source.Entry, error) {
 	if !output.Source.Filter.Valid {
 		return entries, nil // no-op, the filter is blank
 	}
 
-	src := output.Source.Filter.String
+	programs, err := output.Compile()
+	if err != nil {
+		return nil, err
+	}
 
-	filteredEntries := []source.Entry{}
-	for _, entry := range entries {
-		result, err := expr.EvaluateSingleValue[bool](ctx, logger, src, entry)
-		if err != nil {
-			return nil, errors.Wrap(err, "evaluating filter for entry")
-		}
+	// Evaluate the filter for each entry in parallel, then build the filtered list in the
+	// original order once we're done.
+	var (
+		matches     = make([]bool, len(entries))
+		entryErrors = make([]error, len(entries))
+	)
+
+	g := new(errgroup.Group)
+	g.SetLimit(runtime.GOMAXPROCS(0))
+	for idx, entry := range entries {
+		idx, entry := idx, entry
+		g.Go(func() error {
+			result, err := expr.EvaluateSingleValue[bool](ctx, logger, programs.Filter, entry, output.evaluateOptions()...)
+			if err != nil {
+				entryErrors[idx] = errors.Wrap(&EvaluationError{
+					TypeName:   output.TypeName,
+					Field:      "source.filter",
+					Expression: programs.Filter.Source,
+					Entry:      entry,
+					Err:        err,
+				}, "evaluating filter for entry")
 
-		if result != nil && *result {
+				return nil
+			}
+
+			matches[idx] = result != nil && *result
+			return nil
+		})
+	}
+	g.Wait()
+
+	filteredEntries := []source.Entry{}
+	for idx, entry := range entries {
+		if matches[idx] {
 			filteredEntries = append(filteredEntries, entry)
 		}
 	}
 
-	return filteredEntries, nil
+	return filteredEntries, entryErrorsOrNil(entryErrors)
 }
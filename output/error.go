@@ -0,0 +1,49 @@
+package output
+
+import (
+	"fmt"
+
+	"github.com/incident-io/catalog-importer/v2/source"
+)
+
+// EvaluationError is returned when an expression fails to evaluate against an entry,
+// explaining exactly where in the config the expression lives and which entry caused it.
+type EvaluationError struct {
+	TypeName   string       // the output type name, e.g. Custom["Service"]
+	Field      string       // where the expression is configured, e.g. attributes.owner
+	Expression string       // the source of the expression
+	Entry      source.Entry // the entry we evaluated against
+	Origin     string       // where the entry came from, if known
+	Err        error
+}
+
+func (e *EvaluationError) Error() string {
+	origin := e.Origin
+	if origin == "" {
+		origin = "unknown origin"
+	}
+
+	return fmt.Sprintf("output %s: %s: expression %q: entry from %s: %s",
+		e.TypeName, e.Field, e.Expression, origin, e.Err)
+}
+
+func (e *EvaluationError) Unwrap() error {
+	return e.Err
+}
+
+// EntryErrors is returned when some entries couldn't be processed. The entries that were
+// processed successfully are returned alongside it, so callers can choose to either abort
+// or continue without the failed entries.
+type EntryErrors []error
+
+func (e EntryErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+
+	return fmt.Sprintf("%s (and %d more errors)", e[0], len(e)-1)
+}
+
+func (e EntryErrors) Unwrap() []error {
+	return e
+}
@@ -0,0 +1,270 @@
+package output
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+
+	kitlog "github.com/go-kit/log"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/incident-io/catalog-importer/v2/expr"
+	"github.com/incident-io/catalog-importer/v2/source"
+	"github.com/samber/lo"
+	"golang.org/x/sync/errgroup"
+	"gopkg.in/guregu/null.v3"
+)
+
+const (
+	AggregateCollect = "collect" // distinct values across all entries, as a list
+	AggregateCount   = "count"   // number of entries, or entries where source is true
+	AggregateFirst   = "first"   // the value from the first entry that has one
+	AggregateAny     = "any"     // true if source is true for any entry
+	AggregateAll     = "all"     // true if source is true for every entry
+)
+
+// groupFields are set on every group, so can't be used as aggregate IDs.
+var groupFields = []string{"key", "name", "count", "entries"}
+
+// GroupBy turns an output into one entry per distinct key across the source entries,
+// such as building teams from the owners of services.
+//
+// Each group becomes an entry for the output's expressions to evaluate against, with:
+//
+//   - key, the group key
+//   - name, from the name expression (or the key, if not set)
+//   - count, the number of entries in the group
+//   - entries, the source entries in the group
+//
+// Along with a field for each aggregate, named after its ID.
+type GroupBy struct {
+	Key        string       `json:"key"`
+	Name       null.String  `json:"name"`
+	Aggregates []*Aggregate `json:"aggregates"`
+}
+
+func (g GroupBy) Validate() error {
+	return validation.ValidateStruct(&g,
+		validation.Field(&g.Key, validation.Required, validExpression),
+		validation.Field(&g.Name, validExpression),
+		validation.Field(&g.Aggregates, validation.By(func(value any) error {
+			ids := lo.Map(g.Aggregates, func(aggregate *Aggregate, _ int) string {
+				return aggregate.ID
+			})
+			if duplicates := lo.FindDuplicates(ids); len(duplicates) > 0 {
+				return fmt.Errorf("more than one aggregate has id '%s'", duplicates[0])
+			}
+
+			return nil
+		})),
+	)
+}
+
+// Aggregate computes a value over all the entries in a group.
+type Aggregate struct {
+	ID       string      `json:"id"`
+	Function string      `json:"function"`
+	Source   null.String `json:"source"`
+}
+
+func (a Aggregate) Validate() error {
+	return validation.ValidateStruct(&a,
+		validation.Field(&a.ID, validation.Required, validation.NotIn(lo.ToAnySlice(groupFields)...).
+			Error(fmt.Sprintf("cannot be one of %v, as these are set on every group", groupFields))),
+		validation.Field(&a.Function, validation.Required,
+			validation.In(AggregateCollect, AggregateCount, AggregateFirst, AggregateAny, AggregateAll)),
+		validation.Field(&a.Source,
+			validation.Required.When(a.Function != AggregateCount).Error("source is required unless function is count"),
+			validExpression),
+	)
+}
+
+// groupedEntry is what we evaluate from a single source entry when grouping.
+type groupedEntry struct {
+	keys       []string
+	name       *string
+	aggregates map[string]any // by aggregate ID, either []any or *bool
+}
+
+// Group groups the entries by the output's group_by key, returning an entry for each
+// group. An entry whose key is a list is added to every group in that list, and entries
+// without a key are skipped.
+//
+// As with MarshalEntries, if some entries fail to evaluate we return the groups built from
+// the rest alongside an EntryErrors.
+//
+// We record the origin of each group in origins, from the origins of its entries.
+func Group(ctx context.Context, logger kitlog.Logger, output *Output, entries []source.Entry, origins source.Origins) ([]source.Entry, error) {
+	programs, err := output.Compile()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		groupedEntries = make([]*groupedEntry, len(entries))
+		entryErrors    = make([]error, len(entries))
+	)
+
+	g := new(errgroup.Group)
+	g.SetLimit(runtime.GOMAXPROCS(0))
+	for idx, entry := range entries {
+		idx, entry := idx, entry
+		g.Go(func() error {
+			groupedEntries[idx], entryErrors[idx] = groupEntry(ctx, logger, output, programs, entry)
+			return nil
+		})
+	}
+	g.Wait()
+
+	var (
+		keys    = []string{}                  // in the order we first saw them
+		groups  = map[string]source.Entry{}   // by key
+		members = map[string][]source.Entry{} // by key
+		named   = map[string]bool{}           // if we've set the group name from an entry
+	)
+	for idx, grouped := range groupedEntries {
+		if grouped == nil {
+			continue // failed to evaluate
+		}
+
+		for _, key := range lo.Uniq(grouped.keys) {
+			group, ok := groups[key]
+			if !ok {
+				group = source.Entry{
+					"key":     key,
+					"name":    key,
+					"count":   0,
+					"entries": []any{},
+				}
+				for _, aggregate := range output.GroupBy.Aggregates {
+					group[aggregate.ID] = initialAggregate(aggregate)
+				}
+
+				keys = append(keys, key)
+				groups[key] = group
+			}
+
+			if name := lo.FromPtr(grouped.name); name != "" && !named[key] {
+				group["name"], named[key] = name, true
+			}
+			group["count"] = group["count"].(int) + 1
+			group["entries"] = append(group["entries"].([]any), map[string]any(entries[idx]))
+			members[key] = append(members[key], entries[idx])
+
+			for _, aggregate := range output.GroupBy.Aggregates {
+				group[aggregate.ID] = applyAggregate(aggregate, group[aggregate.ID], grouped.aggregates[aggregate.ID])
+			}
+		}
+	}
+
+	return lo.Map(keys, func(key string, _ int) source.Entry {
+		origins.AddFrom("group", groups[key], members[key]...)
+		return groups[key]
+	}), entryErrorsOrNil(entryErrors)
+}
+
+// groupEntry evaluates the group_by expressions against a single source entry.
+func groupEntry(ctx context.Context, logger kitlog.Logger, output *Output, programs *Programs, entry source.Entry) (*groupedEntry, error) {
+	opts := output.evaluateOptions()
+	evaluationError := func(field string, program *expr.Program, err error) error {
+		return &EvaluationError{
+			TypeName:   output.TypeName,
+			Field:      field,
+			Expression: program.Source,
+			Entry:      entry,
+			Err:        err,
+		}
+	}
+
+	keys, err := expr.EvaluateArray[string](ctx, logger, programs.GroupKey, entry, opts...)
+	if err != nil {
+		return nil, evaluationError("group_by.key", programs.GroupKey, err)
+	}
+
+	grouped := &groupedEntry{
+		keys:       lo.Compact(keys),
+		aggregates: map[string]any{},
+	}
+
+	if programs.GroupName != nil {
+		grouped.name, err = expr.EvaluateSingleValue[string](ctx, logger, programs.GroupName, entry, opts...)
+		if err != nil {
+			return nil, evaluationError("group_by.name", programs.GroupName, err)
+		}
+	}
+
+	for _, aggregate := range output.GroupBy.Aggregates {
+		program := programs.Aggregates[aggregate.ID]
+		if program == nil {
+			continue // count without a source
+		}
+
+		field := fmt.Sprintf("group_by.aggregates.%s", aggregate.ID)
+		switch aggregate.Function {
+		case AggregateCollect, AggregateFirst:
+			values, err := expr.EvaluateArray[any](ctx, logger, program, entry, opts...)
+			if err != nil {
+				return nil, evaluationError(field, program, err)
+			}
+
+			grouped.aggregates[aggregate.ID] = values
+		default:
+			value, err := expr.EvaluateSingleValue[bool](ctx, logger, program, entry, opts...)
+			if err != nil {
+				return nil, evaluationError(field, program, err)
+			}
+
+			grouped.aggregates[aggregate.ID] = value
+		}
+	}
+
+	return grouped, nil
+}
+
+func initialAggregate(aggregate *Aggregate) any {
+	switch aggregate.Function {
+	case AggregateCollect:
+		return []any{}
+	case AggregateCount:
+		return 0
+	case AggregateAny:
+		return false
+	case AggregateAll:
+		return true
+	default: // first
+		return nil
+	}
+}
+
+// applyAggregate folds the value evaluated from an entry into the group's current value.
+func applyAggregate(aggregate *Aggregate, current, value any) any {
+	switch aggregate.Function {
+	case AggregateCollect:
+		collected := current.([]any)
+		for _, elem := range value.([]any) {
+			if !lo.ContainsBy(collected, func(existing any) bool { return reflect.DeepEqual(existing, elem) }) {
+				collected = append(collected, elem)
+			}
+		}
+
+		return collected
+	case AggregateFirst:
+		if values := value.([]any); current == nil && len(values) > 0 {
+			return values[0]
+		}
+
+		return current
+	case AggregateCount:
+		if aggregate.Source.Valid && !lo.FromPtr(value.(*bool)) {
+			return current
+		}
+
+		return current.(int) + 1
+	case AggregateAny:
+		return current.(bool) || lo.FromPtr(value.(*bool))
+	case AggregateAll:
+		return current.(bool) && lo.FromPtr(value.(*bool))
+	}
+
+	return current
+}
@@ -0,0 +1,164 @@
+package output
+
+import (
+	"context"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/incident-io/catalog-importer/v2/source"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gopkg.in/guregu/null.v3"
+)
+
+var _ = Describe("Group", func() {
+	var (
+		ctx               context.Context
+		catalogTypeOutput *Output
+		entries           []source.Entry
+		origins           source.Origins
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		catalogTypeOutput = &Output{
+			Name:        "Team",
+			Description: "Teams that own services",
+			TypeName:    `Custom["Team"]`,
+			Source: SourceConfig{
+				Name:       "$.name",
+				ExternalID: "$.key",
+			},
+			Attributes: []*Attribute{
+				{ID: "services", Name: "Services", Type: null.StringFrom("String"), Array: true},
+			},
+			GroupBy: &GroupBy{
+				Key:  "$.owner",
+				Name: null.StringFrom("$.owner_name"),
+				Aggregates: []*Aggregate{
+					{ID: "services", Function: AggregateCollect, Source: null.StringFrom("$.id")},
+					{ID: "tier_one", Function: AggregateCount, Source: null.StringFrom("$.tier == 1")},
+					{ID: "language", Function: AggregateFirst, Source: null.StringFrom("$.language")},
+					{ID: "any_public", Function: AggregateAny, Source: null.StringFrom("$.public")},
+					{ID: "all_public", Function: AggregateAll, Source: null.StringFrom("$.public")},
+				},
+			},
+		}
+
+		entries = []source.Entry{
+			{"id": "api", "owner": "platform", "owner_name": "Platform", "tier": "1", "public": true},
+			{"id": "web", "owner": "product", "owner_name": "Product", "tier": "2", "language": "typescript", "public": true},
+			{"id": "worker", "owner": "platform", "tier": "1", "language": "go", "public": false},
+			{"id": "orphan"},
+		}
+		origins = source.Origins{}
+	})
+
+	group := func() ([]source.Entry, error) {
+		return Group(ctx, kitlog.NewNopLogger(), catalogTypeOutput, entries, origins)
+	}
+
+	It("builds an entry per key, in the order keys were first seen", func() {
+		groups, err := group()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(groups).To(HaveLen(2))
+
+		Expect(groups[0]).To(HaveKeyWithValue("key", "platform"))
+		Expect(groups[0]).To(HaveKeyWithValue("name", "Platform"))
+		Expect(groups[0]).To(HaveKeyWithValue("count", 2))
+		Expect(groups[0]["entries"]).To(HaveLen(2))
+		Expect(groups[1]).To(HaveKeyWithValue("key", "product"))
+	})
+
+	It("computes aggregates over the entries in each group", func() {
+		groups, err := group()
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(groups[0]).To(HaveKeyWithValue("services", []any{"api", "worker"}))
+		Expect(groups[0]).To(HaveKeyWithValue("tier_one", 2))
+		Expect(groups[0]).To(HaveKeyWithValue("language", "go"))
+		Expect(groups[0]).To(HaveKeyWithValue("any_public", true))
+		Expect(groups[0]).To(HaveKeyWithValue("all_public", false))
+
+		Expect(groups[1]).To(HaveKeyWithValue("tier_one", 0))
+		Expect(groups[1]).To(HaveKeyWithValue("all_public", true))
+	})
+
+	It("adds an entry to every group when the key is a list", func() {
+		entries = []source.Entry{
+			{"id": "api", "owner": []any{"platform", "sre"}},
+			{"id": "web", "owner": "product"},
+		}
+
+		groups, err := group()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(groups).To(HaveLen(3))
+		Expect(groups[0]).To(HaveKeyWithValue("services", []any{"api"}))
+		Expect(groups[1]).To(HaveKeyWithValue("key", "sre"))
+		Expect(groups[1]).To(HaveKeyWithValue("name", "sre"))
+	})
+
+	It("records the origins of each group's entries", func() {
+		origins.Add("backstage", entries[0], entries[2])
+		origins.Add("github", entries[1])
+
+		groups, err := group()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(origins.Get(groups[0])).To(Equal("group (backstage)"))
+		Expect(origins.Get(groups[1])).To(Equal("group (github)"))
+	})
+
+	It("produces entries that can be marshalled by the output", func() {
+		groups, err := group()
+		Expect(err).NotTo(HaveOccurred())
+
+		models, err := MarshalEntries(ctx, kitlog.NewNopLogger(), catalogTypeOutput, groups)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(models).To(HaveLen(2))
+		Expect(models[0].ExternalID).To(Equal("platform"))
+		Expect(models[0].Name).To(Equal("Platform"))
+	})
+
+	When("an expression fails in strict mode", func() {
+		BeforeEach(func() {
+			catalogTypeOutput.Strict = true
+			catalogTypeOutput.GroupBy.Aggregates = []*Aggregate{
+				{ID: "language", Function: AggregateFirst, Source: null.StringFrom("$.metadata.language")},
+			}
+		})
+
+		It("returns entry errors alongside the groups that succeeded", func() {
+			entries[1]["metadata"] = map[string]any{"language": "typescript"}
+
+			groups, err := group()
+			Expect(err).To(MatchError(ContainSubstring("group_by.aggregates.language")))
+			Expect(groups).To(HaveLen(1))
+			Expect(groups[0]).To(HaveKeyWithValue("key", "product"))
+		})
+	})
+
+	Describe("Validate", func() {
+		It("requires a key", func() {
+			catalogTypeOutput.GroupBy.Key = ""
+			Expect(catalogTypeOutput.GroupBy.Validate()).To(MatchError(ContainSubstring("key: cannot be blank")))
+		})
+
+		It("rejects unknown functions", func() {
+			catalogTypeOutput.GroupBy.Aggregates[0].Function = "sum"
+			Expect(catalogTypeOutput.GroupBy.Validate()).To(MatchError(ContainSubstring("function: must be a valid value")))
+		})
+
+		It("requires a source unless counting", func() {
+			catalogTypeOutput.GroupBy.Aggregates[0].Source = null.String{}
+			Expect(catalogTypeOutput.GroupBy.Validate()).To(MatchError(ContainSubstring("source is required unless function is count")))
+
+			catalogTypeOutput.GroupBy.Aggregates[0].Function = AggregateCount
+			Expect(catalogTypeOutput.GroupBy.Validate()).To(Succeed())
+		})
+
+		It("rejects aggregate IDs that clash with group fields", func() {
+			catalogTypeOutput.GroupBy.Aggregates[0].ID = "count"
+			Expect(catalogTypeOutput.GroupBy.Validate()).To(MatchError(ContainSubstring("cannot be one of")))
+		})
+	})
+})
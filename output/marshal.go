@@ -3,13 +3,14 @@ package output
 import (
 	"context"
 	"fmt"
+	"runtime"
 
 	kitlog "github.com/go-kit/log"
 	"github.com/incident-io/catalog-importer/v2/client"
 	"github.com/incident-io/catalog-importer/v2/expr"
 	"github.com/incident-io/catalog-importer/v2/source"
-	"github.com/pkg/errors"
 	"github.com/samber/lo"
+	"golang.org/x/sync/errgroup"
 )
 
 type CatalogTypeModel struct {
@@ -112,139 +113,169 @@ func MarshalType(output *Output) (base *CatalogTypeModel, enumTypes []*CatalogTy
 // The majority of the work comes from compiling and evaluating the JS expressions that
 // marshal the catalog entries from source.
 func MarshalEntries(ctx context.Context, logger kitlog.Logger, output *Output, entries []source.Entry) ([]*CatalogEntryModel, error) {
-	nameSource := output.Source.Name
-	externalIDSource := output.Source.ExternalID
-	aliasesSource := output.Source.Aliases
+	programs, err := output.Compile()
+	if err != nil {
+		return nil, err
+	}
+
+	attributeByID := map[string]*Attribute{}
+	for _, attr := range output.Attributes {
+		attributeByID[attr.ID] = attr
+	}
 
+	// Expression evaluation is the most expensive part of a sync, so we marshal entries in
+	// parallel across all available CPUs, writing each model back into its original index
+	// so the output order matches the input.
 	var (
-		attributeByID    = map[string]*Attribute{}
-		attributeSources = map[string]string{}
+		catalogEntryModels = make([]*CatalogEntryModel, len(entries))
+		entryErrors        = make([]error, len(entries))
 	)
-	for _, attr := range output.Attributes {
-		// Use the attribute ID by default if source isn't explicitly provided.
-		source := "$." + attr.ID
-		if attr.Source.Valid {
-			source = attr.Source.String
+
+	g := new(errgroup.Group)
+	g.SetLimit(runtime.GOMAXPROCS(0))
+	for idx, entry := range entries {
+		idx, entry := idx, entry
+		g.Go(func() error {
+			catalogEntryModels[idx], entryErrors[idx] = marshalEntry(ctx, logger, output, programs, attributeByID, entry)
+			return nil
+		})
+	}
+	g.Wait()
+
+	// We return all the entries we could marshal, even if others failed, so the caller can
+	// decide whether to continue without them.
+	return lo.Compact(catalogEntryModels), entryErrorsOrNil(entryErrors)
+}
+
+// entryErrorsOrNil builds an EntryErrors from the non-nil errors, or returns nil if there
+// were none.
+func entryErrorsOrNil(errs []error) error {
+	errs = lo.Compact(errs)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return EntryErrors(errs)
+}
+
+// marshalEntry evaluates all the expressions of an output against a single entry.
+func marshalEntry(ctx context.Context, logger kitlog.Logger, output *Output, programs *Programs, attributeByID map[string]*Attribute, entry source.Entry) (*CatalogEntryModel, error) {
+	opts := output.evaluateOptions()
+	evaluationError := func(field string, program *expr.Program, err error) error {
+		return &EvaluationError{
+			TypeName:   output.TypeName,
+			Field:      field,
+			Expression: program.Source,
+			Entry:      entry,
+			Err:        err,
 		}
-		attributeByID[attr.ID] = attr
-		attributeSources[attr.ID] = source
 	}
 
-	catalogEntryModels := []*CatalogEntryModel{}
-	for _, entry := range entries {
-		name, err := expr.EvaluateSingleValue[string](ctx, logger, nameSource, entry)
+	name, err := expr.EvaluateSingleValue[string](ctx, logger, programs.Name, entry, opts...)
+	if err != nil {
+		return nil, evaluationError("source.name", programs.Name, err)
+	}
+
+	externalID, err := expr.EvaluateSingleValue[string](ctx, logger, programs.ExternalID, entry, opts...)
+	if err != nil {
+		return nil, evaluationError("source.external_id", programs.ExternalID, err)
+	}
+
+	var rank *int
+	if programs.Rank != nil {
+		var err error
+		rank, err = expr.EvaluateSingleValue[int](ctx, logger, programs.Rank, entry, opts...)
 		if err != nil {
-			return nil, errors.Wrap(err, "evaluating entry name")
+			return nil, evaluationError("source.rank", programs.Rank, err)
 		}
+	}
 
-		externalID, err := expr.EvaluateSingleValue[string](ctx, logger, externalIDSource, entry)
+	// Each alias can be either a string or a string array, so evaluate them as an array
+	// (which wraps single values) then concat them together.
+	aliases := []string{}
+	for idx, aliasProgram := range programs.Aliases {
+		aliasArray, err := expr.EvaluateArray[string](ctx, logger, aliasProgram, entry, opts...)
 		if err != nil {
-			return nil, errors.Wrap(err, "evaluating entry external ID")
+			return nil, evaluationError(fmt.Sprintf("source.aliases.%d", idx), aliasProgram, err)
 		}
 
-		var rank *int
-		if rankSource := output.Source.Rank; rankSource.Valid && rankSource.String != "" {
-			var err error
-			rank, err = expr.EvaluateSingleValue[int](ctx, logger, rankSource.String, entry)
-			if err != nil {
-				return nil, errors.Wrap(err, "evaluating entry rank")
+		for _, alias := range aliasArray {
+			if alias != "" {
+				aliases = append(aliases, alias)
 			}
 		}
+	}
 
-		// Try to parse each alias as either a string or a string array, then concat and
-		// dedupe them together.
-		aliases := []string{}
-		for idx, aliasSource := range aliasesSource {
-			toAdd := []string{}
-			alias, err := expr.EvaluateSingleValue[string](ctx, logger, aliasSource, entry)
+	// Attribute values are built best effort, as it might not be the case that upstream
+	// source entries have these fields, or have fields of the correct type.
+	attributeValues := map[string]client.EngineParamBindingPayloadV2{}
+
+	for attributeID, program := range programs.Attributes {
+		binding := client.EngineParamBindingPayloadV2{}
+
+		if attributeByID[attributeID].Array {
+			valueLiterals, err := expr.EvaluateArray[any](ctx, logger, program, entry, opts...)
 			if err != nil {
-				return nil, errors.Wrap(err, fmt.Sprintf("aliases.%d: evaluating entry alias", idx))
+				return nil, evaluationError(fmt.Sprintf("attributes.%s", attributeID), program, err)
 			}
-			if alias == nil {
-				aliasArray, arrayErr := expr.EvaluateArray[string](ctx, logger, aliasSource, entry)
-				if arrayErr != nil {
-					return nil, errors.Wrap(err, fmt.Sprintf("aliases.%d: evaluating entry alias", idx))
-				}
-				toAdd = append(toAdd, aliasArray...)
-			} else {
-				toAdd = append(toAdd, *alias)
+			if valueLiterals == nil {
+				continue
 			}
 
-			for _, alias := range toAdd {
-				if alias != "" {
-					aliases = append(aliases, alias)
-				}
-			}
-		}
-
-		// Attribute values are built best effort, as it might not be the case that upstream
-		// source entries have these fields, or have fields of the correct type.
-		attributeValues := map[string]client.EngineParamBindingPayloadV2{}
-
-		for attributeID, src := range attributeSources {
-			binding := client.EngineParamBindingPayloadV2{}
-
-			if attributeByID[attributeID].Array {
-				valueLiterals, err := expr.EvaluateArray[any](ctx, logger, src, entry)
-				if err != nil {
-					return catalogEntryModels, errors.Wrap(err, "evaluating attribute")
-				}
-				if valueLiterals == nil {
+			arrayValue := []client.EngineParamBindingValuePayloadV2{}
+			for _, literalAny := range valueLiterals {
+				literal, ok := literalAny.(string)
+				if !ok {
 					continue
 				}
 
-				arrayValue := []client.EngineParamBindingValuePayloadV2{}
-				for _, literalAny := range valueLiterals {
-					literal, ok := literalAny.(string)
-					if !ok {
-						continue
-					}
-
-					arrayValue = append(arrayValue, client.EngineParamBindingValuePayloadV2{
-						Literal: lo.ToPtr(literal),
-					})
-				}
-
-				binding.ArrayValue = &arrayValue
-			} else {
-				literal, err := evaluateEntryWithAttributeType(ctx, src, entry, attributeByID[attributeID], logger)
-				if err != nil {
-					return catalogEntryModels, errors.Wrap(err, "evaluating attribute")
-				}
-				if literal == nil {
-					continue
-				}
+				arrayValue = append(arrayValue, client.EngineParamBindingValuePayloadV2{
+					Literal: lo.ToPtr(literal),
+				})
+			}
 
-				binding.Value = &client.EngineParamBindingValuePayloadV2{
-					Literal: literal,
-				}
+			binding.ArrayValue = &arrayValue
+		} else {
+			literal, err := evaluateEntryWithAttributeType(ctx, program, entry, attributeByID[attributeID], logger, opts...)
+			if err != nil {
+				return nil, evaluationError(fmt.Sprintf("attributes.%s", attributeID), program, err)
+			}
+			if literal == nil {
+				continue
 			}
 
-			attributeValues[attributeID] = binding
+			binding.Value = &client.EngineParamBindingValuePayloadV2{
+				Literal: literal,
+			}
 		}
 
-		catalogEntryModel := CatalogEntryModel{
-			Aliases:         aliases,
-			AttributeValues: attributeValues,
-		}
-		if name != nil {
-			catalogEntryModel.Name = *name
-		}
-		if externalID != nil {
-			catalogEntryModel.ExternalID = *externalID
-		}
-		if rank != nil {
-			catalogEntryModel.Rank = int32(*rank)
-		}
-		catalogEntryModels = append(catalogEntryModels, &catalogEntryModel)
+		attributeValues[attributeID] = binding
 	}
 
-	return catalogEntryModels, nil
+	catalogEntryModel := CatalogEntryModel{
+		Aliases:         aliases,
+		AttributeValues: attributeValues,
+	}
+	if name != nil {
+		catalogEntryModel.Name = *name
+	}
+	if externalID != nil {
+		catalogEntryModel.ExternalID = *externalID
+	}
+	if rank != nil {
+		catalogEntryModel.Rank = int32(*rank)
+	}
+
+	return &catalogEntryModel, nil
 }
 
-func evaluateEntryWithAttributeType(ctx context.Context, src string, entry map[string]any, attribute *Attribute, logger kitlog.Logger) (*string, error) {
+func evaluateEntryWithAttributeType(ctx context.Context, program *expr.Program, entry map[string]any, attribute *Attribute, logger kitlog.Logger, opts ...expr.EvaluateOption) (*string, error) {
 	var literal *string
 
+	// We only report errors from the final string evaluation, otherwise an expression that
+	// errors would be reported once for every type we try.
+	typedOpts := append(opts[:len(opts):len(opts)], expr.WithOnError(nil))
+
 	// If we have an attribute type of type Bool or Number, we can try to evaluate the program against the scope
 	// with the appropriate type.
 	// If that fails, we'll fall back to a string literal since we accept passing a boolean or numeric value
@@ -252,17 +283,17 @@ func evaluateEntryWithAttributeType(ctx context.Context, src string, entry map[s
 	if attribute != nil && attribute.Type.Valid {
 		switch attribute.Type.String {
 		case "Bool":
-			literal, _ = evaluateEntryWithType[bool](ctx, src, entry, logger)
+			literal, _ = evaluateEntryWithType[bool](ctx, program, entry, logger, typedOpts...)
 			if literal != nil {
 				return literal, nil
 			}
 		case "Number":
 			// Number accepts float or int, so we'll try to evaluate as a float first.
-			literal, _ = evaluateEntryWithType[float64](ctx, src, entry, logger)
+			literal, _ = evaluateEntryWithType[float64](ctx, program, entry, logger, typedOpts...)
 			if literal != nil {
 				return literal, nil
 			}
-			literal, _ = evaluateEntryWithType[int64](ctx, src, entry, logger)
+			literal, _ = evaluateEntryWithType[int64](ctx, program, entry, logger, typedOpts...)
 			if literal != nil {
 				return literal, nil
 			}
@@ -271,11 +302,11 @@ func evaluateEntryWithAttributeType(ctx context.Context, src string, entry map[s
 
 	// If we have an attribute type of type String, or we failed to evaluate the program against the scope
 	// with the appropriate type, we'll try to evaluate as a string literal.
-	return evaluateEntryWithType[string](ctx, src, entry, logger)
+	return evaluateEntryWithType[string](ctx, program, entry, logger, opts...)
 }
 
-func evaluateEntryWithType[ReturnType any](ctx context.Context, src string, entry map[string]any, logger kitlog.Logger) (*string, error) {
-	literal, err := expr.EvaluateSingleValue[ReturnType](ctx, logger, src, entry)
+func evaluateEntryWithType[ReturnType any](ctx context.Context, program *expr.Program, entry map[string]any, logger kitlog.Logger, opts ...expr.EvaluateOption) (*string, error) {
+	literal, err := expr.EvaluateSingleValue[ReturnType](ctx, logger, program, entry, opts...)
 	if err != nil {
 		return nil, err
 	}
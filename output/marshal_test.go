@@ -2,12 +2,16 @@ package output
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
 
 	kitlog "github.com/go-kit/log"
+	"github.com/incident-io/catalog-importer/v2/metrics"
 	"github.com/incident-io/catalog-importer/v2/source"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"gopkg.in/guregu/null.v3"
 )
 
@@ -105,4 +109,83 @@ var _ = Describe("Marshalling data", func() {
 			})
 		})
 	})
+
+	Describe("strict mode", func() {
+		BeforeEach(func() {
+			catalogTypeOutput = &Output{
+				Name:        "name",
+				Description: "description",
+				TypeName:    `Custom["Component"]`,
+				Strict:      true,
+				Source: SourceConfig{
+					Name:       "$.name",
+					ExternalID: "$.id",
+				},
+				Attributes: []*Attribute{{ID: "namespace", Name: "Namespace", Type: null.StringFrom("String"), Source: null.StringFrom("$.metdata.namespace")}},
+			}
+		})
+
+		It("fails with an error naming the output, attribute and expression", func() {
+			entries := []source.Entry{{"id": "P1236", "name": "Component name 3", "metadata": map[string]any{"namespace": "core"}}}
+			_, err := MarshalEntries(ctx, logger, catalogTypeOutput, entries)
+
+			var evaluationErr *EvaluationError
+			Expect(errors.As(err, &evaluationErr)).To(BeTrue())
+			Expect(evaluationErr.TypeName).To(Equal(`Custom["Component"]`))
+			Expect(evaluationErr.Field).To(Equal("attributes.namespace"))
+			Expect(evaluationErr.Expression).To(Equal("$.metdata.namespace"))
+			Expect(evaluationErr.Entry).To(Equal(entries[0]))
+		})
+
+		It("succeeds when not strict", func() {
+			catalogTypeOutput.Strict = false
+
+			entries := []source.Entry{{"id": "P1236", "name": "Component name 3"}}
+			_, err := MarshalEntries(ctx, logger, catalogTypeOutput, entries)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns the entries that succeeded alongside an error for each that failed", func() {
+			entries := []source.Entry{
+				{"id": "P1", "name": "Component 1", "metdata": map[string]any{"namespace": "core"}},
+				{"id": "P2", "name": "Component 2"},
+				{"id": "P3", "name": "Component 3"},
+			}
+			models, err := MarshalEntries(ctx, logger, catalogTypeOutput, entries)
+			Expect(models).To(HaveLen(1))
+			Expect(models[0].ExternalID).To(Equal("P1"))
+
+			var entryErrors EntryErrors
+			Expect(errors.As(err, &entryErrors)).To(BeTrue())
+			Expect(entryErrors).To(HaveLen(2))
+			Expect(err).To(MatchError(ContainSubstring("(and 1 more errors)")))
+		})
+	})
+
+	Describe("expression errors", func() {
+		DescribeTable("counts each error once, whatever the attribute type",
+			func(attributeType string) {
+				typeName := fmt.Sprintf(`Custom["ErrorsFor%s"]`, attributeType)
+				catalogTypeOutput = &Output{
+					Name:        "name",
+					Description: "description",
+					TypeName:    typeName,
+					Source: SourceConfig{
+						Name:       "$.name",
+						ExternalID: "$.id",
+					},
+					Attributes: []*Attribute{{ID: "value", Name: "Value", Type: null.StringFrom(attributeType), Source: null.StringFrom("$.metdata.value")}},
+				}
+
+				entries := []source.Entry{{"id": "P1", "name": "Component 1"}, {"id": "P2", "name": "Component 2"}}
+				_, err := MarshalEntries(ctx, logger, catalogTypeOutput, entries)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(testutil.ToFloat64(metrics.ExpressionErrors.WithLabelValues(typeName))).To(Equal(2.0))
+			},
+			Entry("String", "String"),
+			Entry("Bool", "Bool"),
+			Entry("Number", "Number"),
+		)
+	})
 })
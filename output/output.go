@@ -1,9 +1,14 @@
 package output
 
 import (
+	"fmt"
 	"regexp"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/incident-io/catalog-importer/v2/expr"
+	"github.com/incident-io/catalog-importer/v2/metrics"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
 	"gopkg.in/guregu/null.v3"
 )
 
@@ -18,6 +23,25 @@ type Output struct {
 	Source      SourceConfig `json:"source"`
 	Attributes  []*Attribute `json:"attributes"`
 	Categories  []string     `json:"categories"`
+	Strict      bool         `json:"strict"`
+
+	// DeleteThreshold limits how many entries a sync can delete from this catalog type.
+	// If not set, we'll use the threshold from the top-level config, if any.
+	DeleteThreshold *DeleteThreshold `json:"delete_threshold"`
+
+	// Adopt takes over a catalog type with this type name that already exists but isn't
+	// managed by any importer, instead of failing when we try to create it.
+	Adopt bool `json:"adopt"`
+
+	// AdoptFrom lists the sync IDs of other importers we can take this catalog type from,
+	// which is how you move a type between importers.
+	AdoptFrom []string `json:"adopt_from"`
+
+	// GroupBy builds one entry per distinct key in the source entries, instead of one
+	// entry per source entry.
+	GroupBy *GroupBy `json:"group_by"`
+
+	programs *Programs // compiled expressions, set by Compile
 }
 
 func (o Output) Validate() error {
@@ -27,9 +51,188 @@ func (o Output) Validate() error {
 		validation.Field(&o.TypeName, validation.Required, validation.Match(regexp.MustCompile(`^Custom\["[A-Z][a-zA-Z]*"\]$`))),
 		validation.Field(&o.Source, validation.Required),
 		validation.Field(&o.Attributes, validation.Required),
+		validation.Field(&o.DeleteThreshold),
+		validation.Field(&o.GroupBy),
+	)
+}
+
+// CanAdopt returns true if we can take over a catalog type that is currently managed by
+// the given sync ID, where an empty sync ID means the type is unmanaged.
+func (o Output) CanAdopt(syncID string) bool {
+	if syncID == "" {
+		return o.Adopt
+	}
+
+	return lo.Contains(o.AdoptFrom, syncID)
+}
+
+// DeleteThreshold protects against a source that partially fails, such as an API that
+// returns half the entries it should, causing us to delete many catalog entries that
+// should still exist.
+type DeleteThreshold struct {
+	MaxDeletes        null.Int   `json:"max_deletes"`         // absolute number of entries
+	MaxDeletesPercent null.Float `json:"max_deletes_percent"` // percentage of existing entries
+}
+
+func (t DeleteThreshold) Validate() error {
+	return validation.ValidateStruct(&t,
+		validation.Field(&t.MaxDeletes, validation.Min(0)),
+		validation.Field(&t.MaxDeletesPercent, validation.Min(0.0), validation.Max(100.0)),
 	)
 }
 
+// Check returns an error if deleting this many of the existing entries would exceed
+// the threshold.
+func (t DeleteThreshold) Check(deleting, existing int) error {
+	if deleting == 0 {
+		return nil
+	}
+
+	if t.MaxDeletes.Valid && int64(deleting) > t.MaxDeletes.Int64 {
+		return fmt.Errorf("would delete %d of %d entries, exceeding max_deletes of %d",
+			deleting, existing, t.MaxDeletes.Int64)
+	}
+
+	if t.MaxDeletesPercent.Valid && existing > 0 {
+		percent := 100 * float64(deleting) / float64(existing)
+		if percent > t.MaxDeletesPercent.Float64 {
+			return fmt.Errorf("would delete %d of %d entries (%.1f%%), exceeding max_deletes_percent of %g%%",
+				deleting, existing, percent, t.MaxDeletesPercent.Float64)
+		}
+	}
+
+	return nil
+}
+
+// Programs are the compiled expressions for an output, built once and reused when
+// evaluating against each entry.
+type Programs struct {
+	Filter     *expr.Program            // nil if there is no filter
+	Name       *expr.Program            //
+	ExternalID *expr.Program            //
+	Rank       *expr.Program            // nil if there is no rank
+	Aliases    []*expr.Program          //
+	Attributes map[string]*expr.Program // by attribute ID
+	GroupKey   *expr.Program            // nil if there is no group_by
+	GroupName  *expr.Program            // nil if there is no group_by name
+	Aggregates map[string]*expr.Program // by aggregate ID, if they have a source
+}
+
+// Compile compiles every expression in the output, caching the result so we only pay the
+// cost once no matter how many entries we evaluate.
+//
+// This isn't safe to call concurrently for the same output, so callers should compile
+// before fanning out.
+func (o *Output) Compile() (*Programs, error) {
+	if o.programs != nil {
+		return o.programs, nil
+	}
+
+	var err error
+	programs := &Programs{
+		Aliases:    []*expr.Program{},
+		Attributes: map[string]*expr.Program{},
+		Aggregates: map[string]*expr.Program{},
+	}
+
+	if o.Source.Filter.Valid {
+		programs.Filter, err = expr.Compile(o.Source.Filter.String)
+		if err != nil {
+			return nil, errors.Wrap(err, "source.filter")
+		}
+	}
+	programs.Name, err = expr.Compile(o.Source.Name)
+	if err != nil {
+		return nil, errors.Wrap(err, "source.name")
+	}
+	programs.ExternalID, err = expr.Compile(o.Source.ExternalID)
+	if err != nil {
+		return nil, errors.Wrap(err, "source.external_id")
+	}
+	if o.Source.Rank.Valid && o.Source.Rank.String != "" {
+		programs.Rank, err = expr.Compile(o.Source.Rank.String)
+		if err != nil {
+			return nil, errors.Wrap(err, "source.rank")
+		}
+	}
+	for idx, alias := range o.Source.Aliases {
+		program, err := expr.Compile(alias)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("source.aliases.%d", idx))
+		}
+
+		programs.Aliases = append(programs.Aliases, program)
+	}
+	for _, attr := range o.Attributes {
+		program, err := expr.Compile(attr.Expression())
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("attributes.%s.source", attr.ID))
+		}
+
+		programs.Attributes[attr.ID] = program
+	}
+	if o.GroupBy != nil {
+		programs.GroupKey, err = expr.Compile(o.GroupBy.Key)
+		if err != nil {
+			return nil, errors.Wrap(err, "group_by.key")
+		}
+		if o.GroupBy.Name.Valid {
+			programs.GroupName, err = expr.Compile(o.GroupBy.Name.String)
+			if err != nil {
+				return nil, errors.Wrap(err, "group_by.name")
+			}
+		}
+		for _, aggregate := range o.GroupBy.Aggregates {
+			if !aggregate.Source.Valid {
+				continue
+			}
+
+			program, err := expr.Compile(aggregate.Source.String)
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("group_by.aggregates.%s.source", aggregate.ID))
+			}
+
+			programs.Aggregates[aggregate.ID] = program
+		}
+	}
+
+	o.programs = programs
+
+	return programs, nil
+}
+
+// evaluateOptions returns the options for evaluating this output's expressions.
+func (o *Output) evaluateOptions() []expr.EvaluateOption {
+	opts := []expr.EvaluateOption{
+		expr.WithOnError(func(error) {
+			metrics.ExpressionErrors.WithLabelValues(o.TypeName).Inc()
+		}),
+	}
+	if o.Strict {
+		opts = append(opts, expr.WithStrict())
+	}
+
+	return opts
+}
+
+// validExpression checks an expression compiles, so we catch syntax errors when we
+// validate config instead of when we evaluate it.
+var validExpression = validation.By(func(value any) error {
+	var source string
+	switch value := value.(type) {
+	case string:
+		source = value
+	case null.String:
+		source = value.String
+	}
+	if source == "" {
+		return nil
+	}
+
+	_, err := expr.Compile(source)
+	return err
+})
+
 // SourceConfig controls how we filter the source for this output's entries, and sets the
 // external ID – used to uniquely identify an entry in the catalog – and the aliases of
 // that entry from the source.
@@ -43,8 +246,11 @@ type SourceConfig struct {
 
 func (s SourceConfig) Validate() error {
 	return validation.ValidateStruct(&s,
-		validation.Field(&s.Name, validation.Required),
-		validation.Field(&s.ExternalID, validation.Required),
+		validation.Field(&s.Filter, validExpression),
+		validation.Field(&s.Name, validation.Required, validExpression),
+		validation.Field(&s.ExternalID, validation.Required, validExpression),
+		validation.Field(&s.Rank, validExpression),
+		validation.Field(&s.Aliases, validation.Each(validExpression)),
 	)
 }
 
@@ -58,12 +264,17 @@ type Attribute struct {
 	BacklinkAttribute null.String    `json:"backlink_attribute"`
 	Path              []string       `json:"path"`
 	SchemaOnly        bool           `json:"schema_only"`
+
+	// Resolve looks up the referenced entries by name or alias, as well as external ID,
+	// for attributes that refer to another catalog type.
+	Resolve *AttributeResolve `json:"resolve"`
 }
 
 func (a Attribute) Validate() error {
 	return validation.ValidateStruct(&a,
 		validation.Field(&a.ID, validation.Required),
 		validation.Field(&a.Name, validation.Required),
+		validation.Field(&a.Source, validExpression),
 		validation.Field(&a.Type,
 			validation.Required.When(a.Enum == nil).Error("type is required when enum is not set"),
 			validation.Empty.When(a.Enum != nil).Error("type cannot be set when enum is provided"),
@@ -72,9 +283,25 @@ func (a Attribute) Validate() error {
 			validation.Required.When(!a.Type.Valid).Error("enum is required if type is not set"),
 			validation.Empty.When(a.Type.Valid).Error("enum cannot be provided when type is set"),
 		),
+		validation.Field(&a.Resolve,
+			validation.Empty.When(!a.Type.Valid || lo.Contains(primitiveTypes, a.Type.String)).
+				Error("resolve can only be set when type refers to another catalog type"),
+			validation.Empty.When(a.BacklinkAttribute.Valid || a.Path != nil).
+				Error("resolve cannot be used with backlink_attribute or path"),
+		),
 	)
 }
 
+// Expression returns the source expression for this attribute, defaulting to the field of
+// the entry with the same name as the attribute ID.
+func (a Attribute) Expression() string {
+	if a.Source.Valid {
+		return a.Source.String
+	}
+
+	return "$." + a.ID
+}
+
 type AttributeEnum struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
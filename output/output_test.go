@@ -0,0 +1,114 @@
+package output
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gopkg.in/guregu/null.v3"
+)
+
+var _ = Describe("Output", func() {
+	var catalogTypeOutput Output
+
+	BeforeEach(func() {
+		catalogTypeOutput = Output{
+			Name:        "Service",
+			Description: "Services in the catalog",
+			TypeName:    `Custom["Service"]`,
+			Source: SourceConfig{
+				Filter:     null.StringFrom("$.enabled"),
+				Name:       "$.name",
+				ExternalID: "$.id",
+				Aliases:    []string{"$.slug"},
+			},
+			Attributes: []*Attribute{
+				{
+					ID:   "tier",
+					Name: "Tier",
+					Type: null.StringFrom("Number"),
+				},
+			},
+		}
+	})
+
+	Describe("Validate", func() {
+		It("accepts valid expressions", func() {
+			Expect(catalogTypeOutput.Validate()).To(Succeed())
+		})
+
+		When("an attribute source has a syntax error", func() {
+			BeforeEach(func() {
+				catalogTypeOutput.Attributes[0].Source = null.StringFrom("$.tier +")
+			})
+
+			It("fails validation", func() {
+				Expect(catalogTypeOutput.Validate()).To(MatchError(ContainSubstring(`compiling "$.tier +"`)))
+			})
+		})
+
+		When("an alias has a syntax error", func() {
+			BeforeEach(func() {
+				catalogTypeOutput.Source.Aliases = []string{"$.slug", "$.slug.replace("}
+			})
+
+			It("fails validation", func() {
+				Expect(catalogTypeOutput.Validate()).To(MatchError(ContainSubstring("aliases")))
+			})
+		})
+	})
+
+	Describe("DeleteThreshold", func() {
+		It("allows deletes within the thresholds", func() {
+			threshold := DeleteThreshold{MaxDeletes: null.IntFrom(10), MaxDeletesPercent: null.FloatFrom(20)}
+			Expect(threshold.Check(2, 10)).To(Succeed())
+		})
+
+		It("rejects more deletes than max_deletes", func() {
+			threshold := DeleteThreshold{MaxDeletes: null.IntFrom(10)}
+			Expect(threshold.Check(11, 100)).To(MatchError("would delete 11 of 100 entries, exceeding max_deletes of 10"))
+		})
+
+		It("rejects deleting a larger percentage than max_deletes_percent", func() {
+			threshold := DeleteThreshold{MaxDeletesPercent: null.FloatFrom(20)}
+			Expect(threshold.Check(3, 10)).To(MatchError("would delete 3 of 10 entries (30.0%), exceeding max_deletes_percent of 20%"))
+		})
+
+		It("fails validation with a percentage over 100", func() {
+			catalogTypeOutput.DeleteThreshold = &DeleteThreshold{MaxDeletesPercent: null.FloatFrom(150)}
+			Expect(catalogTypeOutput.Validate()).To(MatchError(ContainSubstring("max_deletes_percent")))
+		})
+	})
+
+	Describe("CanAdopt", func() {
+		It("does not adopt anything by default", func() {
+			Expect(catalogTypeOutput.CanAdopt("")).To(BeFalse())
+			Expect(catalogTypeOutput.CanAdopt("other-importer")).To(BeFalse())
+		})
+
+		It("adopts unmanaged types with adopt", func() {
+			catalogTypeOutput.Adopt = true
+			Expect(catalogTypeOutput.CanAdopt("")).To(BeTrue())
+			Expect(catalogTypeOutput.CanAdopt("other-importer")).To(BeFalse())
+		})
+
+		It("adopts types from sync IDs in adopt_from", func() {
+			catalogTypeOutput.AdoptFrom = []string{"other-importer"}
+			Expect(catalogTypeOutput.CanAdopt("other-importer")).To(BeTrue())
+			Expect(catalogTypeOutput.CanAdopt("another-importer")).To(BeFalse())
+			Expect(catalogTypeOutput.CanAdopt("")).To(BeFalse())
+		})
+	})
+
+	Describe("Compile", func() {
+		It("compiles every expression once", func() {
+			programs, err := catalogTypeOutput.Compile()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(programs.Filter.Source).To(Equal("$.enabled"))
+			Expect(programs.Aliases).To(HaveLen(1))
+			Expect(programs.Attributes["tier"].Source).To(Equal("$.tier"))
+
+			again, err := catalogTypeOutput.Compile()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(again).To(BeIdenticalTo(programs))
+		})
+	})
+})
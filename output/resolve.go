@@ -0,0 +1,207 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/samber/lo"
+)
+
+const (
+	ResolveByExternalID = "external_id" // the external ID, or catalog entry ID
+	ResolveByName       = "name"        // the entry name
+	ResolveByAlias      = "alias"       // any of the entry aliases
+
+	UnresolvedDrop  = "drop"  // remove the value, and report it
+	UnresolvedKeep  = "keep"  // send the value as-is, and report it
+	UnresolvedError = "error" // fail the output
+)
+
+// primitiveTypes are the attribute types that don't refer to other catalog entries.
+var primitiveTypes = []string{"String", "Text", "Number", "Bool"}
+
+// AttributeResolve looks up the entries that a reference attribute points at using the
+// value from the source, so sources can refer to entries by name or alias instead of
+// external ID.
+type AttributeResolve struct {
+	By         []string `json:"by"`         // defaults to external_id, name then alias
+	Unresolved string   `json:"unresolved"` // defaults to drop
+}
+
+func (r AttributeResolve) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.By, validation.Each(validation.In(ResolveByExternalID, ResolveByName, ResolveByAlias))),
+		validation.Field(&r.Unresolved, validation.In(UnresolvedDrop, UnresolvedKeep, UnresolvedError)),
+	)
+}
+
+func (r AttributeResolve) by() []string {
+	if len(r.By) == 0 {
+		return []string{ResolveByExternalID, ResolveByName, ResolveByAlias}
+	}
+
+	return r.By
+}
+
+// ReferenceIndex finds the entries of a catalog type by external ID, name or alias,
+// returning the value we should send to the API to refer to that entry.
+type ReferenceIndex struct {
+	refs map[string]map[string][]string // by resolve field, then value
+}
+
+func NewReferenceIndex() *ReferenceIndex {
+	return &ReferenceIndex{
+		refs: map[string]map[string][]string{
+			ResolveByExternalID: {},
+			ResolveByName:       {},
+			ResolveByAlias:      {},
+		},
+	}
+}
+
+// AddEntry adds an entry from the live catalog, referring to it by external ID if it has
+// one or its catalog entry ID if not.
+func (i *ReferenceIndex) AddEntry(entry client.CatalogEntryV2) {
+	ref := lo.FromPtr(entry.ExternalId)
+	if ref == "" {
+		ref = entry.Id
+	}
+
+	i.add(ResolveByExternalID, entry.Id, ref)
+	i.add(ResolveByExternalID, ref, ref)
+	i.add(ResolveByName, entry.Name, ref)
+	for _, alias := range entry.Aliases {
+		i.add(ResolveByAlias, alias, ref)
+	}
+}
+
+// AddModel adds an entry built by this sync, which may not exist in the catalog yet.
+func (i *ReferenceIndex) AddModel(model *CatalogEntryModel) {
+	if model.ExternalID == "" {
+		return // we can't refer to it until it has an ID
+	}
+
+	i.add(ResolveByExternalID, model.ExternalID, model.ExternalID)
+	i.add(ResolveByName, model.Name, model.ExternalID)
+	for _, alias := range model.Aliases {
+		i.add(ResolveByAlias, alias, model.ExternalID)
+	}
+}
+
+func (i *ReferenceIndex) add(by, value, ref string) {
+	if value == "" || lo.Contains(i.refs[by][value], ref) {
+		return
+	}
+
+	i.refs[by][value] = append(i.refs[by][value], ref)
+}
+
+// Lookup tries each field in order, returning the matching entries from the first that
+// has any. More than one match means the value is ambiguous.
+func (i *ReferenceIndex) Lookup(value string, by []string) []string {
+	for _, field := range by {
+		if refs := i.refs[field][value]; len(refs) > 0 {
+			return refs
+		}
+	}
+
+	return nil
+}
+
+// UnresolvedReference is a value of a reference attribute that didn't match exactly one
+// entry of the referenced type.
+type UnresolvedReference struct {
+	TypeName    string // of the entry with the reference
+	ExternalID  string // of the entry with the reference
+	AttributeID string
+	Value       string
+	Matches     []string // if ambiguous, the entries it could refer to
+}
+
+func (u UnresolvedReference) Error() string {
+	if len(u.Matches) > 1 {
+		return fmt.Sprintf("attributes.%s: value '%s' of entry '%s' is ambiguous, matching %s",
+			u.AttributeID, u.Value, u.ExternalID, strings.Join(u.Matches, ", "))
+	}
+
+	return fmt.Sprintf("attributes.%s: value '%s' of entry '%s' matches no entry", u.AttributeID, u.Value, u.ExternalID)
+}
+
+// ResolveReferences rewrites the values of any attributes with resolve config to the
+// external IDs of the entries they refer to, using indexFor to find the entries of each
+// referenced type.
+//
+// Values that can't be resolved are dropped or kept depending on the attribute, and
+// returned so they can be reported. If an attribute has unresolved set to error, we
+// return an error instead.
+func ResolveReferences(output *Output, entryModels []*CatalogEntryModel, indexFor func(typeName string) (*ReferenceIndex, error)) ([]UnresolvedReference, error) {
+	unresolved := []UnresolvedReference{}
+	for _, attr := range output.Attributes {
+		if attr.Resolve == nil {
+			continue
+		}
+
+		index, err := indexFor(attr.Type.String)
+		if err != nil {
+			return nil, fmt.Errorf("attributes.%s: loading entries of %s: %w", attr.ID, attr.Type.String, err)
+		}
+
+		attributeUnresolved := []UnresolvedReference{}
+		resolve := func(entry *CatalogEntryModel, value client.EngineParamBindingValuePayloadV2) (client.EngineParamBindingValuePayloadV2, bool) {
+			literal := lo.FromPtr(value.Literal)
+			refs := index.Lookup(literal, attr.Resolve.by())
+			if len(refs) == 1 {
+				return client.EngineParamBindingValuePayloadV2{Literal: lo.ToPtr(refs[0])}, true
+			}
+
+			attributeUnresolved = append(attributeUnresolved, UnresolvedReference{
+				TypeName:    output.TypeName,
+				ExternalID:  entry.ExternalID,
+				AttributeID: attr.ID,
+				Value:       literal,
+				Matches:     refs,
+			})
+
+			return value, attr.Resolve.Unresolved == UnresolvedKeep
+		}
+
+		for _, entry := range entryModels {
+			binding, ok := entry.AttributeValues[attr.ID]
+			if !ok {
+				continue
+			}
+
+			if binding.Value != nil {
+				value, keep := resolve(entry, *binding.Value)
+				if !keep {
+					delete(entry.AttributeValues, attr.ID)
+					continue
+				}
+
+				binding.Value = &value
+			}
+			if binding.ArrayValue != nil {
+				arrayValue := []client.EngineParamBindingValuePayloadV2{}
+				for _, elem := range *binding.ArrayValue {
+					if value, keep := resolve(entry, elem); keep {
+						arrayValue = append(arrayValue, value)
+					}
+				}
+
+				binding.ArrayValue = &arrayValue
+			}
+
+			entry.AttributeValues[attr.ID] = binding
+		}
+
+		if len(attributeUnresolved) > 0 && attr.Resolve.Unresolved == UnresolvedError {
+			return nil, fmt.Errorf("%d values could not be resolved, including: %w", len(attributeUnresolved), attributeUnresolved[0])
+		}
+
+		unresolved = append(unresolved, attributeUnresolved...)
+	}
+
+	return unresolved, nil
+}
@@ -0,0 +1,119 @@
+package output
+
+import (
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/samber/lo"
+	"gopkg.in/guregu/null.v3"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ResolveReferences", func() {
+	var (
+		catalogTypeOutput *Output
+		index             *ReferenceIndex
+		entryModels       []*CatalogEntryModel
+	)
+
+	literal := func(value string) client.EngineParamBindingValuePayloadV2 {
+		return client.EngineParamBindingValuePayloadV2{Literal: lo.ToPtr(value)}
+	}
+
+	BeforeEach(func() {
+		catalogTypeOutput = &Output{
+			TypeName: `Custom["Service"]`,
+			Attributes: []*Attribute{
+				{ID: "team", Type: null.StringFrom(`Custom["Team"]`), Resolve: &AttributeResolve{}},
+				{ID: "contributors", Type: null.StringFrom(`Custom["Team"]`), Array: true, Resolve: &AttributeResolve{}},
+			},
+		}
+
+		index = NewReferenceIndex()
+		index.AddEntry(client.CatalogEntryV2{
+			Id:         "01H000PLATFORM",
+			ExternalId: lo.ToPtr("platform"),
+			Name:       "Platform",
+			Aliases:    []string{"@platform-team"},
+		})
+		index.AddEntry(client.CatalogEntryV2{
+			Id:      "01H000LEGACY",
+			Name:    "Legacy",
+			Aliases: []string{},
+		})
+		index.AddModel(&CatalogEntryModel{
+			ExternalID: "product",
+			Name:       "Product",
+			Aliases:    []string{"@product-team"},
+		})
+
+		entryModels = []*CatalogEntryModel{
+			{
+				ExternalID: "api",
+				AttributeValues: map[string]client.EngineParamBindingPayloadV2{
+					"team": {Value: lo.ToPtr(literal("Platform"))},
+					"contributors": {ArrayValue: &[]client.EngineParamBindingValuePayloadV2{
+						literal("@product-team"), literal("Legacy"), literal("Unknown"),
+					}},
+				},
+			},
+		}
+	})
+
+	resolve := func() ([]UnresolvedReference, error) {
+		return ResolveReferences(catalogTypeOutput, entryModels, func(typeName string) (*ReferenceIndex, error) {
+			Expect(typeName).To(Equal(`Custom["Team"]`))
+			return index, nil
+		})
+	}
+
+	It("rewrites names and aliases to external IDs", func() {
+		unresolved, err := resolve()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(unresolved).To(HaveLen(1))
+		Expect(unresolved[0].Error()).To(Equal("attributes.contributors: value 'Unknown' of entry 'api' matches no entry"))
+
+		attributeValues := entryModels[0].AttributeValues
+		Expect(attributeValues["team"].Value).To(Equal(lo.ToPtr(literal("platform"))))
+		Expect(*attributeValues["contributors"].ArrayValue).To(Equal([]client.EngineParamBindingValuePayloadV2{
+			literal("product"), literal("01H000LEGACY"),
+		}))
+	})
+
+	It("only matches the fields in by", func() {
+		catalogTypeOutput.Attributes[0].Resolve.By = []string{ResolveByExternalID}
+
+		unresolved, err := resolve()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(unresolved).To(HaveLen(2))
+		Expect(entryModels[0].AttributeValues).NotTo(HaveKey("team"))
+	})
+
+	It("keeps unresolved values when configured to", func() {
+		catalogTypeOutput.Attributes[1].Resolve.Unresolved = UnresolvedKeep
+
+		_, err := resolve()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*entryModels[0].AttributeValues["contributors"].ArrayValue).To(ContainElement(literal("Unknown")))
+	})
+
+	It("fails when unresolved is error", func() {
+		catalogTypeOutput.Attributes[1].Resolve.Unresolved = UnresolvedError
+
+		_, err := resolve()
+		Expect(err).To(MatchError(ContainSubstring("1 values could not be resolved")))
+	})
+
+	It("reports values that match more than one entry", func() {
+		index.AddModel(&CatalogEntryModel{ExternalID: "platform-2", Name: "Platform"})
+
+		unresolved, err := resolve()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(unresolved[0].Error()).To(ContainSubstring("is ambiguous, matching platform, platform-2"))
+	})
+
+	It("can only be set on reference attributes", func() {
+		attr := Attribute{ID: "name", Name: "Name", Type: null.StringFrom("String"), Resolve: &AttributeResolve{}}
+		Expect(attr.Validate()).To(MatchError(ContainSubstring("resolve can only be set when type refers to another catalog type")))
+	})
+})
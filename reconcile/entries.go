@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"sync"
 
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/metrics"
 	"github.com/incident-io/catalog-importer/v2/output"
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
@@ -18,6 +20,10 @@ type EntriesClient struct {
 	Delete     func(ctx context.Context, entry *client.CatalogEntryV2) error
 	Create     func(ctx context.Context, payload client.CreateEntryRequestBody) (*client.CatalogEntryV2, error)
 	Update     func(ctx context.Context, entry *client.CatalogEntryV2, payload client.UpdateEntryRequestBody) (*client.CatalogEntryV2, error)
+
+	// Metrics is set if this client changes the real catalog, in which case we count the
+	// entries we reconcile. We don't want a dry-run to count entries it didn't change.
+	Metrics bool
 }
 
 // EntriesClientFromClient wraps a real client with hooks that can create, update and delete
@@ -52,6 +58,7 @@ func EntriesClientFromClient(cl *client.ClientWithResponses) EntriesClient {
 
 			return &result.JSON200.CatalogEntry, err
 		},
+		Metrics: true,
 	}
 }
 
@@ -64,7 +71,95 @@ type EntriesProgress struct {
 	OnUpdateProgress func()
 }
 
-func Entries(ctx context.Context, logger kitlog.Logger, cl EntriesClient, outputType *output.Output, catalogType *client.CatalogTypeV2, entryModels []*output.CatalogEntryModel, progress *EntriesProgress) error {
+// EntriesOption configures how we reconcile entries.
+type EntriesOption func(*entriesOptions)
+
+type entriesOptions struct {
+	continueOnError  bool
+	skipDelete       bool
+	keepUnidentified bool
+	deleteThreshold  *output.DeleteThreshold
+	result           *EntriesResult
+}
+
+// WithContinueOnError keeps going when we fail to create, update or delete an entry,
+// returning all the failures as output.EntryErrors once we've processed everything else.
+func WithContinueOnError() EntriesOption {
+	return func(opts *entriesOptions) {
+		opts.continueOnError = true
+	}
+}
+
+// WithoutDeletes skips deleting entries that are no longer in the models. This is used
+// when we know some entries failed to build, as we'd otherwise delete them.
+func WithoutDeletes() EntriesOption {
+	return func(opts *entriesOptions) {
+		opts.skipDelete = true
+	}
+}
+
+// WithKeepUnidentified keeps entries without an external ID that no model claims by
+// their entry ID, instead of deleting them. We can't tell whether the models ever
+// contained these entries, such as when restoring from a snapshot.
+func WithKeepUnidentified() EntriesOption {
+	return func(opts *entriesOptions) {
+		opts.keepUnidentified = true
+	}
+}
+
+// WithDeleteThreshold refuses to make any changes if we'd delete more entries than the
+// threshold allows.
+func WithDeleteThreshold(threshold *output.DeleteThreshold) EntriesOption {
+	return func(opts *entriesOptions) {
+		opts.deleteThreshold = threshold
+	}
+}
+
+// WithResult records what happened to each entry into the given result.
+func WithResult(result *EntriesResult) EntriesOption {
+	return func(opts *entriesOptions) {
+		opts.result = result
+	}
+}
+
+// EntriesResult lists the external IDs of the entries we reconciled, by what we did to
+// them. Entries without an external ID are listed by their catalog entry ID.
+type EntriesResult struct {
+	Created   []string `json:"created"`
+	Updated   []string `json:"updated"`
+	Deleted   []string `json:"deleted"`
+	Unchanged []string `json:"unchanged"`
+	Skipped   []string `json:"skipped"` // would have been deleted, but deletes were disabled or it had no external ID
+
+	mu sync.Mutex
+}
+
+func (r *EntriesResult) add(ids *[]string, id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	*ids = append(*ids, id)
+}
+
+// EntryError is an error that happened when reconciling a single entry.
+type EntryError struct {
+	ExternalID string
+	Err        error
+}
+
+func (e *EntryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *EntryError) Unwrap() error {
+	return e.Err
+}
+
+func Entries(ctx context.Context, logger kitlog.Logger, cl EntriesClient, outputType *output.Output, catalogType *client.CatalogTypeV2, entryModels []*output.CatalogEntryModel, progress *EntriesProgress, opts ...EntriesOption) error {
+	options := entriesOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	logger = kitlog.With(logger,
 		"catalog_type_id", catalogType.Id,
 		"catalog_type_name", catalogType.TypeName,
@@ -81,6 +176,35 @@ func Entries(ctx context.Context, logger kitlog.Logger, cl EntriesClient, output
 		return errors.Wrap(err, "listing entries")
 	}
 
+	// Record what happens to each entry, even if nobody asked for it, to keep things simple.
+	entriesResult := options.result
+	if entriesResult == nil {
+		entriesResult = new(EntriesResult)
+	}
+	countEntry := func(action string) {
+		if cl.Metrics {
+			metrics.OutputEntries.WithLabelValues(catalogType.TypeName, action).Inc()
+		}
+	}
+
+	// When continuing on error, we collect errors for each entry instead of returning the
+	// first one we hit.
+	var (
+		entryErrors   = output.EntryErrors{}
+		entryErrorsMu sync.Mutex
+	)
+	failEntry := func(externalID *string, err error) error {
+		if !options.continueOnError {
+			return err
+		}
+
+		entryErrorsMu.Lock()
+		defer entryErrorsMu.Unlock()
+		entryErrors = append(entryErrors, &EntryError{ExternalID: lo.FromPtr(externalID), Err: err})
+
+		return nil
+	}
+
 	// Prepare a quick lookup of model by external ID, to power deletion.
 	modelsByExternalID := map[string]*output.CatalogEntryModel{}
 	for _, model := range entryModels {
@@ -89,22 +213,42 @@ func Entries(ctx context.Context, logger kitlog.Logger, cl EntriesClient, output
 
 	{
 		toDelete := []client.CatalogEntryV2{}
-	eachEntry: // for every entry that exists, find any that has no corresponding model
-		for _, entry := range entries {
-			if entry.ExternalId != nil {
-				_, ok := modelsByExternalID[*entry.ExternalId]
-				if ok {
-					continue eachEntry // we know the ID and we've found a match, so skip
-				}
+		for _, entry := range entries { // for every entry that exists, find any that has no corresponding model
+			// Entries without an external ID can be claimed by a model using their entry ID,
+			// such as those we've exported from a type built in the dashboard.
+			_, ok := modelsByExternalID[entryID(entry)]
+			if ok {
+				continue // we know the ID and we've found a match, so skip
+			}
+
+			if entry.ExternalId == nil && options.keepUnidentified {
+				logger.Log("msg", "keeping catalog entry without an external ID", "entry_id", entry.Id)
+				entriesResult.add(&entriesResult.Skipped, entry.Id)
+				continue
 			}
 
-			// We can't find this entry in our model, or it never had an external ID, which
-			// means we want to delete it.
+			// We can't find this entry in our model, which means we want to delete it.
 			toDelete = append(toDelete, entry)
 		}
 
+		if options.skipDelete && len(toDelete) > 0 {
+			logger.Log("msg", fmt.Sprintf("skipping deletion of %d entries, as deletes are disabled", len(toDelete)))
+			for _, entry := range toDelete {
+				entriesResult.add(&entriesResult.Skipped, entryID(entry))
+			}
+			toDelete = []client.CatalogEntryV2{}
+		}
+
 		logger.Log("msg", fmt.Sprintf("found %d entries in the catalog, deleting %d of them", len(entries), len(toDelete)))
 
+		// We delete before creating or updating, so this check happens before we make any
+		// changes to this catalog type.
+		if threshold := options.deleteThreshold; threshold != nil {
+			if err := threshold.Check(len(toDelete), len(entries)); err != nil {
+				return errors.Wrap(err, "delete threshold exceeded (use --ignore-delete-threshold to override)")
+			}
+		}
+
 		g, ctx := errgroup.WithContext(ctx)
 		g.SetLimit(10)
 
@@ -123,10 +267,12 @@ func Entries(ctx context.Context, logger kitlog.Logger, cl EntriesClient, output
 
 				err := cl.Delete(ctx, &entry)
 				if err != nil {
-					return errors.Wrap(err, "unable to destroy catalog entry, got error")
+					return failEntry(entry.ExternalId, errors.Wrap(err, "unable to destroy catalog entry, got error"))
 				}
 
 				logger.Log("msg", "destroyed catalog entry", "catalog_entry_id", entry.Id)
+				countEntry("deleted")
+				entriesResult.add(&entriesResult.Deleted, entryID(entry))
 
 				return nil
 			})
@@ -137,14 +283,20 @@ func Entries(ctx context.Context, logger kitlog.Logger, cl EntriesClient, output
 		}
 	}
 
-	// Prepare a quick lookup of entry by external ID. We'll have deleted all entries
-	// without an external ID now so can ignore those without one.
+	// Prepare a quick lookup of entry by external ID. Any entry without an external ID that
+	// we didn't delete was claimed by its entry ID, so we look those up by that, unless
+	// another entry already has it as an external ID.
 	entriesByExternalID := map[string]*client.CatalogEntryV2{}
 	for _, entry := range entries {
 		if entry.ExternalId != nil {
 			entriesByExternalID[*entry.ExternalId] = lo.ToPtr(entry)
 		}
 	}
+	for _, entry := range entries {
+		if _, ok := entriesByExternalID[entry.Id]; !ok && entry.ExternalId == nil {
+			entriesByExternalID[entry.Id] = lo.ToPtr(entry)
+		}
+	}
 
 	{
 		toCreate := []*output.CatalogEntryModel{}
@@ -183,10 +335,12 @@ func Entries(ctx context.Context, logger kitlog.Logger, cl EntriesClient, output
 					AttributeValues: model.AttributeValues,
 				})
 				if err != nil {
-					return errors.Wrap(err, fmt.Sprintf("unable to create catalog entry with external_id=%s, got error", model.ExternalID))
+					return failEntry(&model.ExternalID, errors.Wrap(err, fmt.Sprintf("unable to create catalog entry with external_id=%s, got error", model.ExternalID)))
 				}
 
 				logger.Log("msg", "created catalog entry", "external_id", model.ExternalID, "entry_id", result.Id)
+				countEntry("created")
+				entriesResult.add(&entriesResult.Created, model.ExternalID)
 
 				return nil
 			})
@@ -218,8 +372,9 @@ func Entries(ctx context.Context, logger kitlog.Logger, cl EntriesClient, output
 			// If we found the entry in the list of all entries, then we need to diff it and
 			// update as appropriate.
 			if entry != nil {
+				// Entries we matched by entry ID need an update to set their external ID.
 				isSame :=
-					entry.Name == model.Name &&
+					entry.Name == model.Name && entry.ExternalId != nil &&
 						reflect.DeepEqual(entry.Aliases, model.Aliases) && entry.Rank == model.Rank
 
 				currentBindings := map[string]client.EngineParamBindingPayloadV2{}
@@ -255,6 +410,8 @@ func Entries(ctx context.Context, logger kitlog.Logger, cl EntriesClient, output
 
 				if isSame && reflect.DeepEqual(model.AttributeValues, currentBindings) {
 					logger.Log("msg", "catalog entry has not changed, not updating", "entry_id", entry.Id)
+					countEntry("unchanged")
+					entriesResult.add(&entriesResult.Unchanged, model.ExternalID)
 					continue eachPayload
 				} else {
 					logger.Log("msg", "catalog entry has changed, scheduling for update", "entry_id", entry.Id)
@@ -291,10 +448,12 @@ func Entries(ctx context.Context, logger kitlog.Logger, cl EntriesClient, output
 					AttributeValues: model.AttributeValues,
 				})
 				if err != nil {
-					return errors.Wrap(err, fmt.Sprintf("unable to update catalog entry with id=%s, got error", entry.Id))
+					return failEntry(&model.ExternalID, errors.Wrap(err, fmt.Sprintf("unable to update catalog entry with id=%s, got error", entry.Id)))
 				}
 
 				logger.Log("msg", "updated catalog entry", "entry_id", entry.Id)
+				countEntry("updated")
+				entriesResult.add(&entriesResult.Updated, model.ExternalID)
 				return nil
 			})
 		}
@@ -304,9 +463,22 @@ func Entries(ctx context.Context, logger kitlog.Logger, cl EntriesClient, output
 		}
 	}
 
+	if len(entryErrors) > 0 {
+		return entryErrors
+	}
+
 	return nil
 }
 
+// entryID identifies an entry by its external ID, or its catalog entry ID if it has none.
+func entryID(entry client.CatalogEntryV2) string {
+	if entry.ExternalId != nil {
+		return *entry.ExternalId
+	}
+
+	return entry.Id
+}
+
 // GetEntries paginates through all catalog entries for the given type.
 func GetEntries(ctx context.Context, cl *client.ClientWithResponses, catalogTypeID string) (catalogType *client.CatalogTypeV2, entries []client.CatalogEntryV2, err error) {
 	var (
@@ -0,0 +1,232 @@
+package reconcile_test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	kitlog "github.com/go-kit/log"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/samber/lo"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/metrics"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+)
+
+// fakeCatalog holds the entries of a single catalog type in memory, and records every
+// change made through its client.
+type fakeCatalog struct {
+	catalogType client.CatalogTypeV2
+	entries     map[string]client.CatalogEntryV2
+	changes     []string
+	seq         int
+	mu          sync.Mutex
+}
+
+func newFakeCatalog(typeName string, entries ...client.CatalogEntryV2) *fakeCatalog {
+	catalog := &fakeCatalog{
+		catalogType: client.CatalogTypeV2{Id: "T1", TypeName: typeName},
+		entries:     map[string]client.CatalogEntryV2{},
+	}
+	for _, entry := range entries {
+		entry.CatalogTypeId = catalog.catalogType.Id
+		if entry.Aliases == nil {
+			entry.Aliases = []string{}
+		}
+		if entry.AttributeValues == nil {
+			entry.AttributeValues = map[string]client.CatalogEntryEngineParamBindingV2{}
+		}
+		catalog.entries[entry.Id] = entry
+	}
+
+	return catalog
+}
+
+func (c *fakeCatalog) Entries() []client.CatalogEntryV2 {
+	defer c.mu.Unlock()
+	c.mu.Lock()
+
+	entries := lo.Values(c.entries)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Id < entries[j].Id
+	})
+
+	return entries
+}
+
+func (c *fakeCatalog) record(change string) {
+	defer c.mu.Unlock()
+	c.mu.Lock()
+
+	c.changes = append(c.changes, change)
+}
+
+func (c *fakeCatalog) Client() reconcile.EntriesClient {
+	return reconcile.EntriesClient{
+		GetEntries: func(ctx context.Context, catalogTypeID string) (*client.CatalogTypeV2, []client.CatalogEntryV2, error) {
+			return lo.ToPtr(c.catalogType), c.Entries(), nil
+		},
+		Delete: func(ctx context.Context, entry *client.CatalogEntryV2) error {
+			c.record("delete " + entry.Id)
+
+			defer c.mu.Unlock()
+			c.mu.Lock()
+			delete(c.entries, entry.Id)
+
+			return nil
+		},
+		Create: func(ctx context.Context, payload client.CreateEntryRequestBody) (*client.CatalogEntryV2, error) {
+			c.record("create " + lo.FromPtr(payload.ExternalId))
+
+			defer c.mu.Unlock()
+			c.mu.Lock()
+			c.seq++
+			entry := client.CatalogEntryV2{
+				Id:              fmt.Sprintf("NEW%d", c.seq),
+				CatalogTypeId:   payload.CatalogTypeId,
+				ExternalId:      payload.ExternalId,
+				Name:            payload.Name,
+				Aliases:         lo.FromPtr(payload.Aliases),
+				Rank:            lo.FromPtr(payload.Rank),
+				AttributeValues: attributeValues(payload.AttributeValues),
+			}
+			c.entries[entry.Id] = entry
+
+			return &entry, nil
+		},
+		Update: func(ctx context.Context, entry *client.CatalogEntryV2, payload client.UpdateEntryRequestBody) (*client.CatalogEntryV2, error) {
+			c.record("update " + entry.Id)
+
+			defer c.mu.Unlock()
+			c.mu.Lock()
+			updated := c.entries[entry.Id]
+			updated.ExternalId = payload.ExternalId
+			updated.Name = payload.Name
+			updated.Aliases = lo.FromPtr(payload.Aliases)
+			updated.Rank = lo.FromPtr(payload.Rank)
+			updated.AttributeValues = attributeValues(payload.AttributeValues)
+			c.entries[entry.Id] = updated
+
+			return &updated, nil
+		},
+	}
+}
+
+func attributeValues(payload map[string]client.EngineParamBindingPayloadV2) map[string]client.CatalogEntryEngineParamBindingV2 {
+	return lo.MapValues(payload, func(binding client.EngineParamBindingPayloadV2, _ string) client.CatalogEntryEngineParamBindingV2 {
+		value := client.CatalogEntryEngineParamBindingV2{}
+		if binding.Value != nil {
+			value.Value = &client.CatalogEntryEngineParamBindingValueV2{Literal: binding.Value.Literal}
+		}
+		if binding.ArrayValue != nil {
+			value.ArrayValue = lo.ToPtr(lo.Map(*binding.ArrayValue, func(element client.EngineParamBindingValuePayloadV2, _ int) client.CatalogEntryEngineParamBindingValueV2 {
+				return client.CatalogEntryEngineParamBindingValueV2{Literal: element.Literal}
+			}))
+		}
+
+		return value
+	})
+}
+
+var _ = Describe("Entries", func() {
+	var (
+		ctx    context.Context
+		logger kitlog.Logger
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		logger = kitlog.NewNopLogger()
+	})
+
+	entryModel := func(externalID, name string) *output.CatalogEntryModel {
+		return &output.CatalogEntryModel{
+			ExternalID:      externalID,
+			Name:            name,
+			Aliases:         []string{},
+			AttributeValues: map[string]client.EngineParamBindingPayloadV2{},
+		}
+	}
+
+	Describe("metrics", func() {
+		reconcileEntries := func(typeName string, withMetrics bool) {
+			catalog := newFakeCatalog(typeName,
+				client.CatalogEntryV2{Id: "E1", ExternalId: lo.ToPtr("unchanged"), Name: "Unchanged"},
+				client.CatalogEntryV2{Id: "E2", ExternalId: lo.ToPtr("updated"), Name: "Before"},
+				client.CatalogEntryV2{Id: "E3", ExternalId: lo.ToPtr("deleted"), Name: "Deleted"},
+			)
+			entriesClient := catalog.Client()
+			entriesClient.Metrics = withMetrics
+
+			err := reconcile.Entries(ctx, logger, entriesClient, &output.Output{TypeName: typeName}, &catalog.catalogType,
+				[]*output.CatalogEntryModel{
+					entryModel("unchanged", "Unchanged"),
+					entryModel("updated", "After"),
+					entryModel("created", "Created"),
+				}, nil)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		count := func(typeName, action string) float64 {
+			return testutil.ToFloat64(metrics.OutputEntries.WithLabelValues(typeName, action))
+		}
+
+		It("counts entries changed by a client with metrics", func() {
+			reconcileEntries(`Custom["WithMetrics"]`, true)
+
+			for _, action := range []string{"created", "updated", "deleted", "unchanged"} {
+				Expect(count(`Custom["WithMetrics"]`, action)).To(Equal(1.0), action)
+			}
+		})
+
+		It("doesn't count entries for a client without metrics, such as a dry-run", func() {
+			reconcileEntries(`Custom["WithoutMetrics"]`, false)
+
+			for _, action := range []string{"created", "updated", "deleted", "unchanged"} {
+				Expect(count(`Custom["WithoutMetrics"]`, action)).To(BeZero(), action)
+			}
+		})
+	})
+
+	Describe("entries without an external ID", func() {
+		var catalog *fakeCatalog
+
+		BeforeEach(func() {
+			catalog = newFakeCatalog(`Custom["Service"]`,
+				client.CatalogEntryV2{Id: "E1", ExternalId: lo.ToPtr("payments"), Name: "Payments"},
+				client.CatalogEntryV2{Id: "E2", Name: "Built in the dashboard"},
+				client.CatalogEntryV2{Id: "E3", Name: "Exported"},
+			)
+		})
+
+		models := func() []*output.CatalogEntryModel {
+			return []*output.CatalogEntryModel{entryModel("payments", "Payments"), entryModel("E3", "Exported")}
+		}
+
+		It("deletes them unless a model claims their entry ID", func() {
+			err := reconcile.Entries(ctx, logger, catalog.Client(), &output.Output{}, &catalog.catalogType, models(), nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(catalog.changes).To(ConsistOf("delete E2", "update E3"))
+			Expect(catalog.Entries()).To(ConsistOf(
+				HaveField("ExternalId", lo.ToPtr("payments")),
+				HaveField("ExternalId", lo.ToPtr("E3")),
+			))
+		})
+
+		It("keeps them when asked to, recording them as skipped", func() {
+			result := new(reconcile.EntriesResult)
+			err := reconcile.Entries(ctx, logger, catalog.Client(), &output.Output{}, &catalog.catalogType, models(), nil,
+				reconcile.WithKeepUnidentified(), reconcile.WithResult(result))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(catalog.changes).To(ConsistOf("update E3"))
+			Expect(result.Skipped).To(ConsistOf("E2"))
+		})
+	})
+})
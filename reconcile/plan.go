@@ -0,0 +1,433 @@
+package reconcile
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"golang.org/x/sync/errgroup"
+)
+
+type TypeAction string
+
+const (
+	TypeActionCreate TypeAction = "create" // type doesn't exist and will be created
+	TypeActionUpdate TypeAction = "update" // type exists but its schema has changed
+	TypeActionNone   TypeAction = "none"   // type exists and is unchanged, though its entries may not be
+	TypeActionDelete TypeAction = "delete" // type is no longer in config, and is removed when pruning
+	TypeActionAdopt  TypeAction = "adopt"  // type exists but is managed by another importer, or none, and will be taken over
+)
+
+type EntryAction string
+
+const (
+	EntryActionCreate EntryAction = "create"
+	EntryActionUpdate EntryAction = "update"
+	EntryActionDelete EntryAction = "delete"
+)
+
+// Plan is a machine-readable record of every change a sync would make to the catalog.
+//
+// It's built by running a sync against a read-only client, and captures a fingerprint of
+// the catalog at that time so we can refuse to apply the plan if the catalog has since
+// drifted.
+type Plan struct {
+	Version       string      `json:"version"`
+	SyncID        string      `json:"sync_id"`
+	SourceRepoUrl string      `json:"source_repo_url,omitempty"`
+	CreatedAt     time.Time   `json:"created_at"`
+	Types         []*TypePlan `json:"types"`
+
+	mu     sync.Mutex
+	byID   map[string]*TypePlan // catalog type ID (or dry-run ID) to plan
+	byName map[string]*TypePlan // type name to plan
+}
+
+// TypePlan describes the desired state of a single catalog type, along with the entry
+// changes required to reach it.
+type TypePlan struct {
+	Action        TypeAction                             `json:"action"`
+	TypeName      string                                 `json:"type_name"`
+	CatalogTypeID string                                 `json:"catalog_type_id,omitempty"` // unset if we're creating the type
+	Name          string                                 `json:"name"`
+	Description   string                                 `json:"description"`
+	Ranked        bool                                   `json:"ranked"`
+	Categories    []string                               `json:"categories"`
+	Attributes    []client.CatalogTypeAttributePayloadV2 `json:"attributes"`
+	Before        *client.CatalogTypeV2                  `json:"before,omitempty"`
+	Fingerprint   string                                 `json:"fingerprint,omitempty"` // unset if we never reconciled its entries
+	Entries       []*EntryPlan                           `json:"entries"`
+}
+
+// Model rebuilds the catalog type model that this plan was generated from.
+func (t TypePlan) Model() *output.CatalogTypeModel {
+	return &output.CatalogTypeModel{
+		Name:        t.Name,
+		Description: t.Description,
+		TypeName:    t.TypeName,
+		Ranked:      t.Ranked,
+		Attributes:  t.Attributes,
+		Categories:  t.Categories,
+	}
+}
+
+// EntryPlan is a single entry change, with before and after payloads where appropriate.
+type EntryPlan struct {
+	Action     EntryAction                    `json:"action"`
+	EntryID    string                         `json:"entry_id,omitempty"` // unset if we're creating the entry
+	ExternalID string                         `json:"external_id,omitempty"`
+	Before     *client.UpdateEntryRequestBody `json:"before,omitempty"`
+	After      *client.UpdateEntryRequestBody `json:"after,omitempty"`
+}
+
+// NewPlan creates an empty plan, ready to record changes.
+func NewPlan(version, syncID, sourceRepoUrl string) *Plan {
+	return &Plan{
+		Version:       version,
+		SyncID:        syncID,
+		SourceRepoUrl: sourceRepoUrl,
+		CreatedAt:     time.Now(),
+		Types:         []*TypePlan{},
+	}
+}
+
+func (p *Plan) synchronise(do func()) {
+	defer p.mu.Unlock()
+	p.mu.Lock()
+
+	if p.byID == nil {
+		p.byID = map[string]*TypePlan{}
+		p.byName = map[string]*TypePlan{}
+		for _, typePlan := range p.Types {
+			p.byName[typePlan.TypeName] = typePlan
+			if typePlan.CatalogTypeID != "" {
+				p.byID[typePlan.CatalogTypeID] = typePlan
+			}
+		}
+	}
+
+	do()
+}
+
+// AddType records the desired state of a catalog type. The catalogType is the type as it
+// currently exists, or the type we faked when simulating the create for a dry-run.
+func (p *Plan) AddType(action TypeAction, model *output.CatalogTypeModel, catalogType *client.CatalogTypeV2) {
+	p.synchronise(func() {
+		if _, ok := p.byName[model.TypeName]; ok {
+			return // we've already seen this type, likely an enum shared between outputs
+		}
+
+		typePlan := &TypePlan{
+			Action:      action,
+			TypeName:    model.TypeName,
+			Name:        model.Name,
+			Description: model.Description,
+			Ranked:      model.Ranked,
+			Categories:  model.Categories,
+			Attributes:  model.Attributes,
+			Entries:     []*EntryPlan{},
+		}
+		if action != TypeActionCreate {
+			typePlan.CatalogTypeID = catalogType.Id
+			typePlan.Before = catalogType
+		}
+
+		p.Types = append(p.Types, typePlan)
+		p.byName[typePlan.TypeName] = typePlan
+		p.byID[catalogType.Id] = typePlan
+	})
+}
+
+// Record wraps an EntriesClient so that every change is recorded against the plan before
+// being passed to the underlying client.
+func (p *Plan) Record(cl EntriesClient) EntriesClient {
+	addEntry := func(catalogTypeID string, entryPlan *EntryPlan) error {
+		var err error
+		p.synchronise(func() {
+			typePlan, ok := p.byID[catalogTypeID]
+			if !ok {
+				err = fmt.Errorf("no plan for catalog type with id='%s', this is a bug in the importer", catalogTypeID)
+				return
+			}
+
+			typePlan.Entries = append(typePlan.Entries, entryPlan)
+		})
+
+		return err
+	}
+
+	return EntriesClient{
+		GetEntries: func(ctx context.Context, catalogTypeID string) (*client.CatalogTypeV2, []client.CatalogEntryV2, error) {
+			catalogType, entries, err := cl.GetEntries(ctx, catalogTypeID)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			p.synchronise(func() {
+				if typePlan, ok := p.byID[catalogTypeID]; ok && typePlan.Action != TypeActionCreate {
+					typePlan.Fingerprint = Fingerprint(catalogType, entries)
+				}
+			})
+
+			return catalogType, entries, nil
+		},
+		Delete: func(ctx context.Context, entry *client.CatalogEntryV2) error {
+			err := addEntry(entry.CatalogTypeId, &EntryPlan{
+				Action:     EntryActionDelete,
+				EntryID:    entry.Id,
+				ExternalID: lo.FromPtr(entry.ExternalId),
+				Before:     lo.ToPtr(EntryPayload(*entry)),
+			})
+			if err != nil {
+				return err
+			}
+
+			return cl.Delete(ctx, entry)
+		},
+		Create: func(ctx context.Context, payload client.CreateEntryRequestBody) (*client.CatalogEntryV2, error) {
+			err := addEntry(payload.CatalogTypeId, &EntryPlan{
+				Action:     EntryActionCreate,
+				ExternalID: lo.FromPtr(payload.ExternalId),
+				After: &client.UpdateEntryRequestBody{
+					Aliases:         payload.Aliases,
+					AttributeValues: payload.AttributeValues,
+					ExternalId:      payload.ExternalId,
+					Name:            payload.Name,
+					Rank:            payload.Rank,
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			return cl.Create(ctx, payload)
+		},
+		Update: func(ctx context.Context, entry *client.CatalogEntryV2, payload client.UpdateEntryRequestBody) (*client.CatalogEntryV2, error) {
+			err := addEntry(entry.CatalogTypeId, &EntryPlan{
+				Action:     EntryActionUpdate,
+				EntryID:    entry.Id,
+				ExternalID: lo.FromPtr(entry.ExternalId),
+				Before:     lo.ToPtr(EntryPayload(*entry)),
+				After:      lo.ToPtr(payload),
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			return cl.Update(ctx, entry, payload)
+		},
+		Metrics: cl.Metrics,
+	}
+}
+
+// ApplyEntries executes the entry changes from a plan against the catalog type, in the
+// same order as a sync would: deleting, then creating, then updating.
+func ApplyEntries(ctx context.Context, logger kitlog.Logger, cl EntriesClient, catalogTypeID string, entryPlans []*EntryPlan, progress *EntriesProgress) error {
+	logger = kitlog.With(logger, "catalog_type_id", catalogTypeID)
+
+	// Initialise this as it's easy to deal with if you don't nil check the full struct.
+	if progress == nil {
+		progress = new(EntriesProgress)
+	}
+
+	steps := []struct {
+		action     EntryAction
+		onStart    func(total int)
+		onProgress func()
+		apply      func(ctx context.Context, entryPlan *EntryPlan) error
+	}{
+		{
+			EntryActionDelete, progress.OnDeleteStart, progress.OnDeleteProgress,
+			func(ctx context.Context, entryPlan *EntryPlan) error {
+				err := cl.Delete(ctx, &client.CatalogEntryV2{Id: entryPlan.EntryID})
+				if err != nil {
+					return errors.Wrap(err, fmt.Sprintf("unable to destroy catalog entry with id=%s, got error", entryPlan.EntryID))
+				}
+
+				logger.Log("msg", "destroyed catalog entry", "catalog_entry_id", entryPlan.EntryID)
+				return nil
+			},
+		},
+		{
+			EntryActionCreate, progress.OnCreateStart, progress.OnCreateProgress,
+			func(ctx context.Context, entryPlan *EntryPlan) error {
+				result, err := cl.Create(ctx, client.CreateEntryRequestBody{
+					CatalogTypeId:   catalogTypeID,
+					Name:            entryPlan.After.Name,
+					Rank:            entryPlan.After.Rank,
+					ExternalId:      entryPlan.After.ExternalId,
+					Aliases:         entryPlan.After.Aliases,
+					AttributeValues: entryPlan.After.AttributeValues,
+				})
+				if err != nil {
+					return errors.Wrap(err, fmt.Sprintf("unable to create catalog entry with external_id=%s, got error", entryPlan.ExternalID))
+				}
+
+				logger.Log("msg", "created catalog entry", "external_id", entryPlan.ExternalID, "entry_id", result.Id)
+				return nil
+			},
+		},
+		{
+			EntryActionUpdate, progress.OnUpdateStart, progress.OnUpdateProgress,
+			func(ctx context.Context, entryPlan *EntryPlan) error {
+				_, err := cl.Update(ctx, &client.CatalogEntryV2{Id: entryPlan.EntryID}, *entryPlan.After)
+				if err != nil {
+					return errors.Wrap(err, fmt.Sprintf("unable to update catalog entry with id=%s, got error", entryPlan.EntryID))
+				}
+
+				logger.Log("msg", "updated catalog entry", "entry_id", entryPlan.EntryID)
+				return nil
+			},
+		},
+	}
+
+	for _, step := range steps {
+		var (
+			step    = step // capture loop variable
+			toApply = lo.Filter(entryPlans, func(entryPlan *EntryPlan, _ int) bool { return entryPlan.Action == step.action })
+			g, gctx = errgroup.WithContext(ctx)
+		)
+		g.SetLimit(10)
+
+		if step.onStart != nil {
+			step.onStart(len(toApply))
+		}
+
+		for _, entryPlan := range toApply {
+			var (
+				entryPlan = entryPlan // capture loop variable
+			)
+
+			g.Go(func() error {
+				if step.onProgress != nil {
+					defer step.onProgress()
+				}
+
+				return step.apply(gctx, entryPlan)
+			})
+		}
+
+		if err := g.Wait(); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("applying %s of catalog entries", step.action))
+		}
+	}
+
+	return nil
+}
+
+// Sort orders the entries of each type so plans are stable between runs, as we record
+// them concurrently.
+func (p *Plan) Sort() {
+	actionOrder := map[EntryAction]int{
+		EntryActionDelete: 0,
+		EntryActionCreate: 1,
+		EntryActionUpdate: 2,
+	}
+
+	p.synchronise(func() {
+		for _, typePlan := range p.Types {
+			sort.SliceStable(typePlan.Entries, func(i, j int) bool {
+				a, b := typePlan.Entries[i], typePlan.Entries[j]
+				if a.Action != b.Action {
+					return actionOrder[a.Action] < actionOrder[b.Action]
+				}
+				if a.ExternalID != b.ExternalID {
+					return a.ExternalID < b.ExternalID
+				}
+
+				return a.EntryID < b.EntryID
+			})
+		}
+	})
+}
+
+// HasChanges is true if applying the plan would modify the catalog.
+func (p *Plan) HasChanges() bool {
+	for _, typePlan := range p.Types {
+		if typePlan.Action != TypeActionNone || len(typePlan.Entries) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Count returns how many entry changes of the given action the type plan contains.
+func (t TypePlan) Count(action EntryAction) int {
+	return lo.CountBy(t.Entries, func(entryPlan *EntryPlan) bool {
+		return entryPlan.Action == action
+	})
+}
+
+// EntryPayload converts an existing catalog entry into the payload that would produce it,
+// which is what we compare against when showing diffs.
+func EntryPayload(entry client.CatalogEntryV2) client.UpdateEntryRequestBody {
+	payload := client.UpdateEntryRequestBody{
+		Aliases:         lo.ToPtr(entry.Aliases),
+		AttributeValues: map[string]client.EngineParamBindingPayloadV2{},
+		ExternalId:      entry.ExternalId,
+		Name:            entry.Name,
+		Rank:            lo.ToPtr(entry.Rank),
+	}
+	for attrID, attr := range entry.AttributeValues {
+		result := client.EngineParamBindingPayloadV2{}
+		if attr.Value != nil {
+			result.Value = &client.EngineParamBindingValuePayloadV2{
+				Literal: attr.Value.Literal,
+			}
+		}
+		if attr.ArrayValue != nil {
+			arrayValue := []client.EngineParamBindingValuePayloadV2{}
+			for _, elementValue := range *attr.ArrayValue {
+				arrayValue = append(arrayValue, client.EngineParamBindingValuePayloadV2{
+					Literal: elementValue.Literal,
+				})
+			}
+
+			result.ArrayValue = &arrayValue
+		}
+
+		payload.AttributeValues[attrID] = result
+	}
+
+	return payload
+}
+
+// Fingerprint summarises the schema of a catalog type and the content of its entries,
+// allowing us to detect if the catalog has changed between building and applying a plan.
+func Fingerprint(catalogType *client.CatalogTypeV2, entries []client.CatalogEntryV2) string {
+	type entryState struct {
+		ID      string                        `json:"id"`
+		Payload client.UpdateEntryRequestBody `json:"payload"`
+	}
+
+	state := struct {
+		SchemaVersion int64                           `json:"schema_version"`
+		Attributes    []client.CatalogTypeAttributeV2 `json:"attributes"`
+		Entries       []entryState                    `json:"entries"`
+	}{
+		SchemaVersion: catalogType.Schema.Version,
+		Attributes:    catalogType.Schema.Attributes,
+		Entries: lo.Map(entries, func(entry client.CatalogEntryV2, _ int) entryState {
+			return entryState{ID: entry.Id, Payload: EntryPayload(entry)}
+		}),
+	}
+	sort.Slice(state.Entries, func(i, j int) bool {
+		return state.Entries[i].ID < state.Entries[j].ID
+	})
+
+	data, _ := json.Marshal(state) // maps marshal with sorted keys, so this is stable
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,201 @@
+package reconcile_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	kitlog "github.com/go-kit/log"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/samber/lo"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+)
+
+var _ = Describe("Fingerprint", func() {
+	var (
+		catalogType *client.CatalogTypeV2
+		entries     []client.CatalogEntryV2
+	)
+
+	BeforeEach(func() {
+		catalogType = &client.CatalogTypeV2{
+			Id:          "T1",
+			TypeName:    `Custom["Service"]`,
+			Annotations: map[string]string{"incident.io/catalog-importer/last-sync-at": "2024-01-01T00:00:00Z"},
+			Schema: client.CatalogTypeSchemaV2{
+				Version: 3,
+				Attributes: []client.CatalogTypeAttributeV2{
+					{Id: "tier", Name: "Tier", Type: "Number", Mode: client.CatalogTypeAttributeV2ModeManual},
+				},
+			},
+		}
+		entries = []client.CatalogEntryV2{
+			{Id: "E1", ExternalId: lo.ToPtr("payments"), Name: "Payments", Aliases: []string{}, AttributeValues: map[string]client.CatalogEntryEngineParamBindingV2{
+				"tier": {Value: &client.CatalogEntryEngineParamBindingValueV2{Literal: lo.ToPtr("1")}},
+			}},
+			{Id: "E2", ExternalId: lo.ToPtr("billing"), Name: "Billing", Aliases: []string{"invoices"}},
+		}
+	})
+
+	It("matches for the same schema and entries, whatever their order", func() {
+		before := reconcile.Fingerprint(catalogType, entries)
+
+		// Annotations change on every sync, and take the lock, so they must not count.
+		catalogType.Annotations = map[string]string{"incident.io/catalog-importer/lock-holder": "someone"}
+
+		Expect(reconcile.Fingerprint(catalogType, []client.CatalogEntryV2{entries[1], entries[0]})).To(Equal(before))
+	})
+
+	DescribeTable("changes when the schema or entries change",
+		func(change func(*client.CatalogTypeV2, []client.CatalogEntryV2) []client.CatalogEntryV2) {
+			before := reconcile.Fingerprint(catalogType, entries)
+			entries = change(catalogType, entries)
+
+			Expect(reconcile.Fingerprint(catalogType, entries)).NotTo(Equal(before))
+		},
+		Entry("schema version", func(catalogType *client.CatalogTypeV2, entries []client.CatalogEntryV2) []client.CatalogEntryV2 {
+			catalogType.Schema.Version++
+			return entries
+		}),
+		Entry("attribute", func(catalogType *client.CatalogTypeV2, entries []client.CatalogEntryV2) []client.CatalogEntryV2 {
+			catalogType.Schema.Attributes[0].Array = true
+			return entries
+		}),
+		Entry("entry name", func(_ *client.CatalogTypeV2, entries []client.CatalogEntryV2) []client.CatalogEntryV2 {
+			entries[1].Name = "Invoicing"
+			return entries
+		}),
+		Entry("entry attribute value", func(_ *client.CatalogTypeV2, entries []client.CatalogEntryV2) []client.CatalogEntryV2 {
+			entries[0].AttributeValues["tier"] = client.CatalogEntryEngineParamBindingV2{
+				Value: &client.CatalogEntryEngineParamBindingValueV2{Literal: lo.ToPtr("2")},
+			}
+			return entries
+		}),
+		Entry("entry external ID", func(_ *client.CatalogTypeV2, entries []client.CatalogEntryV2) []client.CatalogEntryV2 {
+			entries[1].ExternalId = nil
+			return entries
+		}),
+		Entry("entry added", func(_ *client.CatalogTypeV2, entries []client.CatalogEntryV2) []client.CatalogEntryV2 {
+			return append(entries, client.CatalogEntryV2{Id: "E3", Name: "Search"})
+		}),
+		Entry("entry removed", func(_ *client.CatalogTypeV2, entries []client.CatalogEntryV2) []client.CatalogEntryV2 {
+			return entries[:1]
+		}),
+	)
+})
+
+var _ = Describe("ApplyEntries", func() {
+	var (
+		ctx     context.Context
+		logger  kitlog.Logger
+		catalog *fakeCatalog
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		logger = kitlog.NewNopLogger()
+		catalog = newFakeCatalog(`Custom["Service"]`,
+			client.CatalogEntryV2{Id: "E1", ExternalId: lo.ToPtr("payments"), Name: "Payments"},
+			client.CatalogEntryV2{Id: "E2", ExternalId: lo.ToPtr("billing"), Name: "Billing"},
+			client.CatalogEntryV2{Id: "E3", ExternalId: lo.ToPtr("legacy"), Name: "Legacy"},
+			client.CatalogEntryV2{Id: "E4", ExternalId: lo.ToPtr("old"), Name: "Old"},
+		)
+	})
+
+	payload := func(externalID, name string) *client.UpdateEntryRequestBody {
+		return &client.UpdateEntryRequestBody{
+			ExternalId:      lo.ToPtr(externalID),
+			Name:            name,
+			Aliases:         lo.ToPtr([]string{}),
+			Rank:            lo.ToPtr(int32(0)),
+			AttributeValues: map[string]client.EngineParamBindingPayloadV2{},
+		}
+	}
+
+	// The plan mixes up the actions, to check we don't apply them in the order given.
+	entryPlans := func() []*reconcile.EntryPlan {
+		return []*reconcile.EntryPlan{
+			{Action: reconcile.EntryActionUpdate, EntryID: "E1", ExternalID: "payments", After: payload("payments", "Payments API")},
+			{Action: reconcile.EntryActionCreate, ExternalID: "search", After: payload("search", "Search")},
+			{Action: reconcile.EntryActionDelete, EntryID: "E3", ExternalID: "legacy"},
+			{Action: reconcile.EntryActionUpdate, EntryID: "E2", ExternalID: "billing", After: payload("billing", "Billing API")},
+			{Action: reconcile.EntryActionCreate, ExternalID: "ledger", After: payload("ledger", "Ledger")},
+			{Action: reconcile.EntryActionDelete, EntryID: "E4", ExternalID: "old"},
+		}
+	}
+
+	It("deletes, then creates, then updates", func() {
+		err := reconcile.ApplyEntries(ctx, logger, catalog.Client(), catalog.catalogType.Id, entryPlans(), nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		actions := lo.Map(catalog.changes, func(change string, _ int) string {
+			return strings.Fields(change)[0]
+		})
+		Expect(actions).To(Equal([]string{"delete", "delete", "create", "create", "update", "update"}))
+		Expect(catalog.changes).To(ContainElements("delete E3", "delete E4", "create search", "create ledger", "update E1", "update E2"))
+
+		Expect(lo.Map(catalog.Entries(), func(entry client.CatalogEntryV2, _ int) string {
+			return entry.Name
+		})).To(ConsistOf("Payments API", "Billing API", "Search", "Ledger"))
+	})
+
+	It("reports progress for each step", func() {
+		var events []string
+		progress := &reconcile.EntriesProgress{
+			OnDeleteStart: func(total int) { events = append(events, fmt.Sprintf("delete %d", total)) },
+			OnCreateStart: func(total int) { events = append(events, fmt.Sprintf("create %d", total)) },
+			OnUpdateStart: func(total int) { events = append(events, fmt.Sprintf("update %d", total)) },
+		}
+
+		err := reconcile.ApplyEntries(ctx, logger, catalog.Client(), catalog.catalogType.Id, entryPlans(), progress)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(events).To(Equal([]string{"delete 2", "create 2", "update 2"}))
+	})
+
+	It("stops before the next step if a change fails", func() {
+		entriesClient := catalog.Client()
+		entriesClient.Delete = func(ctx context.Context, entry *client.CatalogEntryV2) error {
+			return fmt.Errorf("entry is locked")
+		}
+
+		err := reconcile.ApplyEntries(ctx, logger, entriesClient, catalog.catalogType.Id, entryPlans(), nil)
+		Expect(err).To(MatchError(ContainSubstring("applying delete of catalog entries")))
+		Expect(catalog.changes).To(BeEmpty())
+	})
+
+	It("makes the same changes as the sync that recorded the plan", func() {
+		models := []*output.CatalogEntryModel{
+			{ExternalID: "payments", Name: "Payments API", Aliases: []string{}, AttributeValues: map[string]client.EngineParamBindingPayloadV2{}},
+			{ExternalID: "billing", Name: "Billing", Aliases: []string{}, AttributeValues: map[string]client.EngineParamBindingPayloadV2{}},
+			{ExternalID: "search", Name: "Search", Aliases: []string{}, AttributeValues: map[string]client.EngineParamBindingPayloadV2{}},
+		}
+
+		// Record the plan against a copy of the catalog, as plan would in dry-run.
+		dryRun := newFakeCatalog(catalog.catalogType.TypeName, catalog.Entries()...)
+		plan := reconcile.NewPlan("test", "sync-id", "")
+		plan.AddType(reconcile.TypeActionNone, &output.CatalogTypeModel{TypeName: catalog.catalogType.TypeName}, &catalog.catalogType)
+		err := reconcile.Entries(ctx, logger, plan.Record(dryRun.Client()), &output.Output{TypeName: catalog.catalogType.TypeName},
+			&catalog.catalogType, models, nil)
+		Expect(err).NotTo(HaveOccurred())
+		plan.Sort()
+
+		typePlan := plan.Types[0]
+		Expect(typePlan.Fingerprint).To(Equal(reconcile.Fingerprint(&catalog.catalogType, catalog.Entries())))
+		Expect(typePlan.Count(reconcile.EntryActionDelete)).To(Equal(2))
+		Expect(typePlan.Count(reconcile.EntryActionCreate)).To(Equal(1))
+		Expect(typePlan.Count(reconcile.EntryActionUpdate)).To(Equal(1))
+
+		err = reconcile.ApplyEntries(ctx, logger, catalog.Client(), catalog.catalogType.Id, typePlan.Entries, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(lo.Map(catalog.Entries(), func(entry client.CatalogEntryV2, _ int) string {
+			return entry.Name
+		})).To(ConsistOf("Payments API", "Billing", "Search"))
+		Expect(reconcile.Fingerprint(&catalog.catalogType, catalog.Entries())).
+			NotTo(Equal(typePlan.Fingerprint))
+	})
+})
@@ -0,0 +1,119 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/output"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// Snapshot is the full state of the catalog types managed by a sync ID, along with all
+// their entries, which can be restored to revert a bad sync.
+type Snapshot struct {
+	Version       string          `json:"version"`
+	SyncID        string          `json:"sync_id"`
+	SourceRepoUrl string          `json:"source_repo_url,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Types         []*TypeSnapshot `json:"types"`
+}
+
+// TypeSnapshot is a catalog type as it was when the snapshot was taken.
+type TypeSnapshot struct {
+	CatalogType client.CatalogTypeV2    `json:"catalog_type"`
+	Entries     []client.CatalogEntryV2 `json:"entries"`
+}
+
+// TakeSnapshot fetches the schema and entries of every given catalog type.
+func TakeSnapshot(ctx context.Context, cl *client.ClientWithResponses, version, syncID, sourceRepoUrl string, catalogTypes []client.CatalogTypeV2) (*Snapshot, error) {
+	snapshot := &Snapshot{
+		Version:       version,
+		SyncID:        syncID,
+		SourceRepoUrl: sourceRepoUrl,
+		CreatedAt:     time.Now(),
+		Types:         []*TypeSnapshot{},
+	}
+	for _, catalogType := range catalogTypes {
+		catalogType, entries, err := GetEntries(ctx, cl, catalogType.Id)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("listing entries for %s", catalogType.TypeName))
+		}
+
+		snapshot.Types = append(snapshot.Types, &TypeSnapshot{
+			CatalogType: *catalogType,
+			Entries:     entries,
+		})
+	}
+
+	return snapshot, nil
+}
+
+// Model rebuilds the catalog type model that would restore the type's schema.
+func (t TypeSnapshot) Model() *output.CatalogTypeModel {
+	catalogType := t.CatalogType
+
+	return &output.CatalogTypeModel{
+		Name:        catalogType.Name,
+		Description: catalogType.Description,
+		TypeName:    catalogType.TypeName,
+		Ranked:      catalogType.Ranked,
+		Categories: lo.Map(catalogType.Categories, func(category client.CatalogTypeV2Categories, _ int) string {
+			return string(category)
+		}),
+		Attributes: lo.Map(catalogType.Schema.Attributes, func(attr client.CatalogTypeAttributeV2, _ int) client.CatalogTypeAttributePayloadV2 {
+			var path *[]client.CatalogTypeAttributePathItemPayloadV2
+			if attr.Path != nil {
+				path = lo.ToPtr(lo.Map(*attr.Path, func(item client.CatalogTypeAttributePathItemV2, _ int) client.CatalogTypeAttributePathItemPayloadV2 {
+					return client.CatalogTypeAttributePathItemPayloadV2{AttributeId: item.AttributeId}
+				}))
+			}
+
+			return client.CatalogTypeAttributePayloadV2{
+				Id:                lo.ToPtr(attr.Id),
+				Name:              attr.Name,
+				Type:              attr.Type,
+				Array:             attr.Array,
+				Mode:              lo.ToPtr(client.CatalogTypeAttributePayloadV2Mode(attr.Mode)),
+				BacklinkAttribute: attr.BacklinkAttribute,
+				Path:              path,
+			}
+		}),
+	}
+}
+
+// EntryModels converts the entries into models that, when reconciled, will restore them.
+//
+// We match entries by external ID, so any entry without one can't be restored and is
+// returned separately, for the caller to report.
+func (t TypeSnapshot) EntryModels() (models []*output.CatalogEntryModel, withoutExternalID []client.CatalogEntryV2) {
+	models = []*output.CatalogEntryModel{}
+	for _, entry := range t.Entries {
+		if entry.ExternalId == nil {
+			withoutExternalID = append(withoutExternalID, entry)
+			continue
+		}
+
+		// The API omits empty arrays, which we treat as empty when comparing against existing
+		// entries, so do the same here to avoid restoring values that haven't changed.
+		payload := EntryPayload(entry)
+		for attributeID, binding := range payload.AttributeValues {
+			if binding.Value == nil && binding.ArrayValue == nil {
+				binding.ArrayValue = lo.ToPtr([]client.EngineParamBindingValuePayloadV2{})
+				payload.AttributeValues[attributeID] = binding
+			}
+		}
+
+		models = append(models, &output.CatalogEntryModel{
+			ExternalID:      *entry.ExternalId,
+			Name:            entry.Name,
+			Aliases:         entry.Aliases,
+			Rank:            entry.Rank,
+			AttributeValues: payload.AttributeValues,
+		})
+	}
+
+	return models, withoutExternalID
+}
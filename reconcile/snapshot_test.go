@@ -0,0 +1,64 @@
+package reconcile_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/samber/lo"
+
+	"github.com/incident-io/catalog-importer/v2/client"
+	"github.com/incident-io/catalog-importer/v2/reconcile"
+)
+
+var _ = Describe("TypeSnapshot", func() {
+	typeSnapshot := reconcile.TypeSnapshot{
+		CatalogType: client.CatalogTypeV2{
+			Name:       "Service",
+			TypeName:   `Custom["Service"]`,
+			Ranked:     true,
+			Categories: []client.CatalogTypeV2Categories{"service"},
+			Schema: client.CatalogTypeSchemaV2{
+				Attributes: []client.CatalogTypeAttributeV2{
+					{Id: "owners", Name: "Owners", Type: `Custom["Team"]`, Array: true, Mode: client.CatalogTypeAttributeV2ModeManual},
+					{Id: "owner_slack", Name: "Owner Slack", Type: "SlackChannel", Mode: client.CatalogTypeAttributeV2ModePath,
+						Path: &[]client.CatalogTypeAttributePathItemV2{{AttributeId: "owners", AttributeName: "Owners"}}},
+				},
+			},
+		},
+		Entries: []client.CatalogEntryV2{
+			{Id: "E1", ExternalId: lo.ToPtr("payments"), Name: "Payments", Aliases: []string{"payments-api"}, Rank: 2,
+				AttributeValues: map[string]client.CatalogEntryEngineParamBindingV2{
+					"owners": {}, // the API leaves out empty arrays
+				}},
+			{Id: "E2", Name: "Built in the dashboard"},
+		},
+	}
+
+	Describe("Model", func() {
+		It("rebuilds the type's schema", func() {
+			model := typeSnapshot.Model()
+
+			Expect(model.TypeName).To(Equal(`Custom["Service"]`))
+			Expect(model.Ranked).To(BeTrue())
+			Expect(model.Categories).To(Equal([]string{"service"}))
+			Expect(model.Attributes).To(HaveLen(2))
+			Expect(*model.Attributes[1].Mode).To(Equal(client.CatalogTypeAttributePayloadV2ModePath))
+			Expect(*model.Attributes[1].Path).To(Equal([]client.CatalogTypeAttributePathItemPayloadV2{{AttributeId: "owners"}}))
+		})
+	})
+
+	Describe("EntryModels", func() {
+		It("rebuilds entries, returning those without an external ID separately", func() {
+			models, withoutExternalID := typeSnapshot.EntryModels()
+
+			Expect(models).To(HaveLen(1))
+			Expect(models[0].ExternalID).To(Equal("payments"))
+			Expect(models[0].Aliases).To(Equal([]string{"payments-api"}))
+			Expect(models[0].Rank).To(Equal(int32(2)))
+			Expect(models[0].AttributeValues).To(Equal(map[string]client.EngineParamBindingPayloadV2{
+				"owners": {ArrayValue: &[]client.EngineParamBindingValuePayloadV2{}},
+			}))
+
+			Expect(withoutExternalID).To(ConsistOf(HaveField("Id", "E2")))
+		})
+	})
+})
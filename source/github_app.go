@@ -0,0 +1,134 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/go-github/v52/github"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// GitHubApp authenticates against GitHub as an installation of a GitHub App, instead of
+// using a personal access token.
+//
+// https://docs.github.com/en/apps/creating-github-apps/authenticating-with-a-github-app/authenticating-as-a-github-app-installation
+type GitHubApp struct {
+	AppID          Credential `json:"app_id"`
+	InstallationID Credential `json:"installation_id"`
+	PrivateKey     Credential `json:"private_key"` // PEM encoded
+}
+
+func (a GitHubApp) Validate() error {
+	return validation.ValidateStruct(&a,
+		validation.Field(&a.AppID, validation.Required),
+		validation.Field(&a.InstallationID, validation.Required, validation.By(func(value any) error {
+			if _, err := strconv.ParseInt(string(value.(Credential)), 10, 64); err != nil {
+				return fmt.Errorf("must be a number")
+			}
+
+			return nil
+		})),
+		validation.Field(&a.PrivateKey, validation.Required, validation.By(func(value any) error {
+			if _, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(value.(Credential))); err != nil {
+				return errors.Wrap(err, "must be a PEM encoded RSA private key")
+			}
+
+			return nil
+		})),
+	)
+}
+
+// TokenSource returns installation tokens for the app, refreshing them whenever they
+// expire. The baseURL is that of the GitHub Enterprise Server, or empty for github.com.
+func (a GitHubApp) TokenSource(ctx context.Context, baseURL string) (oauth2.TokenSource, error) {
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(a.PrivateKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing GitHub App private key")
+	}
+	installationID, err := strconv.ParseInt(string(a.InstallationID), 10, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing GitHub App installation ID")
+	}
+
+	// Creating installation tokens requires us to authenticate as the app itself, which
+	// uses a short-lived JWT signed by the app's private key.
+	appTokens := oauth2.ReuseTokenSource(nil, tokenSourceFunc(func() (*oauth2.Token, error) {
+		now := time.Now()
+		expiry := now.Add(9 * time.Minute) // GitHub allows at most 10 minutes
+		token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.StandardClaims{
+			IssuedAt:  now.Add(-time.Minute).Unix(), // allow for clock drift
+			ExpiresAt: expiry.Unix(),
+			Issuer:    string(a.AppID),
+		}).SignedString(key)
+		if err != nil {
+			return nil, errors.Wrap(err, "signing GitHub App JWT")
+		}
+
+		return &oauth2.Token{AccessToken: token, Expiry: expiry}, nil
+	}))
+
+	appClient, err := newGitHubClient(baseURL, "", oauth2.NewClient(ctx, appTokens))
+	if err != nil {
+		return nil, err
+	}
+
+	return oauth2.ReuseTokenSource(nil, tokenSourceFunc(func() (*oauth2.Token, error) {
+		installationToken, _, err := appClient.Apps.CreateInstallationToken(ctx, installationID, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "creating GitHub App installation token")
+		}
+
+		return &oauth2.Token{
+			AccessToken: installationToken.GetToken(),
+			Expiry:      installationToken.GetExpiresAt().Time,
+		}, nil
+	})), nil
+}
+
+type tokenSourceFunc func() (*oauth2.Token, error)
+
+func (f tokenSourceFunc) Token() (*oauth2.Token, error) {
+	return f()
+}
+
+// newGitHubClient builds a client for github.com, or a GitHub Enterprise Server if given
+// a baseURL. The uploadURL defaults to the baseURL.
+func newGitHubClient(baseURL, uploadURL string, httpClient *http.Client) (*github.Client, error) {
+	if baseURL == "" {
+		return github.NewClient(httpClient), nil
+	}
+	if uploadURL == "" {
+		uploadURL = baseURL
+	}
+
+	client, err := github.NewEnterpriseClient(baseURL, uploadURL, httpClient)
+	if err != nil {
+		return nil, errors.Wrap(err, "building GitHub Enterprise client")
+	}
+
+	return client, nil
+}
+
+// gitHubBaseURLForGraphQL returns the base URL of the REST API that goes with a GitHub
+// GraphQL endpoint, which is empty for github.com.
+//
+// GitHub Enterprise Server serves GraphQL from https://HOST/api/graphql, and the REST API
+// from https://HOST/api/v3.
+func gitHubBaseURLForGraphQL(endpoint string) (string, error) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return "", errors.Wrap(err, "parsing GraphQL endpoint")
+	}
+	if parsed.Host == "api.github.com" {
+		return "", nil
+	}
+
+	return fmt.Sprintf("%s://%s", parsed.Scheme, parsed.Host), nil
+}
@@ -0,0 +1,241 @@
+package source
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+// githubCache stores GitHub API responses on disk so repeated syncs can avoid downloading
+// the same content.
+type githubCache struct {
+	dir string
+}
+
+// GetBlob returns the content of a blob if we've already downloaded it. Blobs are
+// addressed by the SHA of their content, so they never go stale.
+func (c githubCache) GetBlob(sha string) ([]byte, bool) {
+	data, err := os.ReadFile(c.path("blobs", sha))
+	if err != nil {
+		return nil, false
+	}
+
+	return data, true
+}
+
+// PutBlob stores the content of a blob.
+func (c githubCache) PutBlob(sha string, data []byte) error {
+	return c.write(c.path("blobs", sha), data)
+}
+
+func (c githubCache) path(kind, key string) string {
+	return filepath.Join(c.dir, kind, key)
+}
+
+// write atomically writes the file, as several goroutines may write the same blob if it
+// appears in more than one repo.
+func (c githubCache) write(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return errors.Wrap(err, "creating cache directory")
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return errors.Wrap(err, "creating cache file")
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return errors.Wrap(err, "writing cache file")
+	}
+	if err := file.Close(); err != nil {
+		return errors.Wrap(err, "writing cache file")
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// cachedResponse is what we store for each conditional request.
+type cachedResponse struct {
+	ETag   string      `json:"etag"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// githubConditionalTransport makes conditional requests for anything we've seen before,
+// replaying the cached response when GitHub tells us it hasn't changed. GitHub doesn't
+// count these against the rate limit.
+//
+// https://docs.github.com/en/rest/overview/resources-in-the-rest-api#conditional-requests
+type githubConditionalTransport struct {
+	cache githubCache
+	base  http.RoundTripper
+}
+
+func (t *githubConditionalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Blobs have their own cache, so there's no need to store them twice.
+	if req.Method != http.MethodGet || strings.Contains(req.URL.Path, "/git/blobs/") {
+		return t.base.RoundTrip(req)
+	}
+
+	key := sha256.Sum256([]byte(req.URL.String()))
+	path := t.cache.path("responses", hex.EncodeToString(key[:]))
+
+	var cached *cachedResponse
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &cached); err == nil && cached.ETag != "" {
+			req = req.Clone(req.Context())
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		resp.Body.Close()
+
+		header := cached.Header.Clone()
+		header.Set("X-From-Cache", "1") // stops go-github tracking rate limits from this
+		return &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         resp.Proto,
+			ProtoMajor:    resp.ProtoMajor,
+			ProtoMinor:    resp.ProtoMinor,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(cached.Body)),
+			ContentLength: int64(len(cached.Body)),
+			Request:       req,
+		}, nil
+
+	case resp.StatusCode == http.StatusOK && resp.Header.Get("ETag") != "":
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+
+		data, err := json.Marshal(cachedResponse{
+			ETag:   resp.Header.Get("ETag"),
+			Header: resp.Header,
+			Body:   body,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "marshalling cached response")
+		}
+		if err := t.cache.write(path, data); err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+// githubRateLimitTransport waits for rate limits to reset instead of failing, retrying
+// any request that was rejected for exceeding them.
+//
+// https://docs.github.com/en/rest/overview/resources-in-the-rest-api#rate-limiting
+type githubRateLimitTransport struct {
+	logger kitlog.Logger
+	base   http.RoundTripper
+}
+
+// githubRateLimitRetries is how many times we'll retry a single request that was rate
+// limited before giving up.
+const githubRateLimitRetries = 5
+
+func (t *githubRateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+
+		wait, limited := githubRateLimitWait(resp, time.Now())
+		if !limited {
+			return resp, nil
+		}
+
+		// We've used up the last of our requests. Wait now, or the GitHub client will refuse
+		// to make any more requests until the limit resets.
+		if resp.StatusCode < 400 {
+			if err := t.sleep(req, wait); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+
+			return resp, nil
+		}
+
+		if attempt >= githubRateLimitRetries || (req.Body != nil && req.GetBody == nil) {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		if err := t.sleep(req, wait); err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			req = req.Clone(req.Context())
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (t *githubRateLimitTransport) sleep(req *http.Request, wait time.Duration) error {
+	t.logger.Log("msg", "GitHub rate limit exceeded, waiting for it to reset",
+		"path", req.URL.Path, "wait", wait.String())
+
+	select {
+	case <-req.Context().Done():
+		return req.Context().Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+// githubRateLimitWait returns how long we should wait before making another request, if
+// the response says we've reached a rate limit.
+func githubRateLimitWait(resp *http.Response, now time.Time) (time.Duration, bool) {
+	// Secondary rate limits tell us how long to wait.
+	if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests {
+		if retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			return time.Duration(retryAfter) * time.Second, true
+		}
+	}
+
+	// Primary rate limits reset at a fixed time.
+	if resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		return 0, false
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	wait := time.Unix(reset, 0).Sub(now) + time.Second // allow for clock drift
+	if wait < 0 {
+		wait = 0
+	}
+
+	return wait, true
+}
@@ -0,0 +1,60 @@
+package source
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// Origins remembers where each parsed entry came from, so that when we fail to process an
+// entry we can say which source produced it.
+//
+// Entries are maps and can't hold this themselves without it becoming visible to
+// expressions, so we track them by the identity of the underlying map instead.
+type Origins map[uintptr]string
+
+// maxDerivedOrigins is how many origins we list for an entry built from others, before
+// summarising the rest.
+const maxDerivedOrigins = 3
+
+// Add records the origin of the given entries.
+func (o Origins) Add(origin string, entries ...Entry) {
+	for _, entry := range entries {
+		o[reflect.ValueOf(entry).Pointer()] = origin
+	}
+}
+
+// AddFrom records the origin of an entry that was built from others, such as by joining
+// or grouping them, from the origins of those entries. Does nothing if o is nil, for
+// callers that don't track origins.
+func (o Origins) AddFrom(how string, entry Entry, from ...Entry) {
+	if o == nil {
+		return
+	}
+
+	origins := []string{}
+	for _, fromEntry := range from {
+		if origin := o.Get(fromEntry); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	origins = lo.Uniq(origins)
+	if len(origins) == 0 {
+		o.Add(how, entry)
+		return
+	}
+
+	summary := strings.Join(origins[:lo.Min([]int{len(origins), maxDerivedOrigins})], ", ")
+	if len(origins) > maxDerivedOrigins {
+		summary += fmt.Sprintf(" and %d more", len(origins)-maxDerivedOrigins)
+	}
+
+	o.Add(fmt.Sprintf("%s (%s)", how, summary), entry)
+}
+
+// Get returns the origin of the entry, or an empty string if we don't know it.
+func (o Origins) Get(entry Entry) string {
+	return o[reflect.ValueOf(entry).Pointer()]
+}
@@ -0,0 +1,46 @@
+package source
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/objx"
+	"gopkg.in/guregu/null.v3"
+)
+
+// extractResult parses a JSON API response and finds the list of results within it, at the
+// given path (e.g. data.items) if provided, or the root of the response if not.
+//
+// It returns the parsed response, so callers can look for pagination details, along with
+// the results marshalled back into JSON and a count of how many there were.
+func extractResult(data []byte, path null.String) (resp objx.Map, content []byte, count int, err error) {
+	resp, err = objx.FromJSON(string(data))
+	if err != nil {
+		// objx only parses objects, so try parsing the response as a plain array if we
+		// haven't been asked to look inside it.
+		var results []any
+		if path.Valid || json.Unmarshal(data, &results) != nil {
+			return nil, nil, 0, errors.Wrap(err, "parsing response")
+		}
+
+		return objx.Map{}, data, len(results), nil
+	}
+
+	result := resp.Value().Data()
+	if path.Valid {
+		result = resp.Get(path.String).Data()
+	}
+
+	if result == nil || reflect.TypeOf(result).Kind() != reflect.Slice {
+		return nil, nil, 0, fmt.Errorf("result is not a list of values")
+	}
+
+	content, err = json.Marshal(result)
+	if err != nil {
+		return nil, nil, 0, errors.Wrap(err, "marshalling result into JSON")
+	}
+
+	return resp, content, reflect.ValueOf(result).Len(), nil
+}
@@ -13,9 +13,10 @@ import (
 // source file and an Origin that explains where the entry came from, specific to the type
 // of source that produced it.
 type SourceEntry struct {
-	Origin   string // the source origin e.g. inline
-	Filename string // the filename that it should be evaluated under e.g. app/main.jsonnet
-	Content  []byte // the content of the source
+	Origin   string         // the source origin e.g. inline
+	Filename string         // the filename that it should be evaluated under e.g. app/main.jsonnet
+	Content  []byte         // the content of the source
+	Fields   map[string]any // added to every entry parsed from the content, if any
 }
 
 func (e SourceEntry) Parse() ([]Entry, error) {
@@ -24,22 +25,36 @@ func (e SourceEntry) Parse() ([]Entry, error) {
 		return entries, fmt.Errorf("failed to parse any entries")
 	}
 
+	for _, entry := range entries {
+		for key, value := range e.Fields {
+			entry[key] = value
+		}
+	}
+
 	return entries, nil
 }
 
 // Source is instantiated from configuration and represents a source of catalog files.
 type Source struct {
+	// Name identifies the source within its pipeline, so it can be referred to by a join.
+	Name string `json:"name,omitempty"`
+
 	Local     *SourceLocal     `json:"local,omitempty"`
 	Inline    *SourceInline    `json:"inline,omitempty"`
 	Exec      *SourceExec      `json:"exec,omitempty"`
 	Backstage *SourceBackstage `json:"backstage,omitempty"`
 	GitHub    *SourceGitHub    `json:"github,omitempty"`
+	GitLab    *SourceGitLab    `json:"gitlab,omitempty"`
 	GraphQL   *SourceGraphQL   `json:"graphql,omitempty"`
+	HTTP      *SourceHTTP      `json:"http,omitempty"`
+	SQL       *SourceSQL       `json:"sql,omitempty"`
 }
 
 func (s Source) Validate() error {
 	err := validation.Validate("source", validation.By(func(value any) error {
-		if reflect.ValueOf(s).IsZero() {
+		withoutName := s
+		withoutName.Name = ""
+		if reflect.ValueOf(withoutName).IsZero() {
 			return ErrInvalidSourceEmpty
 		}
 
@@ -49,7 +64,17 @@ func (s Source) Validate() error {
 		return err
 	}
 
-	return validation.ValidateStruct(&s)
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Local),
+		validation.Field(&s.Inline),
+		validation.Field(&s.Exec),
+		validation.Field(&s.Backstage),
+		validation.Field(&s.GitHub),
+		validation.Field(&s.GitLab),
+		validation.Field(&s.GraphQL),
+		validation.Field(&s.HTTP),
+		validation.Field(&s.SQL),
+	)
 }
 
 type SourceBackend interface {
@@ -73,9 +98,18 @@ func (s Source) Backend() (SourceBackend, error) {
 	if s.GitHub != nil {
 		return s.GitHub, nil
 	}
+	if s.GitLab != nil {
+		return s.GitLab, nil
+	}
 	if s.GraphQL != nil {
 		return s.GraphQL, nil
 	}
+	if s.HTTP != nil {
+		return s.HTTP, nil
+	}
+	if s.SQL != nil {
+		return s.SQL, nil
+	}
 
 	return nil, ErrInvalidSourceEmpty
 }
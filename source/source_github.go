@@ -4,48 +4,232 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"net/http"
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/bmatcuk/doublestar/v4"
 	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-ozzo/ozzo-validation/is"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/google/go-github/v52/github"
+	"github.com/hashicorp/go-cleanhttp"
 	"github.com/pkg/errors"
+	"github.com/samber/lo"
 	"golang.org/x/oauth2"
 	"golang.org/x/sync/errgroup"
+	"gopkg.in/guregu/null.v3"
 )
 
 type SourceGitHub struct {
-	Repos []string   `json:"repos"`
-	Files []string   `json:"files"`
-	Token Credential `json:"token"`
+	Repos     []string            `json:"repos"`
+	Files     []string            `json:"files"`
+	Token     Credential          `json:"token"`
+	App       *GitHubApp          `json:"app"`        // authenticate as a GitHub App instead of a token
+	BaseURL   string              `json:"base_url"`   // for GitHub Enterprise Server, defaults to github.com
+	UploadURL string              `json:"upload_url"` // for GitHub Enterprise Server, defaults to the base URL
+	Cache     string              `json:"cache"`      // directory to cache responses in between syncs
+	Ref       string              `json:"ref"`        // branch, tag or commit to read, defaults to each repo's default branch
+	Filter    *SourceGitHubFilter `json:"filter"`     // which of the repos to load from
+
+	// Metadata, if set, is the field we add to every entry with details of the repository
+	// it came from, such as its URL and topics.
+	Metadata null.String `json:"metadata"`
 }
 
 func (s SourceGitHub) Validate() error {
 	return validation.ValidateStruct(&s,
-		validation.Field(&s.Repos, validation.Each(
-			validation.Match(regexp.MustCompile("^[^/]/.+$")).
-				Error("repos must be of the form owner/repo, or owner/* for matching all repos under that organization"),
+		validation.Field(&s.Repos,
+			validation.Each(
+				validation.Match(regexp.MustCompile(`^[^/!\s]+/!?[^/\s]+$`)).
+					Error("repos must be of the form owner/repo, or a pattern like owner/* or owner/svc-* for matching repos under that organization, with a ! prefix to exclude repos"),
+				validation.By(func(value any) error {
+					if !doublestar.ValidatePattern(value.(string)) {
+						return fmt.Errorf("invalid pattern")
+					}
+
+					return nil
+				}),
+			),
+			validation.By(func(value any) error {
+				included := map[string]bool{}
+				for _, repo := range value.([]string) {
+					if owner, name, _ := strings.Cut(repo, "/"); !strings.HasPrefix(name, "!") {
+						included[owner] = true
+					}
+				}
+				for _, repo := range value.([]string) {
+					if owner, name, _ := strings.Cut(repo, "/"); strings.HasPrefix(name, "!") && !included[owner] {
+						return fmt.Errorf("'%s' excludes repos but no repos are included for %s, add a pattern like %s/*", repo, owner, owner)
+					}
+				}
+
+				return nil
+			}),
+		),
+		validation.Field(&s.App, validation.When(s.Token != "",
+			validation.Nil.Error("cannot use both a token and an app"),
+		)),
+		validation.Field(&s.BaseURL, is.URL),
+		validation.Field(&s.UploadURL, is.URL, validation.When(s.BaseURL == "",
+			validation.Empty.Error("requires a base_url"),
 		)),
+		validation.Field(&s.Filter),
+	)
+}
+
+// SourceGitHubFilter narrows down the repos we load from, which is most useful when
+// matching all the repos under an organization.
+type SourceGitHubFilter struct {
+	Include    []string  `json:"include"`    // regexes, one of which the owner/repo must match
+	Exclude    []string  `json:"exclude"`    // regexes, none of which the owner/repo can match
+	Topics     []string  `json:"topics"`     // the repo must have at least one of these topics
+	Visibility []string  `json:"visibility"` // any of public, private or internal
+	Archived   null.Bool `json:"archived"`   // if set, only archived (true) or unarchived (false) repos
+	Fork       null.Bool `json:"fork"`       // if set, only forks (true) or non-forks (false)
+}
+
+func (f SourceGitHubFilter) Validate() error {
+	return validation.ValidateStruct(&f,
+		validation.Field(&f.Include, validation.Each(validRegex)),
+		validation.Field(&f.Exclude, validation.Each(validRegex)),
+		validation.Field(&f.Visibility, validation.Each(validation.In("public", "private", "internal"))),
 	)
 }
 
+var validRegex = validation.By(func(value any) error {
+	_, err := regexp.Compile(value.(string))
+	return err
+})
+
+// Compile prepares the filter for matching repos, returning an error if any of the
+// patterns are invalid.
+func (f SourceGitHubFilter) Compile() (*GitHubRepoFilter, error) {
+	compileAll := func(field string, patterns []string) ([]*regexp.Regexp, error) {
+		compiled := []*regexp.Regexp{}
+		for _, pattern := range patterns {
+			regex, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("compiling filter %s pattern '%s'", field, pattern))
+			}
+
+			compiled = append(compiled, regex)
+		}
+
+		return compiled, nil
+	}
+
+	include, err := compileAll("include", f.Include)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := compileAll("exclude", f.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GitHubRepoFilter{filter: f, include: include, exclude: exclude}, nil
+}
+
+// GitHubRepoFilter is a compiled SourceGitHubFilter.
+type GitHubRepoFilter struct {
+	filter           SourceGitHubFilter
+	include, exclude []*regexp.Regexp
+}
+
+// Matches returns true if the repo passes the filter.
+func (f *GitHubRepoFilter) Matches(repo *github.Repository) bool {
+	matchesAny := func(patterns []*regexp.Regexp) bool {
+		return lo.SomeBy(patterns, func(pattern *regexp.Regexp) bool {
+			return pattern.MatchString(repo.GetFullName())
+		})
+	}
+
+	switch {
+	case len(f.include) > 0 && !matchesAny(f.include):
+		return false
+	case matchesAny(f.exclude):
+		return false
+	case len(f.filter.Topics) > 0 && len(lo.Intersect(f.filter.Topics, repo.Topics)) == 0:
+		return false
+	case len(f.filter.Visibility) > 0 && !lo.Contains(f.filter.Visibility, repo.GetVisibility()):
+		return false
+	case f.filter.Archived.Valid && f.filter.Archived.Bool != repo.GetArchived():
+		return false
+	case f.filter.Fork.Valid && f.filter.Fork.Bool != repo.GetFork():
+		return false
+	}
+
+	return true
+}
+
+// RepositoryMetadata is what we add to each entry when metadata is set, so the catalog
+// can refer to the repo without duplicating it in each file.
+func RepositoryMetadata(repo *github.Repository, ref string) map[string]any {
+	metadata := map[string]any{
+		"name":           repo.GetName(),
+		"full_name":      repo.GetFullName(),
+		"owner":          repo.GetOwner().GetLogin(),
+		"description":    repo.GetDescription(),
+		"url":            repo.GetHTMLURL(),
+		"topics":         lo.ToAnySlice(repo.Topics),
+		"visibility":     repo.GetVisibility(),
+		"archived":       repo.GetArchived(),
+		"fork":           repo.GetFork(),
+		"default_branch": repo.GetDefaultBranch(),
+		"ref":            ref,
+	}
+	if repo.PushedAt != nil {
+		metadata["pushed_at"] = repo.GetPushedAt().Format(time.RFC3339)
+	}
+
+	return metadata
+}
+
 func (s SourceGitHub) String() string {
 	return fmt.Sprintf("github (repos=%s files=%s)", s.Repos, s.Files)
 }
 
 func (s SourceGitHub) Load(ctx context.Context, logger kitlog.Logger) ([]*SourceEntry, error) {
-	client := github.NewClient(oauth2.NewClient(ctx, oauth2.StaticTokenSource(
+	var tokenSource oauth2.TokenSource = oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: string(s.Token)},
-	)))
+	)
+	if s.App != nil {
+		var err error
+		tokenSource, err = s.App.TokenSource(ctx, s.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var transport http.RoundTripper = &githubRateLimitTransport{
+		logger: logger,
+		base:   cleanhttp.DefaultPooledTransport(),
+	}
+	cache := githubCache{dir: s.Cache}
+	if s.Cache != "" {
+		transport = &githubConditionalTransport{cache: cache, base: transport}
+	}
+
+	client, err := newGitHubClient(s.BaseURL, s.UploadURL, &http.Client{
+		Transport: &oauth2.Transport{Source: tokenSource, Base: transport},
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	type Target struct {
-		Owner   string // e.g. incident-io
-		Repo    string // e.g. catalog-importer
-		Ref     string // e.g. main or master
-		Matches []*github.TreeEntry
+		Owner      string // e.g. incident-io
+		Repo       string // e.g. catalog-importer
+		Ref        string // e.g. main or master
+		Repository *github.Repository
+		Matches    []*github.TreeEntry
+
+		// Expanded is set if we found the repo by matching a pattern, rather than it being
+		// named in repos.
+		Expanded bool
 	}
 
 	// Use this whenever we're modifying structures that are race unsafe.
@@ -57,15 +241,38 @@ func (s SourceGitHub) Load(ctx context.Context, logger kitlog.Logger) ([]*Source
 		do()
 	}
 
-	// Expand any repo wildcards so we have a full list of repos for each owner we want to
-	// scan.
-	targets := []*Target{}
-	addTarget := func(logger kitlog.Logger, repo *github.Repository) {
+	var filter *GitHubRepoFilter
+	if s.Filter != nil {
+		filter, err = s.Filter.Compile()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Expand any repo patterns so we have a full list of repos for each owner we want to
+	// scan, using a map as patterns may overlap.
+	targets, seen := []*Target{}, map[string]bool{}
+	addTarget := func(logger kitlog.Logger, repo *github.Repository, expanded bool) {
+		if filter != nil && !filter.Matches(repo) {
+			logger.Log("msg", "skipping GitHub repo that doesn't match filter", "repo", repo.GetFullName())
+			return
+		}
+
 		synchronise(func() {
+			if _, ok := seen[repo.GetFullName()]; ok {
+				return
+			}
+			seen[repo.GetFullName()] = true
+
 			target := &Target{
-				Owner: repo.Owner.GetLogin(),
-				Repo:  repo.GetName(),
-				Ref:   repo.GetDefaultBranch(),
+				Owner:      repo.Owner.GetLogin(),
+				Repo:       repo.GetName(),
+				Ref:        repo.GetDefaultBranch(),
+				Repository: repo,
+				Expanded:   expanded,
+			}
+			if s.Ref != "" {
+				target.Ref = s.Ref
 			}
 
 			logger.Log("msg", "found GitHub repo",
@@ -78,18 +285,37 @@ func (s SourceGitHub) Load(ctx context.Context, logger kitlog.Logger) ([]*Source
 		g, ctx := errgroup.WithContext(ctx)
 		g.SetLimit(10)
 
+		// Group the patterns by owner, so we list each organization at most once.
+		owners, patterns := []string{}, map[string]*githubRepoPatterns{}
 		for _, providedRepo := range s.Repos {
 			components := strings.SplitN(providedRepo, "/", 2)
 			if len(components) != 2 {
 				return nil, fmt.Errorf("invalid format for repo must be owner/repo but got '%s'", providedRepo)
 			}
 
-			g.Go(func() error {
-				owner, repoNameOrWildcard := components[0], components[1]
+			owner, repoPattern := components[0], components[1]
+			if !doublestar.ValidatePattern(repoPattern) {
+				return nil, fmt.Errorf("invalid repo pattern '%s'", providedRepo)
+			}
+			if _, ok := patterns[owner]; !ok {
+				owners = append(owners, owner)
+				patterns[owner] = &githubRepoPatterns{}
+			}
+			if exclude, ok := strings.CutPrefix(repoPattern, "!"); ok {
+				patterns[owner].Exclude = append(patterns[owner].Exclude, exclude)
+			} else {
+				patterns[owner].Include = append(patterns[owner].Include, repoPattern)
+			}
+		}
+
+		for _, owner := range owners {
+			owner, patterns := owner, patterns[owner]
 
-				// We've found an owner/* repo, so we must find all the repos under this organisation.
-				if repoNameOrWildcard == "*" {
-					logger.Log("msg", "found repo wildcard, resolving repo list", "owner", owner)
+			// If any pattern has a wildcard, we must find all the repos under this
+			// organization and match them against the patterns.
+			if lo.SomeBy(patterns.Include, isGlob) {
+				g.Go(func() error {
+					logger.Log("msg", "found repo pattern, resolving repo list", "owner", owner)
 					opts := &github.RepositoryListByOrgOptions{
 						ListOptions: github.ListOptions{PerPage: 100},
 					}
@@ -100,26 +326,42 @@ func (s SourceGitHub) Load(ctx context.Context, logger kitlog.Logger) ([]*Source
 							return errors.Wrap(err, fmt.Sprintf("listing GitHub repos for organization '%s'", owner))
 						}
 						for _, repo := range page {
-							addTarget(logger, repo)
+							if patterns.Matches(repo.GetName()) {
+								addTarget(logger, repo, !lo.Contains(patterns.Include, repo.GetName()))
+							}
 						}
 						if resp.NextPage == 0 {
 							break
 						}
 						opts.Page = resp.NextPage
 					}
-				} else {
+
+					return nil
+				})
+
+				continue
+			}
+
+			for _, repoName := range patterns.Include {
+				repoName := repoName
+				if !patterns.Matches(repoName) {
+					logger.Log("msg", "skipping GitHub repo that is excluded", "owner", owner, "repo", repoName)
+					continue
+				}
+
+				g.Go(func() error {
 					// The repo is specified, so we just need to resolve it so we can fetch the
 					// default branch.
-					resolved, _, err := client.Repositories.Get(ctx, owner, repoNameOrWildcard)
+					resolved, _, err := client.Repositories.Get(ctx, owner, repoName)
 					if err != nil {
-						return errors.Wrap(err, fmt.Sprintf("accessing '%s/%s'", owner, repoNameOrWildcard))
+						return errors.Wrap(err, fmt.Sprintf("accessing '%s/%s'", owner, repoName))
 					}
 
-					addTarget(logger, resolved)
-				}
+					addTarget(logger, resolved, false)
 
-				return nil
-			})
+					return nil
+				})
+			}
 		}
 
 		if err := g.Wait(); err != nil {
@@ -144,6 +386,14 @@ func (s SourceGitHub) Load(ctx context.Context, logger kitlog.Logger) ([]*Source
 							"owner", target.Owner, "repo", target.Repo, "ref", target.Ref)
 						return nil
 					}
+					// When pinned to a ref, not every repo under an organization need have it. We
+					// can't tell a missing ref from a repo we can't access, so we only skip repos
+					// we matched by pattern: those named in repos must have the ref.
+					if s.Ref != "" && target.Expanded && notFound(err) {
+						logger.Log("msg", "GitHub repository has no such ref, skipping",
+							"owner", target.Owner, "repo", target.Repo, "ref", target.Ref)
+						return nil
+					}
 					return errors.Wrap(err, fmt.Sprintf("getting tree for '%s/%s' at ref %s", target.Owner, target.Repo, target.Ref))
 				}
 
@@ -187,22 +437,45 @@ func (s SourceGitHub) Load(ctx context.Context, logger kitlog.Logger) ([]*Source
 				match := target.Matches[jdx]
 
 				g.Go(func() error {
-					blob, _, err := client.Git.GetBlob(ctx, target.Owner, target.Repo, match.GetSHA())
-					if err != nil {
-						return errors.Wrap(err,
-							fmt.Sprintf("getting blob for '%s' from repo '%s/%s' at SHA %s", match.GetPath(), target.Owner, target.Repo, target.Ref))
+					var (
+						data []byte
+						ok   bool
+					)
+					if s.Cache != "" {
+						data, ok = cache.GetBlob(match.GetSHA())
 					}
 
-					if blob.GetEncoding() != "base64" {
-						logger.Log("msg", "found matching GitHub file but incorrect encoding meant it wasn't processed",
-							"owner", target.Owner, "repo", target.Repo, "ref", target.Ref, "path", match.GetPath())
-						return nil
+					if !ok {
+						blob, _, err := client.Git.GetBlob(ctx, target.Owner, target.Repo, match.GetSHA())
+						if err != nil {
+							return errors.Wrap(err,
+								fmt.Sprintf("getting blob for '%s' from repo '%s/%s' at SHA %s", match.GetPath(), target.Owner, target.Repo, target.Ref))
+						}
+
+						if blob.GetEncoding() != "base64" {
+							logger.Log("msg", "found matching GitHub file but incorrect encoding meant it wasn't processed",
+								"owner", target.Owner, "repo", target.Repo, "ref", target.Ref, "path", match.GetPath())
+							return nil
+						}
+
+						data, err = base64.StdEncoding.DecodeString(blob.GetContent())
+						if err != nil {
+							return errors.Wrap(err,
+								fmt.Sprintf("decoding base64 blob for '%s' from repo '%s/%s' at SHA %s", match.GetPath(), target.Owner, target.Repo, target.Ref))
+						}
+
+						if s.Cache != "" {
+							if err := cache.PutBlob(match.GetSHA(), data); err != nil {
+								return errors.Wrap(err, "caching GitHub blob")
+							}
+						}
 					}
 
-					data, err := base64.StdEncoding.DecodeString(blob.GetContent())
-					if err != nil {
-						return errors.Wrap(err,
-							fmt.Sprintf("decoding base64 blob for '%s' from repo '%s/%s' at SHA %s", match.GetPath(), target.Owner, target.Repo, target.Ref))
+					var fields map[string]any
+					if s.Metadata.Valid {
+						fields = map[string]any{
+							s.Metadata.String: RepositoryMetadata(target.Repository, target.Ref),
+						}
 					}
 
 					synchronise(func() {
@@ -211,7 +484,8 @@ func (s SourceGitHub) Load(ctx context.Context, logger kitlog.Logger) ([]*Source
 						entries = append(entries, &SourceEntry{
 							Origin:   fmt.Sprintf("github (repo=%s/%s path=%s)", target.Owner, target.Repo, match.GetPath()),
 							Filename: match.GetPath(),
-							Content:  []byte(data),
+							Content:  data,
+							Fields:   fields,
 						})
 					})
 
@@ -228,6 +502,39 @@ func (s SourceGitHub) Load(ctx context.Context, logger kitlog.Logger) ([]*Source
 	return entries, nil
 }
 
+// githubRepoPatterns are the repos we want from a single owner, as glob patterns matched
+// against the repo name.
+type githubRepoPatterns struct {
+	Include []string
+	Exclude []string // from patterns prefixed with !
+}
+
+// Matches returns true if the repo name matches any included pattern and no excluded
+// ones. Load checks the patterns are valid before matching against them.
+func (p githubRepoPatterns) Matches(name string) bool {
+	matchesAny := func(patterns []string) bool {
+		return lo.SomeBy(patterns, func(pattern string) bool {
+			match, _ := doublestar.Match(pattern, name)
+			return match
+		})
+	}
+
+	return matchesAny(p.Include) && !matchesAny(p.Exclude)
+}
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[{")
+}
+
+func notFound(err error) bool {
+	var errorResponse *github.ErrorResponse
+	if errors.As(err, &errorResponse) && errorResponse.Response != nil {
+		return errorResponse.Response.StatusCode == http.StatusNotFound
+	}
+
+	return false
+}
+
 func repositoryEmpty(err error) bool {
 	if err == nil {
 		return false
@@ -0,0 +1,417 @@
+package source_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/go-github/v52/github"
+	"github.com/incident-io/catalog-importer/v2/source"
+	"gopkg.in/guregu/null.v3"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SourceGitHub", func() {
+	repo := &github.Repository{
+		Name:          github.String("api"),
+		FullName:      github.String("acme/api"),
+		Owner:         &github.User{Login: github.String("acme")},
+		Description:   github.String("The API"),
+		HTMLURL:       github.String("https://github.com/acme/api"),
+		Topics:        []string{"service", "go"},
+		Visibility:    github.String("private"),
+		DefaultBranch: github.String("main"),
+		PushedAt:      &github.Timestamp{Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+	}
+
+	Describe("Load", func() {
+		var (
+			ctx       context.Context
+			server    *httptest.Server
+			key       *rsa.PrivateKey
+			mu        sync.Mutex
+			requests  []string
+			exchanges int
+			limited   bool // whether the next tree request is rate limited
+			src       source.SourceGitHub
+			entries   []*source.SourceEntry
+			err       error
+		)
+
+		writeJSON := func(w http.ResponseWriter, value any) {
+			w.Header().Set("Content-Type", "application/json")
+			Expect(json.NewEncoder(w).Encode(value)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			exchanges = 0
+			requests = []string{}
+			limited = false
+
+			key, err = rsa.GenerateKey(rand.Reader, 2048)
+			Expect(err).NotTo(HaveOccurred())
+
+			// Behave like a GitHub Enterprise Server, serving the API from /api/v3.
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+
+				authorization := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+				if r.URL.Path == "/api/v3/app/installations/42/access_tokens" {
+					Expect(r.Method).To(Equal(http.MethodPost))
+					token, err := jwt.ParseWithClaims(authorization, &jwt.StandardClaims{}, func(*jwt.Token) (any, error) {
+						return &key.PublicKey, nil
+					})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(token.Claims.(*jwt.StandardClaims).Issuer).To(Equal("123"))
+
+					exchanges++
+					writeJSON(w, map[string]any{
+						"token":      "installation-token",
+						"expires_at": time.Now().Add(time.Hour).Format(time.RFC3339),
+					})
+					return
+				}
+
+				Expect(authorization).To(Equal("installation-token"))
+				// Everything but blobs supports conditional requests.
+				notModified := false
+				if !strings.Contains(r.URL.Path, "/git/blobs/") {
+					etag := fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(r.URL.Path)))
+					notModified = r.Header.Get("If-None-Match") == etag
+					w.Header().Set("ETag", etag)
+				}
+
+				mu.Lock()
+				if notModified {
+					requests = append(requests, r.URL.Path+" (not modified)")
+				} else {
+					requests = append(requests, r.URL.Path)
+				}
+				mu.Unlock()
+
+				if notModified {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+
+				switch {
+				case r.URL.Path == "/api/v3/orgs/acme/repos":
+					orgRepos := []*github.Repository{repo}
+					for _, name := range []string{"svc-payments", "svc-legacy", "legacy-billing"} {
+						orgRepos = append(orgRepos, &github.Repository{
+							Name:          github.String(name),
+							FullName:      github.String("acme/" + name),
+							Owner:         repo.Owner,
+							DefaultBranch: github.String("main"),
+						})
+					}
+					writeJSON(w, orgRepos)
+				case r.URL.Path == "/api/v3/repos/acme/api":
+					writeJSON(w, repo)
+				case strings.HasSuffix(r.URL.Path, "/git/trees/main"):
+					if limited {
+						limited = false
+						w.Header().Set("Retry-After", "0")
+						w.WriteHeader(http.StatusForbidden)
+						writeJSON(w, map[string]any{"message": "You have exceeded a secondary rate limit."})
+						return
+					}
+					writeJSON(w, map[string]any{
+						"tree": []map[string]any{
+							{"path": "catalog-info.yaml", "type": "blob", "sha": "sha-api"},
+							{"path": "README.md", "type": "blob", "sha": "sha-readme"},
+						},
+					})
+				case r.URL.Path == "/api/v3/repos/acme/svc-payments/git/trees/v1":
+					writeJSON(w, map[string]any{
+						"tree": []map[string]any{
+							{"path": "catalog-info.yaml", "type": "blob", "sha": "sha-api"},
+						},
+					})
+				case strings.HasSuffix(r.URL.Path, "/git/blobs/sha-api"):
+					writeJSON(w, map[string]any{
+						"content":  base64.StdEncoding.EncodeToString([]byte("name: api\n")),
+						"encoding": "base64",
+					})
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			DeferCleanup(server.Close)
+
+			src = source.SourceGitHub{
+				BaseURL: server.URL,
+				App: &source.GitHubApp{
+					AppID:          "123",
+					InstallationID: "42",
+					PrivateKey: source.Credential(pem.EncodeToMemory(&pem.Block{
+						Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key),
+					})),
+				},
+				Repos: []string{"acme/api"},
+				Files: []string{"**/catalog-info.yaml"},
+			}
+		})
+
+		JustBeforeEach(func() {
+			entries, err = src.Load(ctx, kitlog.NewNopLogger())
+		})
+
+		It("authenticates as the app installation against the server", func() {
+			Expect(src.Validate()).To(Succeed())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Origin).To(Equal("github (repo=acme/api path=catalog-info.yaml)"))
+			Expect(string(entries[0].Content)).To(Equal("name: api\n"))
+			Expect(exchanges).To(Equal(1), "should reuse the installation token until it expires")
+		})
+
+		When("matching repos by pattern", func() {
+			BeforeEach(func() {
+				src.Repos = []string{"acme/svc-*", "acme/api", "acme/!*-legacy", "acme/!api"}
+			})
+
+			It("loads from matching repos that aren't excluded", func() {
+				Expect(src.Validate()).To(Succeed())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(entries).To(HaveLen(1))
+				Expect(entries[0].Origin).To(Equal("github (repo=acme/svc-payments path=catalog-info.yaml)"))
+				Expect(requests).To(Equal([]string{
+					"/api/v3/orgs/acme/repos",
+					"/api/v3/repos/acme/svc-payments/git/trees/main",
+					"/api/v3/repos/acme/svc-payments/git/blobs/sha-api",
+				}))
+			})
+		})
+
+		When("pinned to a ref", func() {
+			BeforeEach(func() {
+				src.Ref = "v1"
+			})
+
+			When("matching repos by pattern", func() {
+				BeforeEach(func() {
+					src.Repos = []string{"acme/svc-*"}
+				})
+
+				It("skips repos that don't have the ref", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(entries).To(HaveLen(1))
+					Expect(entries[0].Origin).To(Equal("github (repo=acme/svc-payments path=catalog-info.yaml)"))
+				})
+			})
+
+			When("a repo named in repos doesn't have the ref", func() {
+				BeforeEach(func() {
+					src.Repos = []string{"acme/svc-*", "acme/api"}
+				})
+
+				It("returns an error", func() {
+					Expect(err).To(MatchError(ContainSubstring("getting tree for 'acme/api' at ref v1")))
+				})
+			})
+		})
+
+		When("the filter is invalid", func() {
+			BeforeEach(func() {
+				src.Filter = &source.SourceGitHubFilter{Include: []string{"("}}
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(MatchError(ContainSubstring("compiling filter include pattern")))
+				Expect(requests).To(BeEmpty())
+			})
+		})
+
+		When("rate limited", func() {
+			BeforeEach(func() {
+				limited = true
+			})
+
+			It("waits and retries", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(entries).To(HaveLen(1))
+				Expect(requests).To(Equal([]string{
+					"/api/v3/repos/acme/api",
+					"/api/v3/repos/acme/api/git/trees/main",
+					"/api/v3/repos/acme/api/git/trees/main",
+					"/api/v3/repos/acme/api/git/blobs/sha-api",
+				}))
+			})
+		})
+
+		When("caching", func() {
+			BeforeEach(func() {
+				src.Cache = GinkgoT().TempDir()
+			})
+
+			It("reuses cached responses and blobs on the next load", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(requests).To(ContainElement("/api/v3/repos/acme/api/git/blobs/sha-api"))
+
+				requests = []string{}
+				entries, err = src.Load(ctx, kitlog.NewNopLogger())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(entries).To(HaveLen(1))
+				Expect(string(entries[0].Content)).To(Equal("name: api\n"))
+				Expect(entries[0].Origin).To(Equal("github (repo=acme/api path=catalog-info.yaml)"))
+				Expect(requests).To(Equal([]string{
+					"/api/v3/repos/acme/api (not modified)",
+					"/api/v3/repos/acme/api/git/trees/main (not modified)",
+				}))
+			})
+		})
+	})
+
+	Describe("Validate", func() {
+		It("accepts repos and patterns", func() {
+			src := source.SourceGitHub{
+				Repos: []string{"incident-io/catalog-importer", "acme/*", "acme/svc-*", "acme/!svc-legacy-*"},
+			}
+			Expect(src.Validate()).To(Succeed())
+		})
+
+		It("rejects invalid repos", func() {
+			for _, repo := range []string{"acme", "acme/api/extra", "!acme/api", "acme/svc-["} {
+				src := source.SourceGitHub{Repos: []string{repo}}
+				Expect(src.Validate()).To(MatchError(ContainSubstring("repos: (0: ")), repo)
+			}
+		})
+
+		It("rejects exclusions without anything to exclude from", func() {
+			src := source.SourceGitHub{Repos: []string{"acme/*", "other/!legacy-*"}}
+			Expect(src.Validate()).To(MatchError(ContainSubstring(
+				"'other/!legacy-*' excludes repos but no repos are included for other")))
+		})
+
+		It("rejects both a token and an app", func() {
+			src := source.SourceGitHub{
+				Token: "token",
+				App:   &source.GitHubApp{AppID: "123", InstallationID: "42", PrivateKey: "key"},
+			}
+			Expect(src.Validate()).To(MatchError(ContainSubstring("cannot use both a token and an app")))
+		})
+
+		It("rejects apps with invalid credentials", func() {
+			src := source.SourceGitHub{
+				App: &source.GitHubApp{AppID: "123", InstallationID: "abc", PrivateKey: "key"},
+			}
+			Expect(src.Validate()).To(MatchError(And(
+				ContainSubstring("installation_id: must be a number"),
+				ContainSubstring("private_key: must be a PEM encoded RSA private key"),
+			)))
+		})
+	})
+
+	Describe("SourceGitHubFilter", func() {
+		var filter source.SourceGitHubFilter
+
+		BeforeEach(func() {
+			filter = source.SourceGitHubFilter{}
+		})
+
+		matches := func(repo *github.Repository) bool {
+			compiled, err := filter.Compile()
+			Expect(err).NotTo(HaveOccurred())
+
+			return compiled.Matches(repo)
+		}
+
+		It("matches everything when empty", func() {
+			Expect(matches(repo)).To(BeTrue())
+			Expect(matches(&github.Repository{Archived: github.Bool(true), Fork: github.Bool(true)})).To(BeTrue())
+		})
+
+		It("includes and excludes by regex", func() {
+			filter.Include = []string{"^acme/"}
+			Expect(matches(repo)).To(BeTrue())
+
+			filter.Exclude = []string{"/api$"}
+			Expect(matches(repo)).To(BeFalse())
+		})
+
+		It("requires any of the topics", func() {
+			filter.Topics = []string{"library", "service"}
+			Expect(matches(repo)).To(BeTrue())
+
+			filter.Topics = []string{"library"}
+			Expect(matches(repo)).To(BeFalse())
+		})
+
+		It("filters by visibility", func() {
+			filter.Visibility = []string{"public"}
+			Expect(matches(repo)).To(BeFalse())
+		})
+
+		It("filters by archived and fork status", func() {
+			filter.Archived = null.BoolFrom(false)
+			filter.Fork = null.BoolFrom(false)
+			Expect(matches(repo)).To(BeTrue())
+
+			filter.Archived = null.BoolFrom(true)
+			Expect(matches(repo)).To(BeFalse())
+		})
+
+		It("rejects invalid config", func() {
+			filter.Include = []string{"("}
+			Expect(filter.Validate()).To(MatchError(ContainSubstring("include")))
+			_, err := filter.Compile()
+			Expect(err).To(MatchError(ContainSubstring("compiling filter include pattern '('")))
+
+			filter = source.SourceGitHubFilter{Visibility: []string{"secret"}}
+			Expect(filter.Validate()).To(MatchError(ContainSubstring("visibility")))
+		})
+	})
+
+	Describe("RepositoryMetadata", func() {
+		It("describes the repository", func() {
+			Expect(source.RepositoryMetadata(repo, "v1.0.0")).To(Equal(map[string]any{
+				"name":           "api",
+				"full_name":      "acme/api",
+				"owner":          "acme",
+				"description":    "The API",
+				"url":            "https://github.com/acme/api",
+				"topics":         []any{"service", "go"},
+				"visibility":     "private",
+				"archived":       false,
+				"fork":           false,
+				"default_branch": "main",
+				"ref":            "v1.0.0",
+				"pushed_at":      "2024-01-02T03:04:05Z",
+			}))
+		})
+	})
+})
+
+var _ = Describe("SourceEntry", func() {
+	It("adds fields to every parsed entry", func() {
+		entry := source.SourceEntry{
+			Filename: "entries.yaml",
+			Content:  []byte("- name: one\n- name: two\n"),
+			Fields:   map[string]any{"repository": map[string]any{"name": "api"}},
+		}
+
+		entries, err := entry.Parse()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(Equal([]source.Entry{
+			{"name": "one", "repository": map[string]any{"name": "api"}},
+			{"name": "two", "repository": map[string]any{"name": "api"}},
+		}))
+	})
+})
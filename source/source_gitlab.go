@@ -0,0 +1,339 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/bmatcuk/doublestar/v4"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-ozzo/ozzo-validation/is"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+type SourceGitLab struct {
+	BaseURL  string     `json:"base_url"` // defaults to https://gitlab.com
+	Token    Credential `json:"token"`
+	Projects []string   `json:"projects"`
+	Files    []string   `json:"files"`
+}
+
+func (s SourceGitLab) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.BaseURL, is.URL),
+		validation.Field(&s.Projects, validation.Required, validation.Each(
+			validation.Match(regexp.MustCompile(`^[^/*?\[{]+(/[^/]+)+$`)).
+				Error("projects must be of the form group/project, or a pattern like group/* or group/** for matching projects under that group"),
+		)),
+		validation.Field(&s.Files, validation.Required),
+	)
+}
+
+func (s SourceGitLab) String() string {
+	return fmt.Sprintf("gitlab (projects=%s files=%s)", s.Projects, s.Files)
+}
+
+// gitlabProject is the subset of the GitLab project we care about.
+type gitlabProject struct {
+	ID                int    `json:"id"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	DefaultBranch     string `json:"default_branch"`
+	EmptyRepo         bool   `json:"empty_repo"`
+}
+
+// gitlabTreeEntry is an entry in a GitLab repository tree.
+type gitlabTreeEntry struct {
+	ID   string `json:"id"` // the blob SHA
+	Type string `json:"type"`
+	Path string `json:"path"`
+}
+
+func (s SourceGitLab) Load(ctx context.Context, logger kitlog.Logger) ([]*SourceEntry, error) {
+	client := &gitlabClient{
+		baseURL: strings.TrimSuffix(s.baseURL(), "/") + "/api/v4",
+		token:   string(s.Token),
+		client:  cleanhttp.DefaultPooledClient(),
+	}
+
+	type Target struct {
+		Project *gitlabProject
+		Matches []gitlabTreeEntry
+	}
+
+	// Use this whenever we're modifying structures that are race unsafe.
+	var mu sync.Mutex
+	synchronise := func(do func()) {
+		defer mu.Unlock()
+		mu.Lock()
+
+		do()
+	}
+
+	// Expand any project patterns so we have a full list of projects to scan, using a map
+	// as patterns may overlap.
+	targets := map[int]*Target{}
+	addTarget := func(logger kitlog.Logger, project *gitlabProject) {
+		synchronise(func() {
+			if _, ok := targets[project.ID]; ok {
+				return
+			}
+
+			logger.Log("msg", "found GitLab project",
+				"project", project.PathWithNamespace, "ref", project.DefaultBranch)
+			targets[project.ID] = &Target{Project: project}
+		})
+	}
+
+	{
+		g, ctx := errgroup.WithContext(ctx)
+		g.SetLimit(10)
+
+		for _, providedProject := range s.Projects {
+			providedProject := providedProject
+
+			g.Go(func() error {
+				group, isPattern := gitlabGroupForPattern(providedProject)
+
+				// We've found a pattern, so list every project under the group (including its
+				// subgroups) and keep those that match.
+				if isPattern {
+					logger.Log("msg", "found project pattern, resolving project list", "group", group)
+					query := url.Values{
+						"include_subgroups": []string{"true"},
+						"per_page":          []string{"100"},
+					}
+					for page := "1"; page != ""; {
+						logger.Log("msg", "paging for projects...")
+						query.Set("page", page)
+
+						projects := []*gitlabProject{}
+						nextPage, err := client.Get(ctx, fmt.Sprintf("/groups/%s/projects", url.PathEscape(group)), query, &projects)
+						if err != nil {
+							return errors.Wrap(err, fmt.Sprintf("listing GitLab projects for group '%s'", group))
+						}
+						for _, project := range projects {
+							match, err := doublestar.Match(providedProject, project.PathWithNamespace)
+							if err != nil {
+								return errors.Wrap(err, "matching project pattern")
+							}
+							if match {
+								addTarget(logger, project)
+							}
+						}
+
+						page = nextPage
+					}
+				} else {
+					// The project is specified, so we just need to resolve it so we can fetch the
+					// default branch.
+					project := &gitlabProject{}
+					_, err := client.Get(ctx, fmt.Sprintf("/projects/%s", url.PathEscape(providedProject)), nil, project)
+					if err != nil {
+						return errors.Wrap(err, fmt.Sprintf("accessing '%s'", providedProject))
+					}
+
+					addTarget(logger, project)
+				}
+
+				return nil
+			})
+		}
+
+		if err := g.Wait(); err != nil {
+			return nil, err
+		}
+	}
+
+	{
+		g, ctx := errgroup.WithContext(ctx)
+		g.SetLimit(10)
+
+		for _, target := range targets {
+			target := target
+
+			g.Go(func() error {
+				project := target.Project
+				if project.EmptyRepo || project.DefaultBranch == "" {
+					logger.Log("msg", "GitLab repository is empty, skipping", "project", project.PathWithNamespace)
+					return nil
+				}
+
+				logger.Log("msg", "listing GitLab tree",
+					"project", project.PathWithNamespace, "ref", project.DefaultBranch)
+				query := url.Values{
+					"ref":       []string{project.DefaultBranch},
+					"recursive": []string{"true"},
+					"per_page":  []string{"100"},
+				}
+				for page := "1"; page != ""; {
+					query.Set("page", page)
+
+					tree := []gitlabTreeEntry{}
+					nextPage, err := client.Get(ctx, fmt.Sprintf("/projects/%d/repository/tree", project.ID), query, &tree)
+					if err != nil {
+						return errors.Wrap(err, fmt.Sprintf("getting tree for '%s' at ref %s", project.PathWithNamespace, project.DefaultBranch))
+					}
+
+					for _, pattern := range s.Files {
+						for _, treeEntry := range tree {
+							if treeEntry.Type != "blob" {
+								continue // we're only interested in files
+							}
+
+							match, err := doublestar.Match(pattern, treeEntry.Path)
+							if err != nil {
+								return errors.Wrap(err, "matching file pattern")
+							}
+
+							if match {
+								synchronise(func() {
+									target.Matches = append(target.Matches, treeEntry)
+								})
+							}
+						}
+					}
+
+					page = nextPage
+				}
+
+				return nil
+			})
+		}
+
+		if err := g.Wait(); err != nil {
+			return nil, err
+		}
+	}
+
+	entries := []*SourceEntry{}
+	{
+		g, ctx := errgroup.WithContext(ctx)
+		g.SetLimit(10)
+
+		for _, target := range targets {
+			project := target.Project
+
+			for jdx := range target.Matches {
+				match := target.Matches[jdx]
+
+				g.Go(func() error {
+					data, err := client.GetRaw(ctx, fmt.Sprintf("/projects/%d/repository/blobs/%s/raw", project.ID, match.ID))
+					if err != nil {
+						return errors.Wrap(err,
+							fmt.Sprintf("getting blob for '%s' from project '%s' at ref %s", match.Path, project.PathWithNamespace, project.DefaultBranch))
+					}
+
+					synchronise(func() {
+						logger.Log("msg", "found matching GitLab file",
+							"project", project.PathWithNamespace, "ref", project.DefaultBranch, "path", match.Path)
+						entries = append(entries, &SourceEntry{
+							Origin:   fmt.Sprintf("gitlab (project=%s path=%s)", project.PathWithNamespace, match.Path),
+							Filename: match.Path,
+							Content:  data,
+						})
+					})
+
+					return nil
+				})
+			}
+		}
+
+		if err := g.Wait(); err != nil {
+			return nil, err
+		}
+	}
+
+	return entries, nil
+}
+
+func (s SourceGitLab) baseURL() string {
+	if s.BaseURL == "" {
+		return "https://gitlab.com"
+	}
+
+	return s.BaseURL
+}
+
+// gitlabGroupForPattern returns the group we should list projects from if the given
+// project is a pattern, which is everything before the first segment with a wildcard.
+func gitlabGroupForPattern(project string) (group string, isPattern bool) {
+	segments := strings.Split(project, "/")
+	for idx, segment := range segments {
+		if strings.ContainsAny(segment, "*?[{") {
+			return strings.Join(segments[:idx], "/"), true
+		}
+	}
+
+	return "", false
+}
+
+// gitlabClient makes requests to the GitLab REST API.
+type gitlabClient struct {
+	baseURL string // e.g. https://gitlab.com/api/v4
+	token   string
+	client  *http.Client
+}
+
+// Get decodes the JSON response from the path into result, returning the next page if the
+// response is paginated.
+func (c *gitlabClient) Get(ctx context.Context, path string, query url.Values, result any) (nextPage string, err error) {
+	resp, err := c.do(ctx, path, query)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return "", errors.Wrap(err, "decoding response")
+	}
+
+	return resp.Header.Get("X-Next-Page"), nil
+}
+
+// GetRaw returns the response body from the path as-is.
+func (c *gitlabClient) GetRaw(ctx context.Context, path string) ([]byte, error) {
+	resp, err := c.do(ctx, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return io.ReadAll(resp.Body)
+}
+
+func (c *gitlabClient) do(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	endpoint := c.baseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "building request")
+	}
+	if c.token != "" {
+		req.Header.Set("PRIVATE-TOKEN", c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "making request")
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		return nil, fmt.Errorf("GET %s: received status %d: %s", path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,187 @@
+package source_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/incident-io/catalog-importer/v2/source"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SourceGitLab", func() {
+	var (
+		ctx      context.Context
+		server   *httptest.Server
+		mu       sync.Mutex
+		requests []string
+		src      source.SourceGitLab
+		entries  []*source.SourceEntry
+		err      error
+	)
+
+	type project struct {
+		ID                int    `json:"id"`
+		PathWithNamespace string `json:"path_with_namespace"`
+		DefaultBranch     string `json:"default_branch"`
+		EmptyRepo         bool   `json:"empty_repo"`
+	}
+	projects := []project{
+		{ID: 1, PathWithNamespace: "acme/api", DefaultBranch: "main"},
+		{ID: 2, PathWithNamespace: "acme/platform/worker", DefaultBranch: "master"},
+		{ID: 3, PathWithNamespace: "acme/empty", EmptyRepo: true},
+	}
+	trees := map[int][]map[string]string{
+		1: {
+			{"id": "sha-api", "type": "blob", "path": "catalog-info.yaml"},
+			{"id": "sha-readme", "type": "blob", "path": "README.md"},
+		},
+		2: {
+			{"id": "sha-dir", "type": "tree", "path": "deploy"},
+			{"id": "sha-worker", "type": "blob", "path": "deploy/catalog-info.yaml"},
+		},
+	}
+	blobs := map[string]string{
+		"sha-api":    "name: api\n",
+		"sha-worker": "name: worker\n",
+	}
+
+	writeJSON := func(w http.ResponseWriter, value any) {
+		w.Header().Set("Content-Type", "application/json")
+		Expect(json.NewEncoder(w).Encode(value)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		requests = []string{}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+
+			path := r.URL.EscapedPath()
+			mu.Lock()
+			requests = append(requests, path)
+			mu.Unlock()
+			if r.Header.Get("PRIVATE-TOKEN") != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			switch {
+			case path == "/api/v4/groups/acme/projects":
+				Expect(r.URL.Query().Get("include_subgroups")).To(Equal("true"))
+				// Serve a project per page, to check we follow pagination.
+				page := r.URL.Query().Get("page")
+				for idx, project := range projects {
+					if fmt.Sprint(idx+1) == page {
+						if idx+1 < len(projects) {
+							w.Header().Set("X-Next-Page", fmt.Sprint(idx+2))
+						}
+						writeJSON(w, []any{project})
+					}
+				}
+			case path == "/api/v4/projects/acme%2Fapi":
+				writeJSON(w, projects[0])
+			case strings.HasSuffix(path, "/repository/tree"):
+				var id int
+				fmt.Sscanf(path, "/api/v4/projects/%d/repository/tree", &id)
+				Expect(r.URL.Query().Get("recursive")).To(Equal("true"))
+				writeJSON(w, trees[id])
+			case strings.HasSuffix(path, "/raw"):
+				parts := strings.Split(path, "/")
+				w.Write([]byte(blobs[parts[len(parts)-2]]))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"message":"404 Project Not Found"}`))
+			}
+		}))
+		DeferCleanup(server.Close)
+
+		src = source.SourceGitLab{
+			BaseURL: server.URL,
+			Token:   "secret",
+			Files:   []string{"**/catalog-info.yaml"},
+		}
+	})
+
+	JustBeforeEach(func() {
+		entries, err = src.Load(ctx, kitlog.NewNopLogger())
+	})
+
+	origins := func() []string {
+		result := []string{}
+		for _, entry := range entries {
+			result = append(result, entry.Origin)
+		}
+		sort.Strings(result)
+
+		return result
+	}
+
+	When("loading a single project", func() {
+		BeforeEach(func() {
+			src.Projects = []string{"acme/api"}
+		})
+
+		It("loads matching files", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Origin).To(Equal("gitlab (project=acme/api path=catalog-info.yaml)"))
+			Expect(entries[0].Filename).To(Equal("catalog-info.yaml"))
+			Expect(string(entries[0].Content)).To(Equal("name: api\n"))
+		})
+	})
+
+	When("matching projects directly under a group", func() {
+		BeforeEach(func() {
+			src.Projects = []string{"acme/*"}
+		})
+
+		It("skips projects in subgroups", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(origins()).To(Equal([]string{
+				"gitlab (project=acme/api path=catalog-info.yaml)",
+			}))
+		})
+	})
+
+	When("matching projects in a group and all its subgroups", func() {
+		BeforeEach(func() {
+			src.Projects = []string{"acme/**", "acme/api"}
+		})
+
+		It("loads files from every project once, skipping empty repositories", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(origins()).To(Equal([]string{
+				"gitlab (project=acme/api path=catalog-info.yaml)",
+				"gitlab (project=acme/platform/worker path=deploy/catalog-info.yaml)",
+			}))
+			Expect(requests).NotTo(ContainElement("/api/v4/projects/3/repository/tree"))
+		})
+	})
+
+	When("the project doesn't exist", func() {
+		BeforeEach(func() {
+			src.Projects = []string{"acme/missing"}
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(MatchError(ContainSubstring("accessing 'acme/missing'")))
+			Expect(err).To(MatchError(ContainSubstring("received status 404")))
+		})
+	})
+
+	Describe("Validate", func() {
+		It("rejects patterns without a group", func() {
+			src.Projects = []string{"*/api"}
+			Expect(src.Validate()).To(MatchError(ContainSubstring("projects must be of the form group/project")))
+		})
+	})
+})
@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"reflect"
 	"strings"
 
 	kitlog "github.com/go-kit/kit/log"
@@ -14,7 +13,7 @@ import (
 	"github.com/machinebox/graphql"
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
-	"github.com/stretchr/objx"
+	"golang.org/x/oauth2"
 	"gopkg.in/guregu/null.v3"
 )
 
@@ -26,6 +25,10 @@ type SourceGraphQL struct {
 	Paginate struct {
 		NextCursor null.String `json:"next_cursor"`
 	} `json:"paginate,omitempty"`
+
+	// GitHubApp authenticates as a GitHub App installation, for when the endpoint is
+	// GitHub's GraphQL API.
+	GitHubApp *GitHubApp `json:"github_app"`
 }
 
 func (s SourceGraphQL) Validate() error {
@@ -53,6 +56,7 @@ func (s SourceGraphQL) Validate() error {
 				return nil
 			}),
 		),
+		validation.Field(&s.GitHubApp),
 	)
 }
 
@@ -61,8 +65,25 @@ func (s SourceGraphQL) String() string {
 }
 
 func (s SourceGraphQL) Load(ctx context.Context, logger kitlog.Logger) ([]*SourceEntry, error) {
+	httpClient := cleanhttp.DefaultClient()
+	if s.GitHubApp != nil {
+		baseURL, err := gitHubBaseURLForGraphQL(string(s.Endpoint))
+		if err != nil {
+			return nil, err
+		}
+		tokenSource, err := s.GitHubApp.TokenSource(ctx, baseURL)
+		if err != nil {
+			return nil, err
+		}
+
+		httpClient.Transport = &oauth2.Transport{
+			Source: tokenSource,
+			Base:   httpClient.Transport,
+		}
+	}
+
 	client := graphql.NewClient(string(s.Endpoint),
-		graphql.WithHTTPClient(cleanhttp.DefaultClient()))
+		graphql.WithHTTPClient(httpClient))
 	client.Log = func(msg string) {
 		logger.Log("msg", msg)
 	}
@@ -107,23 +128,14 @@ func (s SourceGraphQL) Load(ctx context.Context, logger kitlog.Logger) ([]*Sourc
 			return nil, errors.Wrap(err, "failed to execute GraphQL query")
 		}
 
-		resp, err := objx.FromJSON(string(data))
+		resp, content, resultCount, err := extractResult(data, s.Result)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to parse GraphQL response")
 		}
 
-		result := resp.Value().Data()
-		if s.Result.Valid {
-			result = resp.Get(s.Result.String).Data()
-		}
-
-		if reflect.TypeOf(result).Kind() != reflect.Slice {
-			return nil, errors.Wrap(err, "result is not a slice of values")
-		}
-
-		content, err := json.Marshal(result)
-		if err != nil {
-			return nil, errors.Wrap(err, "marshalling result into JSON")
+		// An empty page has nothing to parse, and means we've seen every result.
+		if resultCount == 0 {
+			return entries, nil
 		}
 
 		entries = append(entries, &SourceEntry{
@@ -131,9 +143,7 @@ func (s SourceGraphQL) Load(ctx context.Context, logger kitlog.Logger) ([]*Sourc
 			Content: content,
 		})
 
-		resultCount := reflect.ValueOf(result).Len()
-
-		if resultCount == 0 || !shouldPaginate {
+		if !shouldPaginate {
 			return entries, nil
 		}
 
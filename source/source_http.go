@@ -0,0 +1,251 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-ozzo/ozzo-validation/is"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v3"
+)
+
+const (
+	HTTPPaginateOffset  = "offset"   // ?offset=N&limit=M, offset incremented by results seen
+	HTTPPaginatePage    = "page"     // ?page=N, incremented once per page
+	HTTPPaginateLink    = "link"     // follow rel="next" from the Link header
+	HTTPPaginateNextURL = "next_url" // follow a URL found in the response body
+	HTTPPaginateCursor  = "cursor"   // ?cursor=X, where X is found in the response body
+)
+
+type SourceHTTP struct {
+	Endpoint Credential            `json:"endpoint"` // https://api.example.com/v1/services
+	Method   string                `json:"method"`
+	Headers  map[string]Credential `json:"headers"`
+	Body     null.String           `json:"body"`
+	Result   null.String           `json:"result"`
+	Paginate *SourceHTTPPaginate   `json:"paginate,omitempty"`
+}
+
+type SourceHTTPPaginate struct {
+	Strategy    string      `json:"strategy"`
+	PageSize    int         `json:"page_size"`    // if set, sent as the limit parameter
+	LimitParam  string      `json:"limit_param"`  // defaults to limit
+	OffsetParam string      `json:"offset_param"` // defaults to offset
+	PageParam   string      `json:"page_param"`   // defaults to page
+	FirstPage   null.Int    `json:"first_page"`   // defaults to 1
+	CursorParam string      `json:"cursor_param"` // defaults to cursor
+	NextCursor  null.String `json:"next_cursor"`  // where to find the next cursor in the response
+	NextURL     null.String `json:"next_url"`     // where to find the next URL in the response
+}
+
+func (s SourceHTTP) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Endpoint,
+			validation.Required.Error("must provide the HTTP endpoint"),
+			is.URL,
+		),
+		validation.Field(&s.Method,
+			validation.In(http.MethodGet, http.MethodPost).Error("method must be either GET or POST"),
+		),
+		validation.Field(&s.Paginate),
+	)
+}
+
+func (p SourceHTTPPaginate) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Strategy,
+			validation.Required.Error("must provide a pagination strategy"),
+			validation.In(HTTPPaginateOffset, HTTPPaginatePage, HTTPPaginateLink, HTTPPaginateNextURL, HTTPPaginateCursor).
+				Error("strategy must be one of offset, page, link, next_url or cursor"),
+		),
+		validation.Field(&p.PageSize, validation.Min(0)),
+		validation.Field(&p.NextCursor,
+			validation.Required.When(p.Strategy == HTTPPaginateCursor).Error("must provide next_cursor when using cursor pagination"),
+		),
+		validation.Field(&p.NextURL,
+			validation.Required.When(p.Strategy == HTTPPaginateNextURL).Error("must provide next_url when using next_url pagination"),
+		),
+	)
+}
+
+func (s SourceHTTP) String() string {
+	return fmt.Sprintf("http (endpoint=%s)", s.Endpoint)
+}
+
+func (s SourceHTTP) Load(ctx context.Context, logger kitlog.Logger) ([]*SourceEntry, error) {
+	client := cleanhttp.DefaultClient()
+
+	endpoint, err := url.Parse(string(s.Endpoint))
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing HTTP endpoint")
+	}
+
+	method := http.MethodGet
+	if s.Method != "" {
+		method = s.Method
+	}
+
+	paginate := SourceHTTPPaginate{}
+	if s.Paginate != nil {
+		paginate = *s.Paginate
+	}
+
+	withDefault := func(value, defaultValue string) string {
+		if value == "" {
+			return defaultValue
+		}
+
+		return value
+	}
+
+	var (
+		nextURL = endpoint
+		page    = int(paginate.FirstPage.ValueOrZero())
+		offset  = 0
+		cursor  = ""
+	)
+	if !paginate.FirstPage.Valid {
+		page = 1
+	}
+
+	entries := []*SourceEntry{}
+	for {
+		// The link and next_url strategies give us the full URL of the next page, while the
+		// others require us to set query parameters on the original endpoint.
+		reqURL := *nextURL
+		query := reqURL.Query()
+		if paginate.PageSize > 0 {
+			query.Set(withDefault(paginate.LimitParam, "limit"), fmt.Sprintf("%d", paginate.PageSize))
+		}
+		switch paginate.Strategy {
+		case HTTPPaginateOffset:
+			query.Set(withDefault(paginate.OffsetParam, "offset"), fmt.Sprintf("%d", offset))
+		case HTTPPaginatePage:
+			query.Set(withDefault(paginate.PageParam, "page"), fmt.Sprintf("%d", page))
+		case HTTPPaginateCursor:
+			if cursor != "" {
+				query.Set(withDefault(paginate.CursorParam, "cursor"), cursor)
+			}
+		}
+		reqURL.RawQuery = query.Encode()
+
+		var body io.Reader
+		if s.Body.Valid {
+			body = strings.NewReader(s.Body.String)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, reqURL.String(), body)
+		if err != nil {
+			return nil, errors.Wrap(err, "building HTTP request")
+		}
+		if s.Body.Valid {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		for key, value := range s.Headers {
+			req.Header.Set(key, string(value))
+		}
+
+		logger.Log("msg", "issuing HTTP request", "method", method,
+			"page", page, "offset", offset, "cursor", cursor)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, errors.Wrap(err, "fetching HTTP entries")
+		}
+
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "reading HTTP response")
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return nil, fmt.Errorf("received error from HTTP endpoint: %s", resp.Status)
+		}
+
+		parsed, content, resultCount, err := extractResult(data, s.Result)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse HTTP response")
+		}
+
+		// An empty page has nothing to parse, and means we've seen every result.
+		if resultCount == 0 {
+			return entries, nil
+		}
+
+		entries = append(entries, &SourceEntry{
+			Origin:  s.String(),
+			Content: content,
+		})
+
+		switch paginate.Strategy {
+		case HTTPPaginateOffset:
+			offset += resultCount
+		case HTTPPaginatePage:
+			page += 1
+		case HTTPPaginateLink, HTTPPaginateNextURL:
+			var next string
+			if paginate.Strategy == HTTPPaginateLink {
+				next = parseLinkNext(resp.Header.Values("Link"))
+			} else {
+				next = parsed.Get(paginate.NextURL.String).Str()
+			}
+			if next == "" {
+				return entries, nil // no more pages
+			}
+
+			nextParsed, err := url.Parse(next)
+			if err != nil {
+				return nil, errors.Wrap(err, "parsing next page URL")
+			}
+			nextURL = reqURL.ResolveReference(nextParsed)
+			if nextURL.String() == reqURL.String() {
+				return nil, fmt.Errorf("next page URL is the same as the current page: %s", nextURL)
+			}
+		case HTTPPaginateCursor:
+			next := parsed.Get(paginate.NextCursor.String).Str()
+			if next == "" {
+				return entries, nil // no more pages
+			}
+			if next == cursor {
+				return nil, fmt.Errorf("next cursor at '%s' is the same as the current cursor", paginate.NextCursor.String)
+			}
+
+			cursor = next
+		default:
+			return entries, nil // not paginating
+		}
+	}
+}
+
+// parseLinkNext finds the URL of the next page from RFC 8288 Link headers, like:
+//
+//	Link: <https://api.example.com/items?page=2>; rel="next", <https://...>; rel="last"
+func parseLinkNext(headers []string) string {
+	for _, header := range headers {
+		for _, link := range strings.Split(header, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.Trim(strings.TrimSpace(parts[0]), "<>")
+
+			for _, param := range parts[1:] {
+				key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if !ok || !strings.EqualFold(key, "rel") {
+					continue
+				}
+
+				for _, rel := range strings.Fields(strings.Trim(value, `"`)) {
+					if strings.EqualFold(rel, "next") {
+						return target
+					}
+				}
+			}
+		}
+	}
+
+	return ""
+}
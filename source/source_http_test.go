@@ -0,0 +1,243 @@
+package source_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/incident-io/catalog-importer/v2/source"
+	"gopkg.in/guregu/null.v3"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SourceHTTP", func() {
+	var (
+		ctx     context.Context
+		server  *httptest.Server
+		handler http.HandlerFunc
+		src     source.SourceHTTP
+		entries []source.Entry
+		err     error
+	)
+
+	// Five items, served in pages of two.
+	items := []map[string]any{
+		{"name": "one"}, {"name": "two"}, {"name": "three"}, {"name": "four"}, {"name": "five"},
+	}
+	pageOf := func(offset int) []map[string]any {
+		if offset >= len(items) {
+			return []map[string]any{}
+		}
+
+		end := offset + 2
+		if end > len(items) {
+			end = len(items)
+		}
+
+		return items[offset:end]
+	}
+	writeJSON := func(w http.ResponseWriter, value any) {
+		w.Header().Set("Content-Type", "application/json")
+		Expect(json.NewEncoder(w).Encode(value)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler(w, r)
+		}))
+		DeferCleanup(server.Close)
+
+		src = source.SourceHTTP{
+			Endpoint: source.Credential(server.URL + "/items"),
+		}
+	})
+
+	JustBeforeEach(func() {
+		var sourceEntries []*source.SourceEntry
+		sourceEntries, err = src.Load(ctx, kitlog.NewNopLogger())
+
+		entries = []source.Entry{}
+		for _, sourceEntry := range sourceEntries {
+			parsed, parseErr := sourceEntry.Parse()
+			Expect(parseErr).NotTo(HaveOccurred())
+			entries = append(entries, parsed...)
+		}
+	})
+
+	names := func() []string {
+		result := []string{}
+		for _, entry := range entries {
+			result = append(result, entry["name"].(string))
+		}
+
+		return result
+	}
+
+	allNames := []string{"one", "two", "three", "four", "five"}
+
+	When("not paginating", func() {
+		BeforeEach(func() {
+			src.Headers = map[string]source.Credential{"Authorization": "Bearer token"}
+			src.Result = null.StringFrom("data.items")
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Header.Get("Authorization")).To(Equal("Bearer token"))
+				writeJSON(w, map[string]any{"data": map[string]any{"items": items}})
+			}
+		})
+
+		It("returns all entries at the result path", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names()).To(Equal(allNames))
+		})
+	})
+
+	When("the response is a plain array", func() {
+		BeforeEach(func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, items)
+			}
+		})
+
+		It("returns all entries", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names()).To(Equal(allNames))
+		})
+	})
+
+	When("the endpoint returns an error", func() {
+		BeforeEach(func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		})
+
+		It("errors", func() {
+			Expect(err).To(MatchError(ContainSubstring("500 Internal Server Error")))
+		})
+	})
+
+	When("paginating by offset", func() {
+		BeforeEach(func() {
+			src.Paginate = &source.SourceHTTPPaginate{Strategy: source.HTTPPaginateOffset, PageSize: 2}
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.URL.Query().Get("limit")).To(Equal("2"))
+				offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+				writeJSON(w, pageOf(offset))
+			}
+		})
+
+		It("returns entries from every page", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names()).To(Equal(allNames))
+		})
+	})
+
+	When("paginating by page number", func() {
+		BeforeEach(func() {
+			src.Paginate = &source.SourceHTTPPaginate{
+				Strategy:  source.HTTPPaginatePage,
+				PageParam: "p",
+				FirstPage: null.IntFrom(0),
+			}
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				page, _ := strconv.Atoi(r.URL.Query().Get("p"))
+				writeJSON(w, pageOf(page*2))
+			}
+		})
+
+		It("returns entries from every page", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names()).To(Equal(allNames))
+		})
+	})
+
+	When("paginating with the Link header", func() {
+		BeforeEach(func() {
+			src.Paginate = &source.SourceHTTPPaginate{Strategy: source.HTTPPaginateLink}
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				offset, _ := strconv.Atoi(r.URL.Query().Get("from"))
+				if offset+2 < len(items) {
+					w.Header().Add("Link", fmt.Sprintf(`</items?from=%d>; rel="next", </items?from=4>; rel="last"`, offset+2))
+				}
+				writeJSON(w, pageOf(offset))
+			}
+		})
+
+		It("follows the next links", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names()).To(Equal(allNames))
+		})
+	})
+
+	When("paginating with a next URL in the body", func() {
+		BeforeEach(func() {
+			src.Result = null.StringFrom("results")
+			src.Paginate = &source.SourceHTTPPaginate{
+				Strategy: source.HTTPPaginateNextURL,
+				NextURL:  null.StringFrom("meta.next"),
+			}
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				offset, _ := strconv.Atoi(r.URL.Query().Get("from"))
+				next := ""
+				if offset+2 < len(items) {
+					next = fmt.Sprintf("%s/items?from=%d", server.URL, offset+2)
+				}
+				writeJSON(w, map[string]any{"results": pageOf(offset), "meta": map[string]any{"next": next}})
+			}
+		})
+
+		It("follows the next URLs", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names()).To(Equal(allNames))
+		})
+	})
+
+	When("paginating with a cursor", func() {
+		BeforeEach(func() {
+			src.Result = null.StringFrom("results")
+			src.Paginate = &source.SourceHTTPPaginate{
+				Strategy:    source.HTTPPaginateCursor,
+				CursorParam: "after",
+				NextCursor:  null.StringFrom("cursor"),
+			}
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				offset, _ := strconv.Atoi(r.URL.Query().Get("after"))
+				cursor := ""
+				if offset+2 < len(items) {
+					cursor = strconv.Itoa(offset + 2)
+				}
+				writeJSON(w, map[string]any{"results": pageOf(offset), "cursor": cursor})
+			}
+		})
+
+		It("returns entries from every page", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names()).To(Equal(allNames))
+		})
+	})
+})
+
+var _ = Describe("SourceHTTP validation", func() {
+	It("requires next_cursor for cursor pagination", func() {
+		err := source.SourceHTTP{
+			Endpoint: "https://example.com/items",
+			Paginate: &source.SourceHTTPPaginate{Strategy: source.HTTPPaginateCursor},
+		}.Validate()
+		Expect(err).To(MatchError(ContainSubstring("must provide next_cursor")))
+	})
+
+	It("rejects unknown strategies", func() {
+		err := source.SourceHTTP{
+			Endpoint: "https://example.com/items",
+			Paginate: &source.SourceHTTPPaginate{Strategy: "magic"},
+		}.Validate()
+		Expect(err).To(MatchError(ContainSubstring("strategy must be one of")))
+	})
+})
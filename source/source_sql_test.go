@@ -0,0 +1,108 @@
+package source_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/incident-io/catalog-importer/v2/source"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SourceSQL", func() {
+	var (
+		ctx     context.Context
+		src     source.SourceSQL
+		entries []source.Entry
+		err     error
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		dsn := filepath.Join(GinkgoT().TempDir(), "catalog.db")
+		db, err := sql.Open("sqlite", dsn)
+		Expect(err).NotTo(HaveOccurred())
+		defer db.Close()
+
+		_, err = db.Exec(`
+create table services (
+  id integer primary key,
+  name text not null,
+  owner text,
+  cost numeric,
+  metadata json
+);
+
+insert into services (id, name, owner, cost, metadata) values
+  (1, 'api', 'core', 12.5, '{"tier":1,"tags":["http"]}'),
+  (2, 'worker', null, null, null);
+`)
+		Expect(err).NotTo(HaveOccurred())
+
+		src = source.SourceSQL{
+			Driver: source.SQLDriverSQLite,
+			DSN:    source.Credential(dsn),
+			Query:  "select id, name, owner, cost, metadata from services order by id",
+		}
+	})
+
+	JustBeforeEach(func() {
+		var sourceEntries []*source.SourceEntry
+		sourceEntries, err = src.Load(ctx, kitlog.NewNopLogger())
+
+		entries = []source.Entry{}
+		for _, sourceEntry := range sourceEntries {
+			parsed, parseErr := sourceEntry.Parse()
+			Expect(parseErr).NotTo(HaveOccurred())
+			entries = append(entries, parsed...)
+		}
+	})
+
+	It("returns an entry per row, keyed by column name", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(Equal([]source.Entry{
+			{
+				"id":    float64(1),
+				"name":  "api",
+				"owner": "core",
+				"cost":  float64(12.5),
+				"metadata": map[string]any{
+					"tier": float64(1),
+					"tags": []any{"http"},
+				},
+			},
+			{
+				"id":       float64(2),
+				"name":     "worker",
+				"owner":    nil,
+				"cost":     nil,
+				"metadata": nil,
+			},
+		}))
+	})
+
+	When("the query returns no rows", func() {
+		BeforeEach(func() {
+			src.Query = "select id, name from services where id > 100"
+		})
+
+		It("returns no entries", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(BeEmpty())
+		})
+	})
+
+	When("the query is invalid", func() {
+		BeforeEach(func() {
+			src.Query = "select * from missing"
+		})
+
+		It("errors", func() {
+			Expect(err).To(MatchError(ContainSubstring("running SQL query")))
+		})
+	})
+})